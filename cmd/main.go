@@ -1,34 +1,202 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"time"
+
+	"github.com/shopspring/decimal"
 	"github.com/sub0xdai/n0xtilus/internal/config"
-	"github.com/sub0xdai/n0xtilus/internal/api"
+	_ "github.com/sub0xdai/n0xtilus/internal/api"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
 	"github.com/sub0xdai/n0xtilus/internal/services"
+	"github.com/sub0xdai/n0xtilus/internal/services/paper_trading"
 	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
 	"github.com/sub0xdai/n0xtilus/internal/ui"
+	"github.com/sub0xdai/n0xtilus/internal/ui/styles"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 type mainModel struct {
 	dashboard    *ui.PositionDashboard
 	tradeWidget  *ui.TradeInputWidget
-	client       *api.APIClient
+	orderResult  *ui.OrderResult
+	actionResult *ui.PositionActionResult
+	executing    bool
+	client       exchange.Exchange
+	pairs        []string
+	favourites   []string
+	instruments  *exchange.InstrumentCache
+	stream       *marketdata.Stream // nil without a market data source
+	orders       *services.OrderStateManager
+	positions    *services.PositionService
+	stops        *services.StopManager
 	orderService *services.OrderService
-	riskPercent  float64
+	commands     *services.CommandQueue // shared by trade entry and position actions
+	fillTimeout  time.Duration
+	cancelEntry  bool // cancel entries not filled within fillTimeout
+	riskCalc     risk_calculator.RiskCalculatorService
+	riskSettings ui.RiskSettings
+}
+
+// balanceMsg carries the account balance fetched before trade entry
+type balanceMsg struct {
+	balance decimal.Decimal
+	err     error
+}
+
+// tradeResultMsg is sent when a trade placed in the background finishes
+type tradeResultMsg struct {
+	result services.TradeResult
+	err    error
+}
+
+// positionActionResultMsg is sent when position actions executed in the
+// background finish
+type positionActionResultMsg struct {
+	results []services.PositionActionResult
 }
 
 func (m mainModel) Init() tea.Cmd {
-	return m.dashboard.Init()
+	cmds := []tea.Cmd{m.dashboard.Init(), listenPositions(m.positions)}
+	if m.stream != nil {
+		cmds = append(cmds, listenMarketData(m.stream))
+	}
+	return tea.Batch(cmds...)
+}
+
+// listenPositions waits for the next portfolio snapshot and delivers it as
+// a message
+func listenPositions(positions *services.PositionService) tea.Cmd {
+	return func() tea.Msg {
+		return <-positions.Snapshots()
+	}
+}
+
+// listenMarketData waits for the next market data update or connection
+// change and delivers it as a message
+func listenMarketData(stream *marketdata.Stream) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case update := <-stream.Updates():
+			return update
+		case status := <-stream.Status():
+			return status
+		}
+	}
+}
+
+// subscribe streams the market data of symbol so its positions reprice live
+func (m mainModel) subscribe(symbol string) {
+	if err := m.stream.Subscribe(symbol); err != nil {
+		log.Printf("Failed to subscribe to %s market data: %v", symbol, err)
+	}
 }
 
 func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
-	switch msg.(type) {
+	switch msg := msg.(type) {
+	case marketdata.Update:
+		m.positions.ApplyMarketData(msg)
+		m.stops.ApplyMarketData(msg)
+		cmds = append(cmds, listenMarketData(m.stream))
+	case marketdata.Status:
+		// Handled by the dashboard below, keep listening
+		if msg.Err != nil {
+			log.Printf("Market data: %v", msg.Err)
+		}
+		cmds = append(cmds, listenMarketData(m.stream))
+	case services.PortfolioSnapshot:
+		// Handled by the dashboard below. Every open position streams its
+		// mark price, subscribing is a no-op for symbols already streaming.
+		if m.stream != nil {
+			for _, p := range msg.Positions {
+				m.subscribe(p.Symbol)
+			}
+		}
+		cmds = append(cmds, listenPositions(m.positions))
 	case ui.ExecuteTradeMsg:
-		m.tradeWidget = ui.NewTradeInputWidget([]string{"BTC/USD", "ETH/USD"}) // TODO: Get from API
+		// Position sizing needs the balance and trading rules, fetch them
+		// before opening trade entry
+		client, instruments := m.client, m.instruments
+		return m, func() tea.Msg {
+			if err := instruments.Refresh(); err != nil {
+				log.Printf("Failed to refresh instruments: %v", err)
+			}
+			balance, err := client.GetBalance()
+			return balanceMsg{balance: balance, err: err}
+		}
+	case ui.PositionActionMsg:
+		executor := services.NewPositionActionExecutor(m.orderService, m.positions, m.riskSettings.RiskPercent)
+		executor.SetCommandQueue(m.commands)
+		executor.SetStopManager(m.stops)
+		m.executing = true
+		return m, func() tea.Msg {
+			return positionActionResultMsg{results: executor.Execute(msg.Actions...)}
+		}
+	case positionActionResultMsg:
+		m.executing = false
+		for _, result := range msg.results {
+			if result.Err != nil {
+				log.Printf("Position %s %s failed: %v", result.Action.Kind, result.Action.Symbol, result.Err)
+				continue
+			}
+			if result.Action.Kind.MovesStop() {
+				log.Printf("Position %s %s: stop=%s at %s trailing=%s", result.Action.Kind, result.Action.Symbol,
+					result.Stop.OrderID, result.Stop.Price, result.Stop.Trailing)
+				continue
+			}
+			log.Printf("Position %s %s: orders=%v %s qty=%s", result.Action.Kind, result.Action.Symbol,
+				result.OrderIDs, result.Side, result.Quantity)
+		}
+		m.actionResult = ui.NewPositionActionResult(msg.results)
+		return m, nil
+	case balanceMsg:
+		if msg.err != nil {
+			log.Printf("Failed to get balance: %v", msg.err)
+			m.orderResult = ui.NewOrderResult()
+			m.orderResult.SetError("", msg.err)
+			return m, nil
+		}
+		m.tradeWidget = ui.NewTradeInputWidget(m.pairs, m.favourites, msg.balance, m.riskCalc, m.riskSettings)
 		return m, m.tradeWidget.Init()
+	case tradeResultMsg:
+		m.executing = false
+		m.orderResult = ui.NewOrderResult()
+		if msg.err != nil {
+			log.Printf("Trade failed: %v", msg.err)
+			m.orderResult.SetError(msg.result.Symbol, msg.err)
+		} else {
+			log.Printf("Trade executed: order=%s stop=%s tps=%v %s %s qty=%s entry=%s",
+				msg.result.OrderID, msg.result.StopLossOrderID, msg.result.TakeProfitOrderIDs, msg.result.Side,
+				msg.result.Symbol, msg.result.Quantity, msg.result.EntryPrice)
+			m.orderResult.Update(msg.result.OrderID, msg.result.Side, msg.result.Symbol,
+				msg.result.Quantity, msg.result.EntryPrice, msg.result.Balance)
+			m.orderResult.SetExits(msg.result.StopLossOrderID, msg.result.TakeProfitOrderIDs, msg.result.Warnings)
+			m.positions.SetLeverage(msg.result.Symbol, msg.result.Leverage)
+			m.stops.Track(msg.result)
+			for _, warning := range msg.result.Warnings {
+				log.Printf("Trade warning: %s", warning)
+			}
+		}
+		return m, nil
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return m, tea.Quit
+		}
+		if m.executing {
+			return m, nil
+		}
+		if m.orderResult != nil || m.actionResult != nil {
+			// Any key dismisses the result
+			m.orderResult = nil
+			m.actionResult = nil
+			return m, nil
+		}
 	}
 
 	// Handle updates based on current active component
@@ -42,18 +210,16 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if widget, ok := updatedModel.(*ui.TradeInputWidget); ok {
 			m.tradeWidget = widget
 			if widget.IsComplete() {
+				m.tradeWidget = nil
 				if widget.Confirmed {
-					// Process trade
-					pairIdx, entry, stop, leverage, err := widget.GetInputs()
+					cmd, err := m.executeTrade(widget)
 					if err != nil {
 						log.Printf("Error getting inputs: %v", err)
-					} else {
-						// TODO: Execute trade
-						log.Printf("Trade executed: pair=%d entry=%.2f stop=%.2f leverage=%.2f", 
-							pairIdx, entry, stop, leverage)
+						return m, nil
 					}
+					m.executing = true
+					return m, cmd
 				}
-				m.tradeWidget = nil
 				return m, nil
 			}
 		}
@@ -74,14 +240,93 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// executeTrade returns a command placing the confirmed trade in the
+// background and reporting a tradeResultMsg
+func (m mainModel) executeTrade(widget *ui.TradeInputWidget) (tea.Cmd, error) {
+	pair, entry, stop, leverage, err := widget.GetInputs()
+	if err != nil {
+		return nil, err
+	}
+
+	side := "BUY"
+	if stop.GreaterThan(entry) {
+		side = "SELL"
+	}
+
+	takeProfits, err := widget.GetTakeProfits()
+	if err != nil {
+		return nil, err
+	}
+
+	executor := services.NewTradeExecutor(m.client, m.orderService, m.riskSettings.RiskPercent, pair, side, entry, stop, leverage)
+	executor.SetTakeProfits(takeProfits)
+	executor.SetCommandQueue(m.commands)
+	executor.SetFillTimeout(m.fillTimeout, m.cancelEntry)
+	return func() tea.Msg {
+		result, err := executor.Execute()
+		return tradeResultMsg{result: result, err: err}
+	}, nil
+}
+
 func (m mainModel) View() string {
+	if m.executing {
+		status := "Placing order..."
+		if stats := m.commands.Stats(); stats.Queued() > 0 {
+			// Show the backlog ahead when the queue is busy
+			status += fmt.Sprintf(" (%d commands queued, %d executing)", stats.Queued(), stats.Running)
+		}
+		return styles.BoxStyle.Render(styles.InfoStyle.Render(status))
+	}
+	if m.orderResult != nil {
+		return m.orderResult.View() + "\n" + styles.InfoStyle.Render("Press any key to continue")
+	}
+	if m.actionResult != nil {
+		return m.actionResult.View() + "\n" + styles.InfoStyle.Render("Press any key to continue")
+	}
 	if m.tradeWidget != nil {
 		return m.tradeWidget.View()
 	}
 	return m.dashboard.View()
 }
 
+// retryPolicies builds the command retry policies from the config
+func retryPolicies(cfg *config.Config) (map[services.CommandType]services.RetryPolicy, error) {
+	minBackoff, err := time.ParseDuration(cfg.RetryBackoff)
+	if err != nil || minBackoff < 0 {
+		return nil, fmt.Errorf("invalid retry_backoff %q", cfg.RetryBackoff)
+	}
+	maxBackoff, err := time.ParseDuration(cfg.RetryMaxBackoff)
+	if err != nil || maxBackoff < minBackoff {
+		return nil, fmt.Errorf("invalid retry_max_backoff %q", cfg.RetryMaxBackoff)
+	}
+
+	policies := make(map[services.CommandType]services.RetryPolicy)
+	for t, retries := range map[services.CommandType]int{
+		services.CommandPlaceOrder:  cfg.PlaceRetries,
+		services.CommandCancelOrder: cfg.CancelRetries,
+		services.CommandModifyOrder: cfg.ModifyRetries,
+	} {
+		if retries < 0 {
+			return nil, fmt.Errorf("%s retries must not be negative", t)
+		}
+		policies[t] = services.RetryPolicy{MaxRetries: retries, MinBackoff: minBackoff, MaxBackoff: maxBackoff}
+	}
+	return policies, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "mock-exchange" {
+		runMockExchange(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "orders" {
+		runOrders(os.Args[2:])
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -90,20 +335,126 @@ func main() {
 
 	log.Printf("Config loaded - TestMode: %v", cfg.TestMode)
 
-	if !cfg.TestMode {
-		if cfg.APIKey == "" || cfg.APISecret == "" || cfg.APIBaseURL == "" {
-			log.Fatal("API credentials not configured. Please update config.yaml")
+	switch {
+	case cfg.TestMode:
+	case cfg.PaperTrading:
+		if cfg.PaperPriceFile == "" && cfg.APIBaseURL == "" {
+			log.Fatal("Paper trading needs api_base_url or paper_price_file. Please update config.yaml")
 		}
+	case cfg.APIKey == "" || cfg.APISecret == "" || cfg.APIBaseURL == "":
+		log.Fatal("API credentials not configured. Please update config.yaml")
 	}
 
 	if cfg.RiskPercentage <= 0 || cfg.RiskPercentage > 100 {
 		log.Fatal("Risk percentage must be between 0 and 100")
 	}
 
-	// Initialize API client
-	client := api.NewAPIClient(cfg.APIKey, cfg.APISecret, cfg.APIBaseURL)
-	if client == nil {
-		log.Fatal("Failed to initialize API client")
+	// In test mode trade against an in-process mock exchange
+	if cfg.TestMode {
+		baseURL, err := startTestExchange(ctx, cfg)
+		if err != nil {
+			log.Fatalf("Failed to start mock exchange: %v", err)
+		}
+		cfg.Exchange = exchange.DefaultName
+		cfg.APIBaseURL = baseURL
+		log.Printf("Test mode - using mock exchange at %s", baseURL)
+	}
+
+	// Initialize exchange adapter
+	client, err := exchange.New(cfg.Exchange, exchange.Config{
+		APIKey:    cfg.APIKey,
+		APISecret: cfg.APISecret,
+		BaseURL:   cfg.APIBaseURL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize exchange: %v", err)
+	}
+
+	// In paper trading mode orders fill against a simulated account
+	if cfg.PaperTrading {
+		var source paper_trading.PriceSource = client
+		if cfg.PaperPriceFile != "" {
+			source, err = paper_trading.LoadRecordedPrices(cfg.PaperPriceFile)
+			if err != nil {
+				log.Fatalf("Failed to load recorded prices: %v", err)
+			}
+		}
+
+		paperCfg := paper_trading.DefaultConfig()
+		paperCfg.AccountFile = cfg.PaperAccountFile
+		paperCfg.StartingBalance = decimal.NewFromFloat(cfg.PaperBalance)
+		paperCfg.Leverage = cfg.PaperLeverage
+		paperCfg.MakerFeeRate = cfg.MakerFeeRate
+		paperCfg.TakerFeeRate = cfg.TakerFeeRate
+
+		paper, err := paper_trading.NewPaperTrader(source, paperCfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize paper trading: %v", err)
+		}
+		go paper.Run(ctx, time.Second)
+		client = paper
+		log.Printf("Paper trading - account file %s", cfg.PaperAccountFile)
+	}
+
+	pairs, err := exchange.TradablePairs(client)
+	if err != nil {
+		log.Fatalf("Failed to load tradable pairs: %v", err)
+	}
+	log.Printf("Loaded %d tradable pairs", len(pairs))
+
+	// Stream market data so positions reprice live
+	var stream *marketdata.Stream
+	streamURL := cfg.StreamURL
+	if streamURL == "" && cfg.APIBaseURL != "" {
+		if streamURL, err = marketdata.URLFromBaseURL(cfg.APIBaseURL); err != nil {
+			log.Fatalf("Invalid market data URL: %v", err)
+		}
+	}
+	if streamURL != "" {
+		stream = marketdata.NewStream(streamURL, marketdata.DefaultStreamConfig())
+		// Paper orders never reach the exchange, only live ones stream
+		if !cfg.PaperTrading {
+			stream.Authenticate(cfg.APIKey, cfg.APISecret)
+			if err := stream.SubscribeAccount(); err != nil {
+				log.Printf("Failed to subscribe to account updates: %v", err)
+			}
+		}
+		go stream.Run(ctx)
+		log.Printf("Streaming market data from %s", streamURL)
+	}
+
+	// Track placed orders until they fill or are cancelled, from the
+	// account stream or by polling while it is down
+	orders := services.NewOrderStateManager()
+	orderSync := services.NewOrderSync(client, orders)
+	if stream != nil && !cfg.PaperTrading {
+		orderSync.SetStream(stream)
+	}
+
+	// Every order change is journaled. Orders still open when the app
+	// last stopped are reconciled with the exchange before any new command.
+	if cfg.OrderStoreFile != "" {
+		store, events, err := services.OpenOrderStore(cfg.OrderStoreFile)
+		if err != nil {
+			log.Fatalf("Failed to open order store: %v", err)
+		}
+		defer store.Close()
+		orders.SetStore(store)
+
+		report, err := orderSync.Recover(services.ProjectOrders(events))
+		if err != nil {
+			log.Fatalf("Failed to recover orders: %v", err)
+		}
+		log.Printf("Recovered %d open orders from %s", report.Restored, cfg.OrderStoreFile)
+		for _, order := range report.Lost {
+			log.Printf("Order %s %s %s %s: %v", order.ID, order.Side, order.Quantity, order.Symbol, order.GetError())
+		}
+		for _, order := range report.Unresolved {
+			log.Printf("Order %s %s %s %s could not be looked up, check the exchange", order.ID, order.Side, order.Quantity, order.Symbol)
+		}
+		for _, o := range report.Untracked {
+			log.Printf("Open order %s %s %s is not tracked", o.OrderID, o.Quantity, o.Symbol)
+		}
 	}
 
 	// Initialize services
@@ -117,12 +468,102 @@ func main() {
 		log.Fatal("Failed to initialize order service")
 	}
 
+	// Trade entry and execution share the instrument specs
+	instruments := exchange.NewInstrumentCache(client, exchange.DefaultInstrumentTTL)
+	orderService.SetInstrumentCache(instruments)
+
+	costs := risk_calculator.CostModel{
+		Fees: risk_calculator.FeeSchedule{
+			MakerRate: cfg.MakerFeeRate,
+			TakerRate: cfg.TakerFeeRate,
+		},
+		Slippage: risk_calculator.FixedBpsSlippage{Bps: cfg.SlippageBps},
+	}
+	orderService.SetCostModel(costs)
+
+	marginMode, err := risk_calculator.ParseMarginMode(cfg.MarginMode)
+	if err != nil {
+		log.Fatalf("Invalid margin mode: %v", err)
+	}
+	tiers := risk_calculator.DefaultMaintenanceTiers()
+	orderService.SetMarginConfig(marginMode, tiers)
+
+	// Positions load from the exchange and follow fills and mark prices
+	positions := services.NewPositionService(client, riskCalc)
+	positions.SetInstrumentCache(instruments)
+	positions.SetMarginConfig(marginMode, tiers)
+	orderSync.SetFillHandler(positions.ApplyFill)
+	go positions.Run(ctx, services.DefaultPositionRefreshInterval)
+	go orderSync.Run(ctx, services.DefaultOrderPollInterval)
+
+	retries, err := retryPolicies(cfg)
+	if err != nil {
+		log.Fatalf("Invalid retry settings: %v", err)
+	}
+
+	// Trade entry, position actions and stop moves queue their orders
+	// together, so cancels and exits go before new entries
+	commands := services.NewCommandQueue(100, orders)
+	for t, policy := range retries {
+		commands.SetRetryPolicy(t, policy)
+	}
+	commands.Start(ctx, orderService)
+
+	// Stops move to breakeven and trail from the price feed
+	stopCfg := services.DefaultStopConfig()
+	stopCfg.Costs = costs
+	stopCfg.BreakevenOnTakeProfit = cfg.BreakevenAfterTP
+	stopCfg.NativeTrailing = cfg.NativeTrailing
+	stopCfg.ATRPeriod = cfg.ATRPeriod
+	if stopCfg.ATRInterval, err = time.ParseDuration(cfg.ATRInterval); err != nil || stopCfg.ATRInterval <= 0 {
+		log.Fatalf("Invalid atr_interval %q", cfg.ATRInterval)
+	}
+	// The stops of positions opened before a restart are taken over from
+	// the recovered orders
+	stops := services.NewStopManager(orderService, positions, commands, stopCfg)
+	stops.Restore()
+	positions.SetStopManager(stops)
+	go stops.Run(ctx, services.DefaultStopSyncInterval)
+
+	fillTimeout, err := time.ParseDuration(cfg.FillTimeout)
+	if err != nil || fillTimeout < 0 {
+		log.Fatalf("Invalid fill_timeout %q", cfg.FillTimeout)
+	}
+
+	takeProfits, err := risk_calculator.ParseTakeProfitTargets(cfg.TakeProfits)
+	if err != nil {
+		log.Fatalf("Invalid take profits: %v", err)
+	}
+
 	// Create and run the main application
 	model := mainModel{
-		dashboard:    ui.NewPositionDashboard(true), // Using placeholder data for now
-		client:      client,
+		dashboard:    ui.NewPositionDashboard(),
+		client:       client,
+		pairs:        pairs,
+		favourites:   cfg.FavouritePairs,
+		instruments:  instruments,
+		stream:       stream,
+		orders:       orders,
+		positions:    positions,
+		stops:        stops,
 		orderService: orderService,
-		riskPercent: cfg.RiskPercentage / 100,
+		commands:     commands,
+		fillTimeout:  fillTimeout,
+		cancelEntry:  cfg.CancelUnfilledEntry,
+		riskCalc:     riskCalc,
+		riskSettings: ui.RiskSettings{
+			RiskPercent: cfg.RiskPercentage,
+			Costs:       costs,
+			MarginMode:  marginMode,
+			Tiers:       tiers,
+
+			AutoLeverage:      cfg.AutoLeverage,
+			LiquidationBuffer: cfg.LiquidationBuffer,
+
+			TakeProfits: takeProfits,
+
+			Instrument: instruments.Get,
+		},
 	}
 
 	p := tea.NewProgram(model)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/sub0xdai/n0xtilus/internal/config"
+	"github.com/sub0xdai/n0xtilus/internal/mockexchange"
+)
+
+// runMockExchange serves a standalone mock exchange until interrupted
+func runMockExchange(args []string) {
+	defaults := mockexchange.DefaultConfig()
+	cfg := defaults
+
+	fs := flag.NewFlagSet("mock-exchange", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	apiKey := fs.String("api-key", "", "API key clients must send (empty disables auth)")
+	apiSecret := fs.String("api-secret", "", "API secret used to verify signatures")
+	fs.TextVar(&cfg.Balance, "balance", defaults.Balance, "starting account balance")
+	volatility := fs.Float64("volatility", defaults.Volatility, "random walk step as a fraction of the price")
+	tick := fs.Duration("tick", defaults.TickInterval, "price feed update interval")
+	fs.Parse(args)
+
+	cfg.APIKey = *apiKey
+	cfg.APISecret = *apiSecret
+	cfg.Volatility = *volatility
+	cfg.TickInterval = *tick
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	baseURL, err := mockexchange.NewServer(cfg).Start(ctx, *addr)
+	if err != nil {
+		log.Fatalf("Failed to start mock exchange: %v", err)
+	}
+	log.Printf("Mock exchange listening on %s", baseURL)
+
+	<-ctx.Done()
+}
+
+// startTestExchange runs an in-process mock exchange for test mode and
+// returns its base URL. Requests are verified against the configured
+// credentials so the signing path is exercised too.
+func startTestExchange(ctx context.Context, cfg *config.Config) (string, error) {
+	mockCfg := mockexchange.DefaultConfig()
+	mockCfg.APIKey = cfg.APIKey
+	mockCfg.APISecret = cfg.APISecret
+	return mockexchange.NewServer(mockCfg).Start(ctx, "127.0.0.1:0")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sub0xdai/n0xtilus/internal/config"
+	"github.com/sub0xdai/n0xtilus/internal/services"
+)
+
+const ordersUsage = "usage: n0xtilus orders history [-file orders.jsonl] <order id>"
+
+// runOrders reads the order journal. `orders history <id>` prints the
+// timeline of one order, found by its own, exchange or client order ID.
+func runOrders(args []string) {
+	if len(args) == 0 || args[0] != "history" {
+		fmt.Fprintln(os.Stderr, ordersUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("orders history", flag.ExitOnError)
+	file := fs.String("file", "", "order journal, order_store_file from config.yaml by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), ordersUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := *file
+	if path == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		path = cfg.OrderStoreFile
+	}
+	events, err := services.LoadOrderEvents(path)
+	if err != nil {
+		log.Fatalf("Failed to read order journal: %v", err)
+	}
+
+	id := fs.Arg(0)
+	var order *services.OrderRecord
+	records := services.ProjectOrders(events)
+	for i, r := range records {
+		if r.ID == id || r.ExchangeOrderID == id || r.Trade.ClientOrderID == id {
+			order = &records[i]
+			break
+		}
+	}
+	if order == nil {
+		log.Fatalf("Order %s not found in %s", id, path)
+	}
+
+	printOrderHistory(*order, events)
+}
+
+// printOrderHistory prints an order's current state and every event
+// journaled for it
+func printOrderHistory(order services.OrderRecord, events []services.OrderEvent) {
+	fmt.Printf("Order %s", order.ID)
+	if order.ExchangeOrderID != "" {
+		fmt.Printf(" (exchange %s)", order.ExchangeOrderID)
+	}
+	fmt.Printf(": %s %s %s %s, %s\n", order.Trade.Type, order.Trade.Side, order.Trade.Quantity, order.Trade.Symbol, order.State)
+	if order.Error != "" {
+		fmt.Printf("Error: %s\n", order.Error)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENT\tTIME\tTYPE\tCAUSE\tDETAIL")
+	for _, e := range events {
+		if e.OrderID != order.ID {
+			continue
+		}
+		cause := ""
+		if e.CausationID != 0 {
+			cause = fmt.Sprintf("#%d", e.CausationID)
+		}
+		fmt.Fprintf(w, "#%d\t%s\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05.000"), e.Type, cause, e.Describe())
+	}
+	w.Flush()
+}
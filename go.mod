@@ -6,6 +6,8 @@ require (
 	github.com/charmbracelet/bubbles v0.17.1
 	github.com/charmbracelet/bubbletea v0.25.0
 	github.com/charmbracelet/lipgloss v0.9.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/shopspring/decimal v1.4.0
 	github.com/spf13/viper v1.19.0
 )
 
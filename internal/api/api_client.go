@@ -1,10 +1,35 @@
 package api
 
 import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
     "errors"
     "fmt"
+    "io"
     "net/http"
+    "net/url"
+    "strconv"
+    "strings"
     "time"
+
+    "github.com/shopspring/decimal"
+    "github.com/sub0xdai/n0xtilus/internal/exchange"
+    "github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// Authentication headers sent with every request
+const (
+    HeaderAPIKey    = "X-API-KEY"
+    HeaderTimestamp = "X-API-TIMESTAMP"
+    HeaderSignature = "X-API-SIGNATURE"
+)
+
+const (
+    maxRetries     = 3
+    initialBackoff = 500 * time.Millisecond
 )
 
 type APIClient struct {
@@ -18,7 +43,7 @@ func NewAPIClient(apiKey, apiSecret, baseURL string) *APIClient {
     return &APIClient{
         apiKey:    apiKey,
         apiSecret: apiSecret,
-        baseURL:   baseURL,
+        baseURL:   strings.TrimRight(baseURL, "/"),
         client: &http.Client{
             Timeout: time.Second * 10,
         },
@@ -26,83 +51,376 @@ func NewAPIClient(apiKey, apiSecret, baseURL string) *APIClient {
 }
 
 var (
-    ErrInvalidOrderParams = errors.New("invalid order parameters")
-    ErrAPIRequestFailed   = errors.New("API request failed")
+    ErrInvalidOrderParams  = errors.New("invalid order parameters")
+    ErrAPIRequestFailed    = errors.New("API request failed")
+    ErrUnauthorized        = errors.New("unauthorized")
+    ErrRateLimited         = errors.New("rate limited")
+    ErrInsufficientBalance = errors.New("insufficient balance")
+    ErrOrderNotFound       = exchange.ErrOrderNotFound
+    ErrUnknownSymbol       = errors.New("unknown symbol")
+    ErrDuplicateOrder      = exchange.ErrDuplicateOrder
+)
+
+// Error codes returned by the exchange in error bodies
+const (
+    CodeUnauthorized        = "UNAUTHORIZED"
+    CodeRateLimited         = "RATE_LIMITED"
+    CodeInvalidParams       = "INVALID_PARAMS"
+    CodeInsufficientBalance = "INSUFFICIENT_BALANCE"
+    CodeOrderNotFound       = "ORDER_NOT_FOUND"
+    CodeUnknownSymbol       = "UNKNOWN_SYMBOL"
+    CodeDuplicateOrder      = "DUPLICATE_ORDER"
 )
 
-func (c *APIClient) GetBalance() (float64, error) {
-    // TODO: Implement actual API call to get balance
-    // Example of how to structure the API call:
-    // resp, err := c.sendRequest("GET", "/balance", nil)
-    // if err != nil {
-    //     return 0, fmt.Errorf("failed to get balance: %w", err)
-    // }
-    // Parse response and return balance
-    return 1000.0, nil // Placeholder
-}
-
-func (c *APIClient) PlaceOrder(symbol, side, quantity, price string) (string, error) {
-    if symbol == "" || side == "" || quantity == "" || price == "" {
-        return "", ErrInvalidOrderParams
-    }
-    // TODO: Implement actual API call to place order
-    // Example:
-    // params := map[string]string{
-    //     "symbol":   symbol,
-    //     "side":     side,
-    //     "quantity": quantity,
-    //     "price":    price,
-    // }
-    // resp, err := c.sendRequest("POST", "/order", params)
-    // if err != nil {
-    //     return "", fmt.Errorf("failed to place order: %w", err)
-    // }
-    // Parse response and return order ID
-    return fmt.Sprintf("Order placed: %s %s %s @ %s", side, symbol, quantity, price), nil
-}
-
-func (c *APIClient) GetTradablePairs() ([]string, error) {
-    // TODO: Implement actual API call to get tradable pairs
-    // Example:
-    // resp, err := c.sendRequest("GET", "/tradable_pairs", nil)
-    // if err != nil {
-    //     return nil, fmt.Errorf("failed to get tradable pairs: %w", err)
-    // }
-    // Parse response and return pairs
-    return []string{"BTC/USDT", "ETH/USDT", "XRP/USDT", "ADA/USDT", "DOT/USDT"}, nil
-}
-
-func (c *APIClient) GetMarketPrice(symbol string) (float64, error) {
-    // TODO: Implement actual API call to get market price
-    // Example:
-    // params := map[string]string{"symbol": symbol}
-    // resp, err := c.sendRequest("GET", "/market_price", params)
-    // if err != nil {
-    //     return 0, fmt.Errorf("failed to get market price: %w", err)
-    // }
-    // Parse response and return price
-    return 50000.0, nil // Placeholder
+// APIError is returned when the exchange responds with a non-2xx status.
+// It wraps ErrAPIRequestFailed and, when the code is known, the matching
+// sentinel error so callers can use errors.Is.
+type APIError struct {
+    StatusCode int    `json:"-"`
+    Code       string `json:"code"`
+    Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+    if e.Code == "" {
+        return fmt.Sprintf("%v: status %d: %s", ErrAPIRequestFailed, e.StatusCode, e.Message)
+    }
+    return fmt.Sprintf("%v: status %d: %s: %s", ErrAPIRequestFailed, e.StatusCode, e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() []error {
+    errs := []error{ErrAPIRequestFailed}
+    if sentinel := e.sentinel(); sentinel != nil {
+        errs = append(errs, sentinel)
+    }
+    return errs
+}
+
+func (e *APIError) sentinel() error {
+    switch e.Code {
+    case CodeUnauthorized:
+        return ErrUnauthorized
+    case CodeRateLimited:
+        return ErrRateLimited
+    case CodeInvalidParams:
+        return ErrInvalidOrderParams
+    case CodeInsufficientBalance:
+        return ErrInsufficientBalance
+    case CodeOrderNotFound:
+        return ErrOrderNotFound
+    case CodeUnknownSymbol:
+        return ErrUnknownSymbol
+    case CodeDuplicateOrder:
+        return ErrDuplicateOrder
+    }
+    switch e.StatusCode {
+    case http.StatusUnauthorized, http.StatusForbidden:
+        return ErrUnauthorized
+    case http.StatusTooManyRequests:
+        return ErrRateLimited
+    }
+    // The exchange or a gateway before it failed, the request may have
+    // been carried out
+    if e.StatusCode >= http.StatusInternalServerError {
+        return exchange.ErrNetwork
+    }
+    return nil
+}
+
+type balanceResponse struct {
+    Balance decimal.Decimal `json:"balance"`
+}
+
+type orderResponse struct {
+    OrderID string `json:"order_id"`
+}
+
+type marketsResponse struct {
+    Markets []models.Market `json:"markets"`
+}
+
+type instrumentsResponse struct {
+    Instruments []models.Instrument `json:"instruments"`
+}
+
+type positionsResponse struct {
+    Positions []models.Position `json:"positions"`
+}
+
+type fillsResponse struct {
+    Fills []models.Fill `json:"fills"`
+}
+
+type openOrdersResponse struct {
+    Orders []models.OrderUpdate `json:"orders"`
+}
+
+func (c *APIClient) GetBalance() (decimal.Decimal, error) {
+    var resp balanceResponse
+    if err := c.doJSON(http.MethodGet, "/balance", nil, &resp); err != nil {
+        return decimal.Zero, fmt.Errorf("failed to get balance: %w", err)
+    }
+    return resp.Balance, nil
+}
+
+func (c *APIClient) PlaceOrder(trade models.Trade) (string, error) {
+    if err := trade.Validate(); err != nil {
+        return "", fmt.Errorf("%w: %w", ErrInvalidOrderParams, err)
+    }
+    params := map[string]string{
+        "symbol":        trade.Symbol,
+        "side":          string(trade.Side),
+        "type":          string(trade.Type),
+        "quantity":      trade.Quantity.String(),
+        "time_in_force": string(trade.TimeInForce),
+    }
+    if trade.Price.IsPositive() {
+        params["price"] = trade.Price.String()
+    }
+    if trade.StopPrice.IsPositive() {
+        params["stop_price"] = trade.StopPrice.String()
+    }
+    if trade.TrailingDistance.IsPositive() {
+        params["trailing_distance"] = trade.TrailingDistance.String()
+    }
+    if trade.ReduceOnly {
+        params["reduce_only"] = "true"
+    }
+    if trade.PostOnly {
+        params["post_only"] = "true"
+    }
+    if trade.ClientOrderID != "" {
+        params["client_order_id"] = trade.ClientOrderID
+    }
+
+    var resp orderResponse
+    if err := c.doJSON(http.MethodPost, "/order", params, &resp); err != nil {
+        return "", fmt.Errorf("failed to place order: %w", err)
+    }
+    if resp.OrderID == "" {
+        return "", fmt.Errorf("failed to place order: %w: missing order ID", ErrAPIRequestFailed)
+    }
+    return resp.OrderID, nil
+}
+
+func (c *APIClient) LinkOCO(orderIDs ...string) error {
+    if len(orderIDs) < 2 {
+        return ErrInvalidOrderParams
+    }
+    params := map[string]string{"order_ids": strings.Join(orderIDs, ",")}
+    if _, err := c.sendRequest(http.MethodPost, "/oco", params); err != nil {
+        return fmt.Errorf("failed to link OCO orders: %w", err)
+    }
+    return nil
+}
+
+func (c *APIClient) GetMarkets() ([]models.Market, error) {
+    var resp marketsResponse
+    if err := c.doJSON(http.MethodGet, "/markets", nil, &resp); err != nil {
+        return nil, fmt.Errorf("failed to get markets: %w", err)
+    }
+    return resp.Markets, nil
+}
+
+func (c *APIClient) GetInstruments() ([]models.Instrument, error) {
+    var resp instrumentsResponse
+    if err := c.doJSON(http.MethodGet, "/instruments", nil, &resp); err != nil {
+        return nil, fmt.Errorf("failed to get instruments: %w", err)
+    }
+    return resp.Instruments, nil
+}
+
+func (c *APIClient) GetTicker(symbol string) (models.Ticker, error) {
+    params := map[string]string{"symbol": symbol}
+    var ticker models.Ticker
+    if err := c.doJSON(http.MethodGet, "/ticker", params, &ticker); err != nil {
+        return models.Ticker{}, fmt.Errorf("failed to get ticker: %w", err)
+    }
+    return ticker, nil
 }
 
 func (c *APIClient) CancelOrder(orderID string) error {
-    // TODO: Implement actual API call to cancel an order
-    // Example:
-    // params := map[string]string{"order_id": orderID}
-    // _, err := c.sendRequest("DELETE", "/order", params)
-    // if err != nil {
-    //     return fmt.Errorf("failed to cancel order: %w", err)
-    // }
-    return nil // Placeholder
+    if orderID == "" {
+        return ErrInvalidOrderParams
+    }
+    params := map[string]string{"order_id": orderID}
+    if _, err := c.sendRequest(http.MethodDelete, "/order", params); err != nil {
+        return fmt.Errorf("failed to cancel order: %w", err)
+    }
+    return nil
+}
+
+func (c *APIClient) AmendOrder(orderID string, quantity, price decimal.Decimal) error {
+    if orderID == "" || !quantity.IsPositive() || !price.IsPositive() {
+        return ErrInvalidOrderParams
+    }
+    params := map[string]string{
+        "order_id": orderID,
+        "quantity": quantity.String(),
+        "price":    price.String(),
+    }
+    if _, err := c.sendRequest(http.MethodPut, "/order", params); err != nil {
+        return fmt.Errorf("failed to amend order: %w", err)
+    }
+    return nil
+}
+
+func (c *APIClient) GetPositions() ([]models.Position, error) {
+    var resp positionsResponse
+    if err := c.doJSON(http.MethodGet, "/positions", nil, &resp); err != nil {
+        return nil, fmt.Errorf("failed to get positions: %w", err)
+    }
+    return resp.Positions, nil
+}
+
+func (c *APIClient) GetOrder(orderID string) (models.OrderUpdate, error) {
+    if orderID == "" {
+        return models.OrderUpdate{}, ErrInvalidOrderParams
+    }
+    var order models.OrderUpdate
+    if err := c.doJSON(http.MethodGet, "/order", map[string]string{"order_id": orderID}, &order); err != nil {
+        return models.OrderUpdate{}, fmt.Errorf("failed to get order: %w", err)
+    }
+    return order, nil
+}
+
+func (c *APIClient) GetOrderByClientID(clientOrderID string) (models.OrderUpdate, error) {
+    if clientOrderID == "" {
+        return models.OrderUpdate{}, ErrInvalidOrderParams
+    }
+    var order models.OrderUpdate
+    if err := c.doJSON(http.MethodGet, "/order", map[string]string{"client_order_id": clientOrderID}, &order); err != nil {
+        return models.OrderUpdate{}, fmt.Errorf("failed to get order: %w", err)
+    }
+    return order, nil
+}
+
+func (c *APIClient) GetOpenOrders(symbol string) ([]models.OrderUpdate, error) {
+    var params map[string]string
+    if symbol != "" {
+        params = map[string]string{"symbol": symbol}
+    }
+    var resp openOrdersResponse
+    if err := c.doJSON(http.MethodGet, "/orders", params, &resp); err != nil {
+        return nil, fmt.Errorf("failed to get open orders: %w", err)
+    }
+    return resp.Orders, nil
+}
+
+func (c *APIClient) GetFills(symbol string) ([]models.Fill, error) {
+    var params map[string]string
+    if symbol != "" {
+        params = map[string]string{"symbol": symbol}
+    }
+    var resp fillsResponse
+    if err := c.doJSON(http.MethodGet, "/fills", params, &resp); err != nil {
+        return nil, fmt.Errorf("failed to get fills: %w", err)
+    }
+    return resp.Fills, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of a request. The
+// signed payload is timestamp + method + request path (including the query
+// string) + body.
+func Sign(secret, timestamp, method, requestPath string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp))
+    mac.Write([]byte(method))
+    mac.Write([]byte(requestPath))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
 }
 
-// Helper method to send API requests
+// doJSON sends a request and decodes the JSON response into out
+func (c *APIClient) doJSON(method, endpoint string, params map[string]string, out interface{}) error {
+    body, err := c.sendRequest(method, endpoint, params)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(body, out); err != nil {
+        return fmt.Errorf("%w: invalid response: %v", ErrAPIRequestFailed, err)
+    }
+    return nil
+}
+
+// Helper method to send API requests. Parameters are sent as the query
+// string for GET and DELETE and as a JSON body otherwise. Rate limited
+// requests are retried with exponential backoff.
 func (c *APIClient) sendRequest(method, endpoint string, params map[string]string) ([]byte, error) {
-    // Implement the actual HTTP request logic here
-    // This should include:
-    // 1. Constructing the full URL
-    // 2. Adding authentication headers
-    // 3. Sending the request
-    // 4. Handling rate limiting (possibly with exponential backoff)
-    // 5. Reading and returning the response body
-    return nil, nil
+    requestPath := endpoint
+    var body []byte
+    if method == http.MethodGet || method == http.MethodDelete {
+        if len(params) > 0 {
+            query := url.Values{}
+            for k, v := range params {
+                query.Set(k, v)
+            }
+            requestPath += "?" + query.Encode()
+        }
+    } else if params != nil {
+        var err error
+        body, err = json.Marshal(params)
+        if err != nil {
+            return nil, fmt.Errorf("%w: failed to encode params: %v", ErrAPIRequestFailed, err)
+        }
+    }
+
+    backoff := initialBackoff
+    for attempt := 0; ; attempt++ {
+        respBody, retryAfter, err := c.doRequest(method, requestPath, body)
+        if err == nil {
+            return respBody, nil
+        }
+        if !errors.Is(err, ErrRateLimited) || attempt >= maxRetries {
+            return nil, err
+        }
+        wait := backoff
+        if retryAfter > 0 {
+            wait = retryAfter
+        }
+        time.Sleep(wait)
+        backoff *= 2
+    }
+}
+
+// doRequest performs a single signed HTTP request
+func (c *APIClient) doRequest(method, requestPath string, body []byte) ([]byte, time.Duration, error) {
+    req, err := http.NewRequest(method, c.baseURL+requestPath, bytes.NewReader(body))
+    if err != nil {
+        return nil, 0, fmt.Errorf("%w: %v", ErrAPIRequestFailed, err)
+    }
+
+    timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+    req.Header.Set(HeaderAPIKey, c.apiKey)
+    req.Header.Set(HeaderTimestamp, timestamp)
+    req.Header.Set(HeaderSignature, Sign(c.apiSecret, timestamp, method, requestPath, body))
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return nil, 0, fmt.Errorf("%w: %w: %v", ErrAPIRequestFailed, exchange.ErrNetwork, err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, 0, fmt.Errorf("%w: %w: failed to read response: %v", ErrAPIRequestFailed, exchange.ErrNetwork, err)
+    }
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        apiErr := &APIError{StatusCode: resp.StatusCode}
+        if json.Unmarshal(respBody, apiErr) != nil || apiErr.Message == "" {
+            apiErr.Message = strings.TrimSpace(string(respBody))
+        }
+        var retryAfter time.Duration
+        if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+            retryAfter = time.Duration(seconds) * time.Second
+        }
+        return nil, retryAfter, apiErr
+    }
+
+    return respBody, 0, nil
 }
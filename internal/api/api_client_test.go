@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+const (
+	testKey    = "key"
+	testSecret = "secret"
+)
+
+// newTestClient returns a client for a server running handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *APIClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewAPIClient(testKey, testSecret, server.URL+"/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// checkSignature fails the test unless r carries the test key and is
+// signed with the test secret
+func checkSignature(t *testing.T, r *http.Request, body []byte) {
+	t.Helper()
+	if got := r.Header.Get(HeaderAPIKey); got != testKey {
+		t.Errorf("%s = %q, want %q", HeaderAPIKey, got, testKey)
+	}
+	timestamp := r.Header.Get(HeaderTimestamp)
+	if timestamp == "" {
+		t.Errorf("%s missing", HeaderTimestamp)
+	}
+	want := Sign(testSecret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if got := r.Header.Get(HeaderSignature); got != want {
+		t.Errorf("%s %s: signature = %q, want %q", r.Method, r.URL.RequestURI(), got, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   string
+	}{
+		{"query", http.MethodGet, "/balance", "", "d7a614fcfe18ff1e2ced4f5419b440d1c352b68415a369fe8d763de12b5bd355"},
+		{"body", http.MethodPost, "/order", `{"symbol":"BTC/USDT"}`, "4d4306f754a2d709304420a894e1998201f9a87b5de53cc225b59ed605287851"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+			if got := Sign("secret", "1700000000000", tt.method, tt.path, body); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestsAreSigned(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		checkSignature(t, r, body)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/balance":
+			writeJSON(w, http.StatusOK, map[string]string{"balance": "1234.5"})
+		case r.Method == http.MethodPost && r.URL.Path == "/order":
+			var params map[string]string
+			if err := json.Unmarshal(body, &params); err != nil {
+				t.Errorf("order body %q: %v", body, err)
+			}
+			if params["symbol"] != "BTC/USDT" || params["quantity"] != "0.5" || params["price"] != "50000" || params["client_order_id"] != "C1" {
+				t.Errorf("order params = %v", params)
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"order_id": "EX-1"})
+		case r.Method == http.MethodDelete && r.URL.Path == "/order":
+			if got := r.URL.Query().Get("order_id"); got != "EX-1" {
+				t.Errorf("cancel order_id = %q, want EX-1", got)
+			}
+			writeJSON(w, http.StatusOK, map[string]string{})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	balance, err := client.GetBalance()
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if !balance.Equal(decimal.RequireFromString("1234.5")) {
+		t.Errorf("balance = %s, want 1234.5", balance)
+	}
+
+	orderID, err := client.PlaceOrder(models.Trade{
+		Symbol:        "BTC/USDT",
+		Side:          models.SideBuy,
+		Type:          models.OrderTypeLimit,
+		Quantity:      decimal.RequireFromString("0.5"),
+		Price:         decimal.RequireFromString("50000"),
+		TimeInForce:   models.TimeInForceGTC,
+		ClientOrderID: "C1",
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if orderID != "EX-1" {
+		t.Errorf("order ID = %q, want EX-1", orderID)
+	}
+
+	if err := client.CancelOrder("EX-1"); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestRateLimitedRequestsAreRetried(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			writeJSON(w, http.StatusTooManyRequests, APIError{Code: CodeRateLimited, Message: "slow down"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"balance": "10"})
+	})
+
+	balance, err := client.GetBalance()
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if !balance.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("balance = %s, want 10", balance)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestOtherErrorsAreNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		writeJSON(w, http.StatusBadRequest, APIError{Code: CodeInsufficientBalance, Message: "not enough margin"})
+	})
+
+	if _, err := client.GetBalance(); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("GetBalance error = %v, want ErrInsufficientBalance", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+		code   string
+	}{
+		{"code", http.StatusBadRequest, `{"code":"UNKNOWN_SYMBOL","message":"no such market"}`, ErrUnknownSymbol, CodeUnknownSymbol},
+		{"duplicate", http.StatusConflict, `{"code":"DUPLICATE_ORDER","message":"exists"}`, exchange.ErrDuplicateOrder, CodeDuplicateOrder},
+		{"unauthorized status", http.StatusUnauthorized, `bad key`, ErrUnauthorized, ""},
+		{"forbidden status", http.StatusForbidden, `{"message":"ip not allowed"}`, ErrUnauthorized, ""},
+		{"server error", http.StatusInternalServerError, `oops`, exchange.ErrNetwork, ""},
+		{"gateway error", http.StatusBadGateway, ``, exchange.ErrNetwork, ""},
+		{"unknown", http.StatusBadRequest, `{"code":"SOMETHING_NEW","message":"?"}`, nil, "SOMETHING_NEW"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := client.GetMarkets()
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %v is not an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code {
+				t.Errorf("APIError = %+v, want status %d and code %q", apiErr, tt.status, tt.code)
+			}
+			if !errors.Is(err, ErrAPIRequestFailed) {
+				t.Errorf("error %v does not wrap ErrAPIRequestFailed", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error %v does not wrap %v", err, tt.want)
+			}
+			if tt.want != exchange.ErrNetwork && errors.Is(err, exchange.ErrNetwork) {
+				t.Errorf("error %v wraps ErrNetwork, the request cannot have been carried out", err)
+			}
+		})
+	}
+}
+
+func TestTransportErrorsAreNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	client := NewAPIClient(testKey, testSecret, server.URL)
+	server.Close()
+
+	_, err := client.GetBalance()
+	if !errors.Is(err, exchange.ErrNetwork) || !errors.Is(err, ErrAPIRequestFailed) {
+		t.Fatalf("error = %v, want ErrNetwork and ErrAPIRequestFailed", err)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		t.Errorf("transport error %v is an APIError", err)
+	}
+}
+
+func TestInvalidResponse(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not json")
+	})
+
+	_, err := client.GetBalance()
+	if !errors.Is(err, ErrAPIRequestFailed) || errors.Is(err, exchange.ErrNetwork) {
+		t.Fatalf("error = %v, want ErrAPIRequestFailed only", err)
+	}
+}
+
+func TestGetOrderByClientID(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		checkSignature(t, r, nil)
+		if r.Method != http.MethodGet || r.URL.Path != "/order" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		query := r.URL.Query()
+		if query.Has("order_id") {
+			t.Errorf("lookup by client ID sent order_id %q", query.Get("order_id"))
+		}
+		switch query.Get("client_order_id") {
+		case "C1":
+			writeJSON(w, http.StatusOK, models.OrderUpdate{
+				OrderID:        "EX-1",
+				ClientOrderID:  "C1",
+				Symbol:         "BTC/USDT",
+				Status:         models.OrderStatusPartiallyFilled,
+				Quantity:       decimal.RequireFromString("1"),
+				FilledQuantity: decimal.RequireFromString("0.25"),
+				AveragePrice:   decimal.RequireFromString("50000"),
+			})
+		default:
+			writeJSON(w, http.StatusNotFound, APIError{Code: CodeOrderNotFound, Message: "unknown order"})
+		}
+	})
+
+	order, err := client.GetOrderByClientID("C1")
+	if err != nil {
+		t.Fatalf("GetOrderByClientID: %v", err)
+	}
+	if order.OrderID != "EX-1" || order.Status != models.OrderStatusPartiallyFilled || !order.FilledQuantity.Equal(decimal.RequireFromString("0.25")) {
+		t.Errorf("order = %+v", order)
+	}
+
+	if _, err := client.GetOrderByClientID("C2"); !errors.Is(err, exchange.ErrOrderNotFound) {
+		t.Errorf("unknown client ID error = %v, want ErrOrderNotFound", err)
+	}
+	if _, err := client.GetOrderByClientID(""); !errors.Is(err, ErrInvalidOrderParams) {
+		t.Errorf("empty client ID error = %v, want ErrInvalidOrderParams", err)
+	}
+}
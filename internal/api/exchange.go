@@ -0,0 +1,16 @@
+package api
+
+import (
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+)
+
+// Name is the registry name of the generic REST adapter
+const Name = exchange.DefaultName
+
+var _ exchange.Exchange = (*APIClient)(nil)
+
+func init() {
+	exchange.Register(Name, func(cfg exchange.Config) (exchange.Exchange, error) {
+		return NewAPIClient(cfg.APIKey, cfg.APISecret, cfg.BaseURL), nil
+	})
+}
@@ -7,17 +7,87 @@ import (
 )
 
 type Config struct {
+	Exchange       string  `mapstructure:"exchange"`
 	APIKey         string  `mapstructure:"api_key"`
 	APISecret      string  `mapstructure:"api_secret"`
 	APIBaseURL     string  `mapstructure:"api_base_url"`
+	StreamURL      string  `mapstructure:"stream_url"` // market data WebSocket, derived from api_base_url if empty
 	RiskPercentage float64 `mapstructure:"risk_percentage"`
 	TestMode       bool    `mapstructure:"test_mode"`
+
+	// Trading cost settings used for position sizing
+	MakerFeeRate float64 `mapstructure:"maker_fee_rate"`
+	TakerFeeRate float64 `mapstructure:"taker_fee_rate"`
+	SlippageBps  float64 `mapstructure:"slippage_bps"`
+	MarginMode   string  `mapstructure:"margin_mode"`
+
+	// Leverage selection
+	AutoLeverage      bool    `mapstructure:"auto_leverage"`
+	LiquidationBuffer float64 `mapstructure:"liquidation_buffer"`
+
+	// Default scaled exits as R:percent pairs, e.g. "1:50,2:30,3:20"
+	TakeProfits string `mapstructure:"take_profits"`
+
+	// Stop management: move to breakeven once the first take profit fills,
+	// trail with exchange trailing orders, and the ATR trailing stops use
+	BreakevenAfterTP bool   `mapstructure:"breakeven_after_tp"`
+	NativeTrailing   bool   `mapstructure:"native_trailing"`
+	ATRPeriod        int    `mapstructure:"atr_period"`
+	ATRInterval      string `mapstructure:"atr_interval"` // bar length, e.g. "1m"
+
+	// Append-only journal of order events, replayed and reconciled with
+	// the exchange on startup
+	OrderStoreFile string `mapstructure:"order_store_file"`
+
+	// Retries of orders whose outcome a network error left unknown. Orders
+	// are looked up by client order ID first, so none is placed twice.
+	PlaceRetries    int    `mapstructure:"place_retries"`
+	CancelRetries   int    `mapstructure:"cancel_retries"`
+	ModifyRetries   int    `mapstructure:"modify_retries"`
+	RetryBackoff    string `mapstructure:"retry_backoff"`     // first delay, e.g. "250ms"
+	RetryMaxBackoff string `mapstructure:"retry_max_backoff"` // longest delay, e.g. "2s"
+
+	// How long trades wait for the entry to fill before placing the exits,
+	// and whether an entry still resting then is cancelled
+	FillTimeout         string `mapstructure:"fill_timeout"` // e.g. "30s", "0s" to not wait
+	CancelUnfilledEntry bool   `mapstructure:"cancel_unfilled_entry"`
+
+	// Pairs listed first in trade entry, e.g. ["BTC/USDT", "ETH/USDT"]
+	FavouritePairs []string `mapstructure:"favourite_pairs"`
+
+	// Paper trading settings
+	PaperTrading     bool    `mapstructure:"paper_trading"`
+	PaperAccountFile string  `mapstructure:"paper_account_file"`
+	PaperBalance     float64 `mapstructure:"paper_balance"`
+	PaperLeverage    float64 `mapstructure:"paper_leverage"`
+	PaperPriceFile   string  `mapstructure:"paper_price_file"`
 }
 
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
+	viper.SetDefault("exchange", "generic")
+	viper.SetDefault("maker_fee_rate", 0.0002)
+	viper.SetDefault("taker_fee_rate", 0.0005)
+	viper.SetDefault("slippage_bps", 5)
+	viper.SetDefault("margin_mode", "isolated")
+	viper.SetDefault("auto_leverage", true)
+	viper.SetDefault("liquidation_buffer", 1.0)
+	viper.SetDefault("breakeven_after_tp", true)
+	viper.SetDefault("atr_period", 14)
+	viper.SetDefault("atr_interval", "1m")
+	viper.SetDefault("order_store_file", "orders.jsonl")
+	viper.SetDefault("place_retries", 2)
+	viper.SetDefault("cancel_retries", 4)
+	viper.SetDefault("modify_retries", 2)
+	viper.SetDefault("retry_backoff", "250ms")
+	viper.SetDefault("retry_max_backoff", "2s")
+	viper.SetDefault("fill_timeout", "30s")
+	viper.SetDefault("cancel_unfilled_entry", true)
+	viper.SetDefault("paper_account_file", "paper_account.json")
+	viper.SetDefault("paper_balance", 10000)
+	viper.SetDefault("paper_leverage", 10)
 
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,150 @@
+// Package exchange defines the venue-independent trading interface and a
+// registry of adapters selected by the exchange config field
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// DefaultName is the adapter used when no exchange is configured
+const DefaultName = "generic"
+
+var ErrUnknownExchange = errors.New("unknown exchange")
+
+// Errors adapters wrap so callers can handle them alike on every venue
+var (
+	// ErrNetwork means a request failed in transit or the exchange failed
+	// while handling it, so it may or may not have taken effect
+	ErrNetwork = errors.New("network error")
+	// ErrOrderNotFound means the exchange has no such order
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrDuplicateOrder means an order with the same client order ID was
+	// already placed
+	ErrDuplicateOrder = errors.New("duplicate client order ID")
+)
+
+// Exchange defines the operations every venue adapter must support
+type Exchange interface {
+	// GetBalance returns the account balance in the quote currency
+	GetBalance() (decimal.Decimal, error)
+
+	// GetMarkets returns all tradable markets
+	GetMarkets() ([]models.Market, error)
+
+	// GetTicker returns the latest prices for a market
+	GetTicker(symbol string) (models.Ticker, error)
+
+	// GetInstruments returns the trading rules of all markets
+	GetInstruments() ([]models.Instrument, error)
+
+	// PlaceOrder places an order and returns the exchange order ID
+	PlaceOrder(trade models.Trade) (string, error)
+
+	// LinkOCO links open orders into a one-cancels-other group. Once a
+	// fill in the group leaves the position flat the rest are cancelled.
+	LinkOCO(orderIDs ...string) error
+
+	// CancelOrder cancels an open order
+	CancelOrder(orderID string) error
+
+	// AmendOrder changes the quantity and price of an open order. For stop
+	// orders price is the trigger price.
+	AmendOrder(orderID string, quantity, price decimal.Decimal) error
+
+	// GetPositions returns all open positions
+	GetPositions() ([]models.Position, error)
+
+	// GetOrder returns the current state of an order, including orders
+	// that have filled or been cancelled
+	GetOrder(orderID string) (models.OrderUpdate, error)
+
+	// GetOrderByClientID returns the current state of the order placed
+	// with a client order ID, failing with ErrOrderNotFound if there is
+	// none
+	GetOrderByClientID(clientOrderID string) (models.OrderUpdate, error)
+
+	// GetOpenOrders returns the orders resting on the book, optionally
+	// filtered by symbol
+	GetOpenOrders(symbol string) ([]models.OrderUpdate, error)
+
+	// GetFills returns recent fills, optionally filtered by symbol
+	GetFills(symbol string) ([]models.Fill, error)
+}
+
+// Config holds the settings passed to an adapter factory
+type Config struct {
+	APIKey    string
+	APISecret string
+	BaseURL   string
+}
+
+// Factory creates an Exchange from config
+type Factory func(cfg Config) (Exchange, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes an adapter available under the given name. It panics if
+// the name is registered twice.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("exchange: Register factory is nil")
+	}
+	if _, exists := factories[name]; exists {
+		panic("exchange: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the adapter registered under name
+func New(name string, cfg Config) (Exchange, error) {
+	if name == "" {
+		name = DefaultName
+	}
+
+	mu.RLock()
+	factory, exists := factories[name]
+	mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %q (available: %v)", ErrUnknownExchange, name, Names())
+	}
+	return factory(cfg)
+}
+
+// Names returns the sorted names of all registered adapters
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TradablePairs returns the symbols of all markets on the exchange
+func TradablePairs(ex Exchange) ([]string, error) {
+	markets, err := ex.GetMarkets()
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]string, 0, len(markets))
+	for _, m := range markets {
+		pairs = append(pairs, m.Symbol)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+var ErrUnknownInstrument = errors.New("unknown instrument")
+
+// DefaultInstrumentTTL is how long instrument specs are cached. They rarely
+// change, so an hour keeps them fresh without refetching for every order.
+const DefaultInstrumentTTL = time.Hour
+
+// InstrumentSource supplies instrument specs, every Exchange is one
+type InstrumentSource interface {
+	GetInstruments() ([]models.Instrument, error)
+}
+
+// InstrumentCache caches the instrument specs of an exchange. Specs are
+// refetched once they are older than the TTL or a symbol is missing.
+type InstrumentCache struct {
+	source InstrumentSource
+	ttl    time.Duration
+
+	mu          sync.Mutex
+	instruments map[string]models.Instrument
+	fetched     time.Time
+}
+
+// NewInstrumentCache creates an empty cache over source
+func NewInstrumentCache(source InstrumentSource, ttl time.Duration) *InstrumentCache {
+	return &InstrumentCache{
+		source: source,
+		ttl:    ttl,
+	}
+}
+
+// Get returns the instrument for symbol, fetching the specs if needed
+func (c *InstrumentCache) Get(symbol string) (models.Instrument, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	instrument, exists := c.instruments[symbol]
+	if exists && time.Since(c.fetched) < c.ttl {
+		return instrument, nil
+	}
+	if err := c.refresh(); err != nil {
+		if exists {
+			// Stale specs are better than none while the exchange is down
+			return instrument, nil
+		}
+		return models.Instrument{}, err
+	}
+
+	instrument, exists = c.instruments[symbol]
+	if !exists {
+		return models.Instrument{}, fmt.Errorf("%w: %s", ErrUnknownInstrument, symbol)
+	}
+	return instrument, nil
+}
+
+// Refresh refetches the specs of all instruments
+func (c *InstrumentCache) Refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.refresh()
+}
+
+// refresh refetches the specs. The caller must hold c.mu.
+func (c *InstrumentCache) refresh() error {
+	list, err := c.source.GetInstruments()
+	if err != nil {
+		return fmt.Errorf("failed to get instruments: %w", err)
+	}
+	instruments := make(map[string]models.Instrument, len(list))
+	for _, instrument := range list {
+		instruments[instrument.Symbol] = instrument
+	}
+	c.instruments = instruments
+	c.fetched = time.Now()
+	return nil
+}
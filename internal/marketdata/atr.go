@@ -0,0 +1,76 @@
+package marketdata
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ATR tracks the average true range of a price over fixed interval bars
+// built from streamed prices
+type ATR struct {
+	period   int
+	interval time.Duration
+
+	bar       *bar // the bar being built
+	prevClose decimal.Decimal
+	ranges    []decimal.Decimal // true ranges of the last period closed bars
+}
+
+type bar struct {
+	start            time.Time
+	high, low, close decimal.Decimal
+}
+
+// NewATR averages the true range of the last period bars of interval
+func NewATR(period int, interval time.Duration) *ATR {
+	if period < 1 {
+		period = 1
+	}
+	return &ATR{period: period, interval: interval}
+}
+
+// Add records a price observed at t
+func (a *ATR) Add(price decimal.Decimal, t time.Time) {
+	if !price.IsPositive() {
+		return
+	}
+	start := t.Truncate(a.interval)
+	if a.bar != nil && start.After(a.bar.start) {
+		a.close()
+	}
+	if a.bar == nil {
+		a.bar = &bar{start: start, high: price, low: price}
+	}
+	a.bar.high = decimal.Max(a.bar.high, price)
+	a.bar.low = decimal.Min(a.bar.low, price)
+	a.bar.close = price
+}
+
+// close moves the current bar into the average
+func (a *ATR) close() {
+	high, low := a.bar.high, a.bar.low
+	if a.prevClose.IsPositive() {
+		high = decimal.Max(high, a.prevClose)
+		low = decimal.Min(low, a.prevClose)
+	}
+	a.ranges = append(a.ranges, high.Sub(low))
+	if len(a.ranges) > a.period {
+		a.ranges = a.ranges[1:]
+	}
+	a.prevClose = a.bar.close
+	a.bar = nil
+}
+
+// Value returns the average true range of the closed bars. ok is false
+// until the first bar has closed.
+func (a *ATR) Value() (atr decimal.Decimal, ok bool) {
+	if len(a.ranges) == 0 {
+		return decimal.Zero, false
+	}
+	sum := decimal.Zero
+	for _, r := range a.ranges {
+		sum = sum.Add(r)
+	}
+	return sum.Div(decimal.NewFromInt(int64(len(a.ranges)))), true
+}
@@ -0,0 +1,265 @@
+// Package marketdata streams live market data from the exchange over a
+// WebSocket: trades and quotes, mark prices, funding rates and the top of
+// the order book. Once authenticated, the same connection carries the
+// account's order updates and fills.
+package marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// Channel is a kind of market data a symbol can be subscribed to
+type Channel string
+
+const (
+	// ChannelTicker carries the last traded price and best bid and ask
+	ChannelTicker Channel = "ticker"
+	// ChannelMark carries the mark and index price positions are valued at
+	ChannelMark Channel = "mark"
+	// ChannelFunding carries the current funding rate
+	ChannelFunding Channel = "funding"
+	// ChannelBook carries the best bid and ask with their sizes
+	ChannelBook Channel = "book"
+
+	// ChannelOrders carries the state of the account's orders after every
+	// change. It needs an authenticated stream.
+	ChannelOrders Channel = "orders"
+	// ChannelFills carries the account's executions. It needs an
+	// authenticated stream.
+	ChannelFills Channel = "fills"
+)
+
+// AllChannels lists every market data channel, e.g. to subscribe a symbol
+// to all of them
+var AllChannels = []Channel{ChannelTicker, ChannelMark, ChannelFunding, ChannelBook}
+
+// AccountChannels lists the private channels, which cover the whole
+// account rather than one symbol
+var AccountChannels = []Channel{ChannelOrders, ChannelFills}
+
+// IsAccount reports whether c is a private account channel
+func (c Channel) IsAccount() bool {
+	return c == ChannelOrders || c == ChannelFills
+}
+
+// Ticker is an update of the ticker channel
+type Ticker struct {
+	LastPrice decimal.Decimal `json:"last_price"`
+	BidPrice  decimal.Decimal `json:"bid_price"`
+	AskPrice  decimal.Decimal `json:"ask_price"`
+}
+
+// Mark is an update of the mark channel
+type Mark struct {
+	MarkPrice  decimal.Decimal `json:"mark_price"`
+	IndexPrice decimal.Decimal `json:"index_price"`
+}
+
+// Funding is an update of the funding channel. Rate is the fraction of
+// notional longs pay shorts at NextFunding, negative when shorts pay.
+type Funding struct {
+	Rate        float64   `json:"rate,string"`
+	NextFunding time.Time `json:"next_funding"`
+}
+
+// BookTop is an update of the book channel
+type BookTop struct {
+	BidPrice decimal.Decimal `json:"bid_price"`
+	BidSize  decimal.Decimal `json:"bid_size"`
+	AskPrice decimal.Decimal `json:"ask_price"`
+	AskSize  decimal.Decimal `json:"ask_size"`
+}
+
+// Update is one market data event. Only the field matching Channel is set.
+type Update struct {
+	Symbol    string
+	Channel   Channel
+	Timestamp time.Time
+
+	Ticker  Ticker
+	Mark    Mark
+	Funding Funding
+	Book    BookTop
+}
+
+// AccountUpdate is one event of the account channels. Order is set on the
+// orders channel and Fill on the fills channel.
+type AccountUpdate struct {
+	Channel   Channel
+	Timestamp time.Time
+
+	Order models.OrderUpdate
+	Fill  models.Fill
+}
+
+// Status reports the connection state of a stream
+type Status struct {
+	Connected bool
+	// Err is why the connection was lost, or an error the server reported
+	// on a live connection such as an unknown symbol
+	Err error
+	// Attempt counts reconnect attempts since the last connection
+	Attempt int
+}
+
+// Snapshot is the latest data received for a symbol across all channels
+type Snapshot struct {
+	Symbol    string
+	Ticker    Ticker
+	Mark      Mark
+	Funding   Funding
+	Book      BookTop
+	UpdatedAt time.Time
+}
+
+// apply merges an update into the snapshot
+func (s *Snapshot) apply(u Update) {
+	s.Symbol = u.Symbol
+	switch u.Channel {
+	case ChannelTicker:
+		s.Ticker = u.Ticker
+	case ChannelMark:
+		s.Mark = u.Mark
+	case ChannelFunding:
+		s.Funding = u.Funding
+	case ChannelBook:
+		s.Book = u.Book
+	}
+	s.UpdatedAt = u.Timestamp
+}
+
+// Stream operations sent in Message.Op
+const (
+	OpSubscribe     = "subscribe"
+	OpUnsubscribe   = "unsubscribe"
+	OpSubscribed    = "subscribed"
+	OpAuth          = "auth"
+	OpAuthenticated = "authenticated"
+	OpError         = "error"
+)
+
+// Message is a frame of the n0xtilus market data protocol. Clients send
+// subscribe and unsubscribe operations naming topics; the server
+// acknowledges them and then sends data messages carrying a channel,
+// symbol, time in Unix milliseconds and payload.
+//
+// The account channels need an auth operation first, carrying the API key,
+// the time and a signature made as for REST requests, over the time, GET
+// and the request URI of the stream.
+type Message struct {
+	Op        string          `json:"op,omitempty"`
+	Topics    []string        `json:"topics,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	APIKey    string          `json:"api_key,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Channel   Channel         `json:"channel,omitempty"`
+	Symbol    string          `json:"symbol,omitempty"`
+	Time      int64           `json:"time,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Topic names the subscription of a symbol to a channel, e.g.
+// "ticker:BTC/USDT". Account channels take no symbol and are named alone,
+// e.g. "orders".
+func Topic(channel Channel, symbol string) string {
+	if channel.IsAccount() {
+		return string(channel)
+	}
+	return string(channel) + ":" + symbol
+}
+
+// ParseTopic splits a topic into its channel and symbol. The symbol of an
+// account channel is empty.
+func ParseTopic(topic string) (Channel, string, error) {
+	channel, symbol, found := strings.Cut(topic, ":")
+	if Channel(channel).IsAccount() {
+		if found {
+			return "", "", fmt.Errorf("invalid topic %q: account channels take no symbol", topic)
+		}
+		return Channel(channel), "", nil
+	}
+	if !found || symbol == "" {
+		return "", "", fmt.Errorf("invalid topic %q", topic)
+	}
+	switch Channel(channel) {
+	case ChannelTicker, ChannelMark, ChannelFunding, ChannelBook:
+		return Channel(channel), symbol, nil
+	default:
+		return "", "", fmt.Errorf("unknown channel %q", channel)
+	}
+}
+
+// decode turns a data message into an update
+func (m Message) decode() (Update, error) {
+	u := Update{Symbol: m.Symbol, Channel: m.Channel, Timestamp: time.UnixMilli(m.Time)}
+	if m.Time == 0 {
+		u.Timestamp = time.Now()
+	}
+
+	var target interface{}
+	switch m.Channel {
+	case ChannelTicker:
+		target = &u.Ticker
+	case ChannelMark:
+		target = &u.Mark
+	case ChannelFunding:
+		target = &u.Funding
+	case ChannelBook:
+		target = &u.Book
+	default:
+		return Update{}, fmt.Errorf("unknown channel %q", m.Channel)
+	}
+	if err := json.Unmarshal(m.Data, target); err != nil {
+		return Update{}, fmt.Errorf("invalid %s data: %w", m.Channel, err)
+	}
+	return u, nil
+}
+
+// decodeAccount turns a data message of an account channel into an update
+func (m Message) decodeAccount() (AccountUpdate, error) {
+	u := AccountUpdate{Channel: m.Channel, Timestamp: time.UnixMilli(m.Time)}
+	if m.Time == 0 {
+		u.Timestamp = time.Now()
+	}
+
+	var target interface{}
+	switch m.Channel {
+	case ChannelOrders:
+		target = &u.Order
+	case ChannelFills:
+		target = &u.Fill
+	default:
+		return AccountUpdate{}, fmt.Errorf("unknown account channel %q", m.Channel)
+	}
+	if err := json.Unmarshal(m.Data, target); err != nil {
+		return AccountUpdate{}, fmt.Errorf("invalid %s data: %w", m.Channel, err)
+	}
+	return u, nil
+}
+
+// URLFromBaseURL derives the stream URL of an exchange speaking the
+// n0xtilus API from its REST base URL, e.g. https://api.example.com becomes
+// wss://api.example.com/ws
+func URLFromBaseURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid base URL scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+	return u.String(), nil
+}
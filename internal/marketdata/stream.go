@@ -0,0 +1,417 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sub0xdai/n0xtilus/internal/api"
+)
+
+// StreamConfig holds the connection settings of a stream
+type StreamConfig struct {
+	// PingInterval is how often a ping is sent. The connection is
+	// considered dead if nothing, not even a pong, arrives for PongWait.
+	PingInterval time.Duration
+	PongWait     time.Duration
+
+	// MinBackoff and MaxBackoff bound the jittered exponential delay
+	// between reconnect attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// BufferSize is how many updates are queued for a slow reader before
+	// new ones are dropped; Snapshot always has the latest. Account updates
+	// have a queue of the same size.
+	BufferSize int
+}
+
+// DefaultStreamConfig returns settings suitable for an interactive client
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		PingInterval: 15 * time.Second,
+		PongWait:     30 * time.Second,
+		MinBackoff:   500 * time.Millisecond,
+		MaxBackoff:   30 * time.Second,
+		BufferSize:   256,
+	}
+}
+
+const writeWait = 5 * time.Second
+
+// Stream is a market data connection that reconnects automatically and
+// restores its subscriptions after every reconnect
+type Stream struct {
+	url string
+	cfg StreamConfig
+
+	updates chan Update
+	account chan AccountUpdate
+	status  chan Status
+
+	mu        sync.Mutex
+	signIn    bool // set by Authenticate
+	apiKey    string
+	apiSecret string
+	topics    map[string]bool
+	conn      *websocket.Conn
+	snapshots map[string]Snapshot
+
+	// accountLive is set while the stream is authenticated. accountGaps
+	// counts the times account updates may have been missed since.
+	accountLive bool
+	accountGaps int
+}
+
+// NewStream creates a stream to url. Nothing connects until Run.
+func NewStream(url string, cfg StreamConfig) *Stream {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultStreamConfig().BufferSize
+	}
+	return &Stream{
+		url:       url,
+		cfg:       cfg,
+		updates:   make(chan Update, cfg.BufferSize),
+		account:   make(chan AccountUpdate, cfg.BufferSize),
+		status:    make(chan Status, 16),
+		topics:    make(map[string]bool),
+		snapshots: make(map[string]Snapshot),
+	}
+}
+
+// Updates returns the channel market data updates are delivered on
+func (s *Stream) Updates() <-chan Update {
+	return s.updates
+}
+
+// AccountUpdates returns the channel order updates and fills are delivered
+// on
+func (s *Stream) AccountUpdates() <-chan AccountUpdate {
+	return s.account
+}
+
+// AccountLive reports whether the stream is authenticated and delivering
+// account updates. gaps counts the times updates may have been missed,
+// through a lost connection or a reader falling behind, so a reader
+// reconciling orders knows when to catch up by polling.
+func (s *Stream) AccountLive() (live bool, gaps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accountLive, s.accountGaps
+}
+
+// Authenticate sets the API credentials the stream signs in with on every
+// connect. It takes effect from the next connection.
+func (s *Stream) Authenticate(apiKey, apiSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signIn, s.apiKey, s.apiSecret = true, apiKey, apiSecret
+}
+
+// Status returns the channel connection changes are delivered on
+func (s *Stream) Status() <-chan Status {
+	return s.status
+}
+
+// Snapshot returns the latest data received for symbol
+func (s *Stream) Snapshot(symbol string) (Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot, exists := s.snapshots[symbol]
+	return snapshot, exists
+}
+
+// Subscribe adds channels of symbol to the stream, all channels if none
+// are given. Subscriptions made while disconnected are sent on connect.
+func (s *Stream) Subscribe(symbol string, channels ...Channel) error {
+	return s.change(OpSubscribe, symbol, channels)
+}
+
+// Unsubscribe removes channels of symbol from the stream, all channels if
+// none are given
+func (s *Stream) Unsubscribe(symbol string, channels ...Channel) error {
+	return s.change(OpUnsubscribe, symbol, channels)
+}
+
+// SubscribeAccount adds the account channels to the stream. The stream must
+// be authenticated for the exchange to accept them.
+func (s *Stream) SubscribeAccount() error {
+	return s.change(OpSubscribe, "", AccountChannels)
+}
+
+func (s *Stream) change(op, symbol string, channels []Channel) error {
+	if len(channels) == 0 {
+		channels = AllChannels
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var topics []string
+	for _, channel := range channels {
+		topic := Topic(channel, symbol)
+		if s.topics[topic] == (op == OpSubscribe) {
+			continue
+		}
+		if op == OpSubscribe {
+			s.topics[topic] = true
+		} else {
+			delete(s.topics, topic)
+		}
+		topics = append(topics, topic)
+	}
+	if s.conn == nil || len(topics) == 0 {
+		return nil
+	}
+	return writeMessage(s.conn, Message{Op: op, Topics: topics})
+}
+
+// Run connects and delivers updates until ctx is done, reconnecting with
+// backoff whenever the connection fails
+func (s *Stream) Run(ctx context.Context) {
+	attempt := 0
+	for {
+		connected, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		s.notify(Status{Err: err, Attempt: attempt})
+
+		select {
+		case <-time.After(s.backoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// backoff returns the delay before reconnect attempt n, doubling from
+// MinBackoff up to MaxBackoff with up to half of it as random jitter
+func (s *Stream) backoff(attempt int) time.Duration {
+	delay := s.cfg.MinBackoff
+	for i := 1; i < attempt && delay < s.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.cfg.MaxBackoff)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// session runs one connection until it fails. connected reports whether
+// the connection was established.
+func (s *Stream) session(ctx context.Context) (connected bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to %s: %w", s.url, err)
+	}
+	defer conn.Close()
+
+	if err := s.attach(conn); err != nil {
+		return true, err
+	}
+	defer s.detach()
+	s.notify(Status{Connected: true})
+
+	// A missed heartbeat or cancellation closes the connection, which
+	// ends the read loop below
+	conn.SetReadDeadline(time.Now().Add(s.cfg.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.cfg.PongWait))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(ctx, conn, done)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, ctx.Err()
+			}
+			return true, fmt.Errorf("market data connection lost: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(s.cfg.PongWait))
+
+		// A malformed frame is skipped, the connection is still good
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Op {
+		case OpAuthenticated:
+			s.mu.Lock()
+			s.accountLive = true
+			s.mu.Unlock()
+		case OpError:
+			// e.g. an unknown symbol, the other subscriptions carry on
+			s.notify(Status{Connected: true, Err: fmt.Errorf("market data error: %s", msg.Error)})
+		case "":
+			if msg.Channel.IsAccount() {
+				update, err := msg.decodeAccount()
+				if err != nil {
+					// An order update or fill was lost, as when the
+					// reader falls behind
+					s.mu.Lock()
+					s.accountGaps++
+					s.mu.Unlock()
+					continue
+				}
+				s.deliverAccount(update)
+				continue
+			}
+			update, err := msg.decode()
+			if err != nil {
+				continue
+			}
+			s.deliver(update)
+		}
+	}
+}
+
+// attach makes conn the current connection, signs in if credentials are
+// set and resubscribes every topic
+func (s *Stream) attach(conn *websocket.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.signIn {
+		if err := writeMessage(conn, s.authMessage()); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if len(topics) > 0 {
+		if err := writeMessage(conn, Message{Op: OpSubscribe, Topics: topics}); err != nil {
+			return fmt.Errorf("failed to resubscribe: %w", err)
+		}
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *Stream) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = nil
+	if s.accountLive {
+		s.accountLive = false
+		s.accountGaps++
+	}
+}
+
+// authMessage signs the request URI of the stream with the API secret. The
+// caller must hold s.mu.
+func (s *Stream) authMessage() Message {
+	requestURI := "/"
+	if u, err := url.Parse(s.url); err == nil {
+		requestURI = u.RequestURI()
+	}
+	now := time.Now().UnixMilli()
+	return Message{
+		Op:        OpAuth,
+		APIKey:    s.apiKey,
+		Time:      now,
+		Signature: api.Sign(s.apiSecret, strconv.FormatInt(now, 10), http.MethodGet, requestURI, nil),
+	}
+}
+
+// heartbeat pings the server until done, and closes the connection when
+// ctx is cancelled
+func (s *Stream) heartbeat(ctx context.Context, conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				conn.Close()
+				return
+			}
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+			conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// deliver records an update and queues it for the reader, dropping it if
+// the reader has fallen behind
+func (s *Stream) deliver(u Update) {
+	s.mu.Lock()
+	snapshot := s.snapshots[u.Symbol]
+	snapshot.apply(u)
+	s.snapshots[u.Symbol] = snapshot
+	s.mu.Unlock()
+
+	select {
+	case s.updates <- u:
+	default:
+	}
+}
+
+// deliverAccount queues an account update for the reader. One that does
+// not fit is dropped and counted as a gap, the reader catches up by polling.
+func (s *Stream) deliverAccount(u AccountUpdate) {
+	select {
+	case s.account <- u:
+	default:
+		s.mu.Lock()
+		s.accountGaps++
+		s.mu.Unlock()
+	}
+}
+
+// notify queues a status change, dropping the oldest if the reader has
+// fallen behind so the latest state always arrives
+func (s *Stream) notify(status Status) {
+	for {
+		select {
+		case s.status <- status:
+			return
+		default:
+		}
+		select {
+		case <-s.status:
+		default:
+		}
+	}
+}
+
+// writeMessage sends a JSON frame. The caller must hold the stream lock,
+// which serialises writers.
+func writeMessage(conn *websocket.Conn, msg Message) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("failed to send to market data stream: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sub0xdai/n0xtilus/internal/api"
+)
+
+const testTimeout = 5 * time.Second
+
+// testServer accepts stream connections and hands them to the test
+type testServer struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{conns: make(chan *websocket.Conn, 4)}
+	upgrader := websocket.Upgrader{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		ts.conns <- conn
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) streamURL() string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// accept waits for the next connection
+func (ts *testServer) accept(t *testing.T) *websocket.Conn {
+	t.Helper()
+	select {
+	case conn := <-ts.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(testTimeout):
+		t.Fatal("stream did not connect")
+		return nil
+	}
+}
+
+// testConfig reconnects quickly and leaves the heartbeat out of the way
+func testConfig() StreamConfig {
+	return StreamConfig{
+		PingInterval: time.Minute,
+		PongWait:     time.Minute,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   20 * time.Millisecond,
+		BufferSize:   16,
+	}
+}
+
+// runStream runs s until the test ends
+func runStream(t *testing.T, s *Stream) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read from stream: %v", err)
+	}
+	return msg
+}
+
+func writeFrame(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write to stream: %v", err)
+	}
+}
+
+func tickerFrame(symbol, price string) string {
+	return `{"channel":"ticker","symbol":"` + symbol + `","time":1700000000000,"data":{"last_price":"` + price + `","bid_price":"` + price + `","ask_price":"` + price + `"}}`
+}
+
+// waitStatus returns the first status matching want
+func waitStatus(t *testing.T, s *Stream, want func(Status) bool) Status {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case status := <-s.Status():
+			if want(status) {
+				return status
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for stream status")
+		}
+	}
+}
+
+func waitUpdate(t *testing.T, s *Stream) Update {
+	t.Helper()
+	select {
+	case u := <-s.Updates():
+		return u
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for an update")
+		return Update{}
+	}
+}
+
+// eventually fails the test unless cond holds within the test timeout
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func connected(s Status) bool { return s.Connected && s.Err == nil }
+
+func TestStreamResubscribesAfterReconnect(t *testing.T) {
+	ts := newTestServer(t)
+	s := NewStream(ts.streamURL(), testConfig())
+	if err := s.Subscribe("BTC/USDT", ChannelTicker); err != nil {
+		t.Fatalf("subscribe while disconnected: %v", err)
+	}
+	runStream(t, s)
+
+	conn := ts.accept(t)
+	msg := readMessage(t, conn)
+	if msg.Op != OpSubscribe || !slices.Equal(msg.Topics, []string{"ticker:BTC/USDT"}) {
+		t.Fatalf("first frame = %+v, want the subscription made while disconnected", msg)
+	}
+	waitStatus(t, s, connected)
+
+	// Subscriptions while connected are sent at once
+	if err := s.Subscribe("ETH/USDT", ChannelTicker, ChannelMark); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	msg = readMessage(t, conn)
+	if msg.Op != OpSubscribe || !slices.Equal(msg.Topics, []string{"ticker:ETH/USDT", "mark:ETH/USDT"}) {
+		t.Fatalf("frame = %+v, want the new subscription", msg)
+	}
+	if err := s.Unsubscribe("ETH/USDT", ChannelMark); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	msg = readMessage(t, conn)
+	if msg.Op != OpUnsubscribe || !slices.Equal(msg.Topics, []string{"mark:ETH/USDT"}) {
+		t.Fatalf("frame = %+v, want the unsubscription", msg)
+	}
+
+	writeFrame(t, conn, tickerFrame("BTC/USDT", "50000"))
+	if u := waitUpdate(t, s); u.Symbol != "BTC/USDT" || u.Ticker.LastPrice.String() != "50000" {
+		t.Fatalf("update = %+v", u)
+	}
+
+	// The server drops the connection
+	conn.Close()
+	status := waitStatus(t, s, func(s Status) bool { return !s.Connected })
+	if status.Err == nil || status.Attempt != 1 {
+		t.Errorf("disconnect status = %+v, want an error and attempt 1", status)
+	}
+
+	conn = ts.accept(t)
+	msg = readMessage(t, conn)
+	if msg.Op != OpSubscribe || !slices.Equal(msg.Topics, []string{"ticker:BTC/USDT", "ticker:ETH/USDT"}) {
+		t.Fatalf("first frame after reconnect = %+v, want every topic resubscribed", msg)
+	}
+	waitStatus(t, s, connected)
+
+	writeFrame(t, conn, tickerFrame("ETH/USDT", "3000"))
+	if u := waitUpdate(t, s); u.Symbol != "ETH/USDT" || u.Ticker.LastPrice.String() != "3000" {
+		t.Fatalf("update after reconnect = %+v", u)
+	}
+	if snapshot, ok := s.Snapshot("BTC/USDT"); !ok || snapshot.Ticker.LastPrice.String() != "50000" {
+		t.Errorf("BTC/USDT snapshot = %+v, want the price from before the reconnect", snapshot)
+	}
+}
+
+func TestStreamBacksOffWhileServerIsDown(t *testing.T) {
+	ts := newTestServer(t)
+	url := ts.streamURL()
+	ts.Close()
+
+	s := NewStream(url, testConfig())
+	runStream(t, s)
+
+	for want := 1; want <= 3; want++ {
+		status := waitStatus(t, s, func(Status) bool { return true })
+		if status.Connected || status.Err == nil || status.Attempt != want {
+			t.Fatalf("status = %+v, want failed attempt %d", status, want)
+		}
+	}
+}
+
+func TestStreamReconnectsWhenServerGoesSilent(t *testing.T) {
+	ts := newTestServer(t)
+	cfg := testConfig()
+	cfg.PingInterval = 20 * time.Millisecond
+	cfg.PongWait = 100 * time.Millisecond
+	s := NewStream(ts.streamURL(), cfg)
+	runStream(t, s)
+
+	// The server never reads, so it never answers pings
+	ts.accept(t)
+	waitStatus(t, s, connected)
+	status := waitStatus(t, s, func(s Status) bool { return !s.Connected })
+	if status.Err == nil {
+		t.Errorf("status = %+v, want the lost connection's error", status)
+	}
+	ts.accept(t)
+	waitStatus(t, s, connected)
+}
+
+func TestStreamSkipsMalformedFrames(t *testing.T) {
+	ts := newTestServer(t)
+	s := NewStream(ts.streamURL(), testConfig())
+	runStream(t, s)
+
+	conn := ts.accept(t)
+	waitStatus(t, s, connected)
+
+	for _, frame := range []string{
+		`not json`,
+		`{"channel":"ticker","symbol":"BTC/USDT","data":{"last_price":"abc"}}`,
+		`{"channel":"candles","symbol":"BTC/USDT","data":{}}`,
+		`{"op":"subscribed","topics":["ticker:BTC/USDT"]}`,
+		tickerFrame("BTC/USDT", "50100"),
+	} {
+		writeFrame(t, conn, frame)
+	}
+	if u := waitUpdate(t, s); u.Ticker.LastPrice.String() != "50100" {
+		t.Fatalf("update = %+v, want the only valid data frame", u)
+	}
+
+	// The connection survived
+	writeFrame(t, conn, `{"op":"error","error":"unknown symbol FOO/USDT"}`)
+	status := waitStatus(t, s, func(Status) bool { return true })
+	if !status.Connected || status.Err == nil || !strings.Contains(status.Err.Error(), "FOO/USDT") {
+		t.Fatalf("status = %+v, want the server's error on a live connection", status)
+	}
+}
+
+func TestStreamDropsUpdatesForSlowReader(t *testing.T) {
+	ts := newTestServer(t)
+	cfg := testConfig()
+	cfg.BufferSize = 2
+	s := NewStream(ts.streamURL(), cfg)
+	runStream(t, s)
+
+	conn := ts.accept(t)
+	waitStatus(t, s, connected)
+	for _, price := range []string{"1", "2", "3", "4", "5"} {
+		writeFrame(t, conn, tickerFrame("BTC/USDT", price))
+	}
+
+	// Snapshot has the latest even though the queue overflowed
+	eventually(t, "the latest price", func() bool {
+		snapshot, _ := s.Snapshot("BTC/USDT")
+		return snapshot.Ticker.LastPrice.String() == "5"
+	})
+	var got []string
+	for len(s.Updates()) > 0 {
+		got = append(got, (<-s.Updates()).Ticker.LastPrice.String())
+	}
+	if !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("queued prices = %v, want the first 2 with the rest dropped", got)
+	}
+}
+
+func TestStreamAuthenticatesAndCountsAccountGaps(t *testing.T) {
+	ts := newTestServer(t)
+	cfg := testConfig()
+	cfg.BufferSize = 1
+	s := NewStream(ts.streamURL(), cfg)
+	s.Authenticate("key", "secret")
+	if err := s.SubscribeAccount(); err != nil {
+		t.Fatalf("subscribe account: %v", err)
+	}
+	runStream(t, s)
+
+	signIn := func(conn *websocket.Conn) {
+		t.Helper()
+		msg := readMessage(t, conn)
+		want := api.Sign("secret", strconv.FormatInt(msg.Time, 10), http.MethodGet, "/ws", nil)
+		if msg.Op != OpAuth || msg.APIKey != "key" || msg.Signature != want {
+			t.Fatalf("first frame = %+v, want a signed auth", msg)
+		}
+		if msg := readMessage(t, conn); msg.Op != OpSubscribe || !slices.Equal(msg.Topics, []string{"fills", "orders"}) {
+			t.Fatalf("frame after auth = %+v, want the account channels", msg)
+		}
+		writeFrame(t, conn, `{"op":"authenticated"}`)
+		eventually(t, "authentication", func() bool {
+			live, _ := s.AccountLive()
+			return live
+		})
+	}
+
+	conn := ts.accept(t)
+	signIn(conn)
+
+	order, err := json.Marshal(map[string]string{"order_id": "EX-1", "status": "NEW", "quantity": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFrame(t, conn, `{"channel":"orders","time":1700000000000,"data":`+string(order)+`}`)
+	select {
+	case u := <-s.AccountUpdates():
+		if u.Channel != ChannelOrders || u.Order.OrderID != "EX-1" {
+			t.Fatalf("account update = %+v", u)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the order update")
+	}
+
+	// A fill that cannot be decoded is lost like one dropped for a slow
+	// reader, and both are gaps
+	writeFrame(t, conn, `{"channel":"fills","data":{"quantity":[]}}`)
+	writeFrame(t, conn, `{"channel":"orders","data":{"order_id":"EX-2"}}`)
+	writeFrame(t, conn, `{"channel":"orders","data":{"order_id":"EX-3"}}`)
+	eventually(t, "two gaps", func() bool {
+		_, gaps := s.AccountLive()
+		return gaps == 2
+	})
+
+	// Losing the connection is a gap too, and the stream signs in again
+	conn.Close()
+	eventually(t, "the disconnect", func() bool {
+		live, gaps := s.AccountLive()
+		return !live && gaps == 3
+	})
+	signIn(ts.accept(t))
+}
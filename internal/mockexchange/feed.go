@@ -0,0 +1,59 @@
+package mockexchange
+
+import (
+	"math"
+	"math/rand"
+)
+
+// PriceFeed produces the sequence of prices for a simulated market
+type PriceFeed interface {
+	// Next returns the next price in the feed
+	Next() float64
+}
+
+// RandomWalk is a price feed following a geometric random walk
+type RandomWalk struct {
+	price      float64
+	volatility float64
+	rng        *rand.Rand
+}
+
+// NewRandomWalk creates a random walk starting at price. Volatility is the
+// standard deviation of each step as a fraction of the price.
+func NewRandomWalk(price, volatility float64, seed int64) *RandomWalk {
+	return &RandomWalk{
+		price:      price,
+		volatility: volatility,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next returns the next price in the walk
+func (w *RandomWalk) Next() float64 {
+	w.price *= math.Exp(w.rng.NormFloat64() * w.volatility)
+	return w.price
+}
+
+// ScriptedFeed replays a fixed list of prices, repeating the last one once
+// the script is exhausted
+type ScriptedFeed struct {
+	prices []float64
+	pos    int
+}
+
+// NewScriptedFeed creates a feed that replays prices in order
+func NewScriptedFeed(prices ...float64) *ScriptedFeed {
+	return &ScriptedFeed{prices: prices}
+}
+
+// Next returns the next scripted price
+func (f *ScriptedFeed) Next() float64 {
+	if len(f.prices) == 0 {
+		return 0
+	}
+	price := f.prices[f.pos]
+	if f.pos < len(f.prices)-1 {
+		f.pos++
+	}
+	return price
+}
@@ -0,0 +1,83 @@
+package mockexchange
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// minNotional is the smallest order value the mock accepts, in the quote
+// currency
+const minNotional = 5
+
+// instrumentFor derives trading rules from a market's price the way real
+// venues scale them: about five significant digits of price and a lot
+// worth roughly a tenth of the quote unit per price digit
+func instrumentFor(symbol string, price, maxLeverage float64) models.Instrument {
+	digits := int32(math.Floor(math.Log10(price)))
+	step := decimal.New(1, min(0, 1-digits))
+	return models.Instrument{
+		Symbol:             symbol,
+		TickSize:           decimal.New(1, digits-5),
+		StepSize:           step,
+		MinQuantity:        step,
+		MaxQuantity:        step.Mul(decimal.NewFromInt(10000000)),
+		MinNotional:        decimal.NewFromInt(minNotional),
+		MaxLeverage:        maxLeverage,
+		ContractMultiplier: decimal.NewFromInt(1),
+	}
+}
+
+func (s *Server) handleInstruments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instruments := make([]models.Instrument, 0, len(s.markets))
+	for _, m := range s.markets {
+		instruments = append(instruments, m.instrument)
+	}
+	sort.Slice(instruments, func(i, j int) bool { return instruments[i].Symbol < instruments[j].Symbol })
+	writeJSON(w, map[string]interface{}{"instruments": instruments})
+}
+
+// checkGrid rejects quantities and prices off the instrument's grid or
+// outside its limits. Orders that only reduce a position are exempt from
+// the minimum notional.
+func checkGrid(inst models.Instrument, params map[string]string, reference decimal.Decimal, reduceOnly bool) error {
+	quantity, err := decimal.NewFromString(params["quantity"])
+	if err != nil {
+		return fmt.Errorf("invalid quantity")
+	}
+	if !inst.OnStep(quantity) {
+		return fmt.Errorf("quantity %s is not a multiple of the step size %s", quantity, inst.StepSize)
+	}
+	if quantity.LessThan(inst.MinQuantity) {
+		return fmt.Errorf("quantity %s is below the minimum %s", quantity, inst.MinQuantity)
+	}
+	if inst.MaxQuantity.IsPositive() && quantity.GreaterThan(inst.MaxQuantity) {
+		return fmt.Errorf("quantity %s is above the maximum %s", quantity, inst.MaxQuantity)
+	}
+
+	for _, key := range []string{"price", "stop_price"} {
+		if params[key] == "" {
+			continue
+		}
+		price, err := decimal.NewFromString(params[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s", key)
+		}
+		if !inst.OnTick(price) {
+			return fmt.Errorf("%s %s is not a multiple of the tick size %s", key, price, inst.TickSize)
+		}
+	}
+
+	notional := inst.Notional(quantity, reference)
+	if !reduceOnly && notional.LessThan(inst.MinNotional) {
+		return fmt.Errorf("order value %s is below the minimum %s", notional.StringFixed(2), inst.MinNotional)
+	}
+	return nil
+}
@@ -0,0 +1,826 @@
+// Package mockexchange implements an in-memory exchange speaking the
+// n0xtilus REST API. It keeps a balance, matches orders against a simulated
+// price feed and reports fills and positions, so the whole trade flow can be
+// exercised offline.
+package mockexchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/api"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// Config holds the mock exchange settings
+type Config struct {
+	APIKey       string
+	APISecret    string
+	Balance      decimal.Decimal
+	Prices       map[string]float64 // initial price by symbol
+	FeeRate      float64
+	Spread       float64 // bid/ask spread as a fraction of the price
+	Volatility   float64 // random walk step as a fraction of the price
+	MaxLeverage  float64
+	FundingRate  float64 // per funding interval, paid by longs when positive
+	TickInterval time.Duration
+	Seed         int64
+}
+
+// DefaultConfig returns a config with a funded account and a few markets
+func DefaultConfig() Config {
+	return Config{
+		Balance: decimal.NewFromInt(10000),
+		Prices: map[string]float64{
+			"BTC/USDT": 50000,
+			"ETH/USDT": 3000,
+			"SOL/USDT": 150,
+			"XRP/USDT": 0.6,
+			"ADA/USDT": 0.45,
+		},
+		FeeRate:      0.0005,
+		Spread:       0.0002,
+		Volatility:   0.0005,
+		MaxLeverage:  100,
+		FundingRate:  0.0001,
+		TickInterval: time.Second,
+		Seed:         time.Now().UnixNano(),
+	}
+}
+
+type market struct {
+	price      decimal.Decimal // the feed's latest price, on the tick
+	feed       PriceFeed
+	instrument models.Instrument
+}
+
+type order struct {
+	id         string
+	clientID   string
+	symbol     string
+	side       string
+	typ        models.OrderType
+	tif        models.TimeInForce
+	quantity   decimal.Decimal
+	price      decimal.Decimal
+	stopPrice  decimal.Decimal
+	trailing   decimal.Decimal // trailing stops, distance kept from the best price
+	triggered  bool            // stop orders, set once the stop price trades
+	reduceOnly bool
+	oco        string // one-cancels-other group, empty for none
+
+	status   models.OrderStatus
+	filled   decimal.Decimal
+	avgPrice decimal.Decimal
+}
+
+type position struct {
+	size  decimal.Decimal // positive for long, negative for short
+	entry decimal.Decimal
+}
+
+// Server is an in-memory exchange
+type Server struct {
+	mu        sync.Mutex
+	cfg       Config
+	balance   decimal.Decimal
+	markets   map[string]*market
+	orders    map[string]*order // resting
+	closed    map[string]*order // filled, cancelled or expired
+	positions map[string]*position
+	fills     []models.Fill
+	nextID    int
+	nextOCO   int
+	nextFill  int
+	mux       *http.ServeMux
+
+	subscribers map[*subscriber]struct{}
+}
+
+// NewServer creates a mock exchange from config. Each market follows a
+// random walk until SetFeed replaces its feed.
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		cfg:       cfg,
+		balance:   cfg.Balance,
+		markets:   make(map[string]*market),
+		orders:    make(map[string]*order),
+		closed:    make(map[string]*order),
+		positions: make(map[string]*position),
+		mux:       http.NewServeMux(),
+
+		subscribers: make(map[*subscriber]struct{}),
+	}
+
+	seed := cfg.Seed
+	for symbol, price := range cfg.Prices {
+		seed++
+		m := &market{
+			feed:       NewRandomWalk(price, cfg.Volatility, seed),
+			instrument: instrumentFor(symbol, price, cfg.MaxLeverage),
+		}
+		m.setPrice(price)
+		s.markets[symbol] = m
+	}
+
+	s.mux.HandleFunc("GET /balance", s.handleBalance)
+	s.mux.HandleFunc("GET /markets", s.handleMarkets)
+	s.mux.HandleFunc("GET /ticker", s.handleTicker)
+	s.mux.HandleFunc("GET /instruments", s.handleInstruments)
+	s.mux.HandleFunc("GET /order", s.handleGetOrder)
+	s.mux.HandleFunc("GET /orders", s.handleOpenOrders)
+	s.mux.HandleFunc("POST /order", s.handlePlaceOrder)
+	s.mux.HandleFunc("PUT /order", s.handleAmendOrder)
+	s.mux.HandleFunc("DELETE /order", s.handleCancelOrder)
+	s.mux.HandleFunc("POST /oco", s.handleLinkOCO)
+	s.mux.HandleFunc("GET /positions", s.handlePositions)
+	s.mux.HandleFunc("GET /fills", s.handleFills)
+	s.mux.HandleFunc("GET /ws", s.handleStream)
+	return s
+}
+
+// SetFeed replaces the price feed of a market, adding the market if needed
+func (s *Server) SetFeed(symbol string, feed PriceFeed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, exists := s.markets[symbol]
+	if !exists {
+		m = &market{}
+		s.markets[symbol] = m
+	}
+	m.feed = feed
+	price := feed.Next()
+	if !exists {
+		m.instrument = instrumentFor(symbol, price, s.cfg.MaxLeverage)
+	}
+	m.setPrice(price)
+}
+
+// setPrice sets the market price from the feed, rounded to the tick
+func (m *market) setPrice(price float64) {
+	m.price = m.instrument.RoundPrice(decimal.NewFromFloat(price), models.RoundHalfEven)
+}
+
+// Tick advances every price feed one step and matches resting orders
+func (s *Server) Tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.markets {
+		m.setPrice(m.feed.Next())
+	}
+	for _, o := range s.sortedOrders() {
+		if _, resting := s.orders[o.id]; !resting {
+			// Cancelled by an earlier OCO fill this tick
+			continue
+		}
+		if price, ok := s.matchPrice(o, false); ok {
+			s.fill(o, price)
+		}
+	}
+	s.broadcast()
+}
+
+// Run ticks the price feeds at the configured interval until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	interval := s.cfg.TickInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Tick()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start serves the mock exchange on addr and runs the price feeds until ctx
+// is done. It returns the base URL of the server.
+func (s *Server) Start(ctx context.Context, addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{Handler: s}
+	go srv.Serve(ln)
+	go s.Run(ctx)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	return "http://" + ln.Addr().String(), nil
+}
+
+// ServeHTTP authenticates the request and dispatches it. The stream is
+// public; clients sign in over it to receive account updates.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.APISecret != "" && r.URL.Path != "/ws" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "failed to read body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		timestamp := r.Header.Get(api.HeaderTimestamp)
+		expected := api.Sign(s.cfg.APISecret, timestamp, r.Method, r.URL.RequestURI(), body)
+		if r.Header.Get(api.HeaderAPIKey) != s.cfg.APIKey || r.Header.Get(api.HeaderSignature) != expected {
+			writeError(w, http.StatusUnauthorized, api.CodeUnauthorized, "invalid API key or signature")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, map[string]decimal.Decimal{"balance": s.balance})
+}
+
+func (s *Server) handleMarkets(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	markets := make([]models.Market, 0, len(s.markets))
+	for symbol := range s.markets {
+		base, quote, _ := strings.Cut(symbol, "/")
+		markets = append(markets, models.Market{Symbol: symbol, Base: base, Quote: quote})
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Symbol < markets[j].Symbol })
+	writeJSON(w, map[string]interface{}{"markets": markets})
+}
+
+func (s *Server) handleTicker(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	symbol := r.URL.Query().Get("symbol")
+	m, exists := s.markets[symbol]
+	if !exists {
+		writeError(w, http.StatusNotFound, api.CodeUnknownSymbol, "unknown symbol "+symbol)
+		return
+	}
+	writeJSON(w, models.Ticker{
+		Symbol:    symbol,
+		LastPrice: m.price,
+		MarkPrice: m.price,
+		BidPrice:  s.bid(m),
+		AskPrice:  s.ask(m),
+		Timestamp: time.Now(),
+	})
+}
+
+func (s *Server) handlePlaceOrder(w http.ResponseWriter, r *http.Request) {
+	params, err := decodeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &order{
+		clientID:   params["client_order_id"],
+		symbol:     params["symbol"],
+		side:       strings.ToUpper(params["side"]),
+		typ:        models.OrderType(params["type"]),
+		tif:        models.TimeInForce(params["time_in_force"]),
+		reduceOnly: params["reduce_only"] == "true",
+	}
+	if o.typ == "" {
+		o.typ = models.OrderTypeLimit
+	}
+	if o.tif == "" {
+		o.tif = models.TimeInForceGTC
+	}
+	postOnly := params["post_only"] == "true"
+	if o.clientID != "" && s.byClientID(o.clientID) != nil {
+		writeError(w, http.StatusConflict, api.CodeDuplicateOrder, "client order ID already used: "+o.clientID)
+		return
+	}
+
+	m, exists := s.markets[o.symbol]
+	if !exists {
+		writeError(w, http.StatusBadRequest, api.CodeUnknownSymbol, "unknown symbol "+o.symbol)
+		return
+	}
+	if o.side != "BUY" && o.side != "SELL" {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "side must be BUY or SELL")
+		return
+	}
+	if o.quantity, err = parsePositive(params["quantity"]); err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "invalid quantity")
+		return
+	}
+
+	switch o.typ {
+	case models.OrderTypeLimit:
+		o.price, err = parsePositive(params["price"])
+	case models.OrderTypeStopMarket:
+		o.stopPrice, err = parsePositive(params["stop_price"])
+	case models.OrderTypeStopLimit:
+		if o.stopPrice, err = parsePositive(params["stop_price"]); err == nil {
+			o.price, err = parsePositive(params["price"])
+		}
+	case models.OrderTypeTrailingStop:
+		if o.stopPrice, err = parsePositive(params["stop_price"]); err == nil {
+			o.trailing, err = parsePositive(params["trailing_distance"])
+		}
+	case models.OrderTypeMarket:
+	default:
+		err = fmt.Errorf("unknown order type %q", o.typ)
+	}
+	if err == nil && postOnly && o.typ != models.OrderTypeLimit {
+		err = errors.New("post-only requires a limit order")
+	}
+	switch o.tif {
+	case models.TimeInForceGTC, models.TimeInForceIOC, models.TimeInForceFOK:
+	default:
+		err = fmt.Errorf("unknown time in force %q", o.tif)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+
+	reference := o.price
+	if reference.IsZero() {
+		reference = m.price
+	}
+	if err := checkGrid(m.instrument, params, reference, o.reduceOnly); err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+	if !o.reduceOnly && o.quantity.Mul(reference).GreaterThan(s.balance.Mul(decimal.NewFromFloat(s.cfg.MaxLeverage))) {
+		writeError(w, http.StatusBadRequest, api.CodeInsufficientBalance, "order exceeds available margin")
+		return
+	}
+
+	price, marketable := s.matchPrice(o, true)
+	if postOnly && marketable {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "post-only order would take liquidity")
+		return
+	}
+
+	s.nextID++
+	o.id = fmt.Sprintf("MOCK-%d", s.nextID)
+
+	// Orders fill in full, so IOC and FOK behave alike: anything not filled
+	// on placement expires, as do market orders with nothing to reduce
+	switch {
+	case marketable:
+		s.fill(o, price)
+	case o.typ == models.OrderTypeMarket || o.tif != models.TimeInForceGTC:
+		s.closeOrder(o, models.OrderStatusExpired)
+	default:
+		o.status = models.OrderStatusOpen
+		s.orders[o.id] = o
+		s.publishOrder(o)
+	}
+
+	writeJSON(w, map[string]string{"order_id": o.id, "status": string(o.status)})
+}
+
+func (s *Server) handleAmendOrder(w http.ResponseWriter, r *http.Request) {
+	params, err := decodeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, exists := s.orders[params["order_id"]]
+	if !exists {
+		writeError(w, http.StatusNotFound, api.CodeOrderNotFound, "order not found")
+		return
+	}
+
+	quantity, qtyErr := parsePositive(params["quantity"])
+	price, priceErr := parsePositive(params["price"])
+	if qtyErr != nil || priceErr != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "invalid quantity or price")
+		return
+	}
+	if err := checkGrid(s.markets[o.symbol].instrument, params, price, o.reduceOnly); err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+
+	o.quantity = quantity
+	if o.typ == models.OrderTypeStopMarket || o.typ == models.OrderTypeTrailingStop || (o.typ == models.OrderTypeStopLimit && !o.triggered) {
+		o.stopPrice = price
+	} else {
+		o.price = price
+	}
+
+	if fillPrice, ok := s.matchPrice(o, true); ok {
+		s.fill(o, fillPrice)
+	} else {
+		s.publishOrder(o)
+	}
+	writeJSON(w, map[string]string{"order_id": o.id})
+}
+
+func (s *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orderID := r.URL.Query().Get("order_id")
+	o, exists := s.orders[orderID]
+	if !exists {
+		writeError(w, http.StatusNotFound, api.CodeOrderNotFound, "order not found")
+		return
+	}
+	s.closeOrder(o, models.OrderStatusCanceled)
+	writeJSON(w, map[string]string{"order_id": orderID})
+}
+
+func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orderID := r.URL.Query().Get("order_id")
+	o, exists := s.orders[orderID]
+	if !exists {
+		o, exists = s.closed[orderID]
+	}
+	if clientID := r.URL.Query().Get("client_order_id"); orderID == "" && clientID != "" {
+		o = s.byClientID(clientID)
+		exists = o != nil
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, api.CodeOrderNotFound, "order not found")
+		return
+	}
+	writeJSON(w, o.update())
+}
+
+func (s *Server) handleOpenOrders(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	symbol := r.URL.Query().Get("symbol")
+	orders := make([]models.OrderUpdate, 0, len(s.orders))
+	for _, o := range s.sortedOrders() {
+		if symbol == "" || o.symbol == symbol {
+			orders = append(orders, o.update())
+		}
+	}
+	writeJSON(w, map[string]interface{}{"orders": orders})
+}
+
+func (s *Server) handleLinkOCO(w http.ResponseWriter, r *http.Request) {
+	params, err := decodeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := strings.Split(params["order_ids"], ",")
+	if len(ids) < 2 {
+		writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "at least two order IDs required")
+		return
+	}
+	orders := make([]*order, 0, len(ids))
+	for _, id := range ids {
+		o, exists := s.orders[strings.TrimSpace(id)]
+		if !exists {
+			writeError(w, http.StatusNotFound, api.CodeOrderNotFound, "order not found: "+id)
+			return
+		}
+		if len(orders) > 0 && o.symbol != orders[0].symbol {
+			writeError(w, http.StatusBadRequest, api.CodeInvalidParams, "OCO orders must share a symbol")
+			return
+		}
+		orders = append(orders, o)
+	}
+
+	s.nextOCO++
+	group := fmt.Sprintf("OCO-%d", s.nextOCO)
+	for _, o := range orders {
+		o.oco = group
+	}
+	writeJSON(w, map[string]string{"oco_id": group})
+}
+
+func (s *Server) handlePositions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make([]models.Position, 0, len(s.positions))
+	for symbol, p := range s.positions {
+		side := "LONG"
+		if p.size.IsNegative() {
+			side = "SHORT"
+		}
+		mark := s.markets[symbol].price
+		positions = append(positions, models.Position{
+			Symbol:        symbol,
+			Side:          side,
+			Size:          p.size.Abs(),
+			EntryPrice:    p.entry,
+			MarkPrice:     mark,
+			UnrealizedPnL: p.size.Mul(mark.Sub(p.entry)),
+		})
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	writeJSON(w, map[string]interface{}{"positions": positions})
+}
+
+func (s *Server) handleFills(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	symbol := r.URL.Query().Get("symbol")
+	fills := make([]models.Fill, 0, len(s.fills))
+	for _, f := range s.fills {
+		if symbol == "" || f.Symbol == symbol {
+			fills = append(fills, f)
+		}
+	}
+	writeJSON(w, map[string]interface{}{"fills": fills})
+}
+
+// matchPrice reports whether an order is executable at the current price and
+// at what price it fills. Limit orders cross the spread on placement and
+// fill at their limit price once resting. Stop orders trigger once the price
+// trades through their stop; a triggered stop-limit then matches as a limit
+// order. Trailing stops first move their stop after a favourable price.
+// Reduce-only orders wait while there is no opposite position to reduce.
+func (s *Server) matchPrice(o *order, onPlacement bool) (decimal.Decimal, bool) {
+	if o.reduceOnly && s.reducible(o).IsZero() {
+		return decimal.Zero, false
+	}
+
+	m := s.markets[o.symbol]
+	price, bid, ask := m.price, s.bid(m), s.ask(m)
+
+	switch o.typ {
+	case models.OrderTypeMarket:
+		return touch(o.side, bid, ask), true
+	case models.OrderTypeStopMarket, models.OrderTypeStopLimit, models.OrderTypeTrailingStop:
+		if o.typ == models.OrderTypeTrailingStop {
+			// Matching runs under s.mu, so the stop can be moved here
+			if o.side == "SELL" {
+				o.stopPrice = decimal.Max(o.stopPrice, price.Sub(o.trailing))
+			} else {
+				o.stopPrice = decimal.Min(o.stopPrice, price.Add(o.trailing))
+			}
+		}
+		if !o.triggered {
+			if (o.side == "BUY" && price.LessThan(o.stopPrice)) || (o.side == "SELL" && price.GreaterThan(o.stopPrice)) {
+				return decimal.Zero, false
+			}
+			// Matching runs under s.mu, so the trigger can be recorded here
+			o.triggered = true
+			onPlacement = true
+		}
+		if o.typ != models.OrderTypeStopLimit {
+			return touch(o.side, bid, ask), true
+		}
+		return matchLimit(o, bid, ask, onPlacement)
+	case models.OrderTypeLimit:
+		return matchLimit(o, bid, ask, onPlacement)
+	}
+	return decimal.Zero, false
+}
+
+// matchLimit matches a limit order against the touch. Taking orders fill at
+// the touch, resting orders at their limit price.
+func matchLimit(o *order, bid, ask decimal.Decimal, taking bool) (decimal.Decimal, bool) {
+	if o.side == "BUY" && ask.LessThanOrEqual(o.price) {
+		if taking {
+			return ask, true
+		}
+		return o.price, true
+	}
+	if o.side == "SELL" && bid.GreaterThanOrEqual(o.price) {
+		if taking {
+			return bid, true
+		}
+		return o.price, true
+	}
+	return decimal.Zero, false
+}
+
+// touch returns the price a marketable order on side fills at
+func touch(side string, bid, ask decimal.Decimal) decimal.Decimal {
+	if side == "BUY" {
+		return ask
+	}
+	return bid
+}
+
+// fill executes an order in full, updating the position and balance.
+// Reduce-only orders are clamped to the position size.
+func (s *Server) fill(o *order, price decimal.Decimal) {
+	quantity := o.quantity
+	if o.reduceOnly {
+		quantity = decimal.Min(quantity, s.reducible(o))
+	}
+
+	signed := quantity
+	if o.side == "SELL" {
+		signed = signed.Neg()
+	}
+
+	fee := quantity.Mul(price).Mul(decimal.NewFromFloat(s.cfg.FeeRate))
+	s.balance = s.balance.Sub(fee)
+
+	p, exists := s.positions[o.symbol]
+	if !exists {
+		p = &position{}
+		s.positions[o.symbol] = p
+	}
+
+	if p.size.IsZero() || p.size.Sign() == signed.Sign() {
+		size := p.size.Abs()
+		p.entry = size.Mul(p.entry).Add(quantity.Mul(price)).Div(size.Add(quantity))
+		p.size = p.size.Add(signed)
+	} else {
+		closing := decimal.Min(quantity, p.size.Abs())
+		direction := decimal.NewFromInt(int64(p.size.Sign()))
+		s.balance = s.balance.Add(closing.Mul(price.Sub(p.entry)).Mul(direction))
+		p.size = p.size.Add(signed)
+		if !p.size.IsZero() && p.size.Sign() != direction.Sign() {
+			// Position reversed, the remainder opened at the fill price
+			p.entry = price
+		}
+	}
+
+	s.nextFill++
+	f := models.Fill{
+		ID:        fmt.Sprintf("FILL-%d", s.nextFill),
+		OrderID:   o.id,
+		Symbol:    o.symbol,
+		Side:      o.side,
+		Quantity:  quantity,
+		Price:     price,
+		Fee:       fee,
+		Timestamp: time.Now(),
+	}
+	s.fills = append(s.fills, f)
+	s.publishFill(f)
+
+	o.filled, o.avgPrice = quantity, price
+	s.closeOrder(o, models.OrderStatusFilled)
+
+	if p.size.IsZero() {
+		delete(s.positions, o.symbol)
+		s.cancelOCO(o)
+	}
+}
+
+// cancelOCO cancels the resting orders linked to a filled order once its
+// position is flat
+func (s *Server) cancelOCO(filled *order) {
+	if filled.oco == "" {
+		return
+	}
+	for _, o := range s.sortedOrders() {
+		if o.oco == filled.oco {
+			s.closeOrder(o, models.OrderStatusCanceled)
+		}
+	}
+}
+
+// closeOrder takes an order off the book with its final status and
+// publishes the change
+func (s *Server) closeOrder(o *order, status models.OrderStatus) {
+	delete(s.orders, o.id)
+	o.status = status
+	s.closed[o.id] = o
+	s.publishOrder(o)
+}
+
+// update returns the order as the exchange reports it
+func (o *order) update() models.OrderUpdate {
+	u := models.OrderUpdate{
+		OrderID:        o.id,
+		ClientOrderID:  o.clientID,
+		Symbol:         o.symbol,
+		Status:         o.status,
+		Quantity:       o.quantity,
+		FilledQuantity: o.filled,
+		Timestamp:      time.Now(),
+	}
+	if o.filled.IsPositive() {
+		u.AveragePrice = o.avgPrice
+	}
+	return u
+}
+
+// reducible returns how much of the open position a reduce-only order may
+// close
+func (s *Server) reducible(o *order) decimal.Decimal {
+	p, exists := s.positions[o.symbol]
+	if !exists || (p.size.IsPositive() && o.side == "BUY") || (p.size.IsNegative() && o.side == "SELL") {
+		return decimal.Zero
+	}
+	return p.size.Abs()
+}
+
+// byClientID returns the resting or closed order placed with a client order
+// ID, nil if there is none
+func (s *Server) byClientID(clientID string) *order {
+	for _, orders := range []map[string]*order{s.orders, s.closed} {
+		for _, o := range orders {
+			if o.clientID == clientID {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// sortedOrders returns resting orders in placement order
+func (s *Server) sortedOrders() []*order {
+	orders := make([]*order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orderSeq(orders[i].id) < orderSeq(orders[j].id)
+	})
+	return orders
+}
+
+// bid returns the best bid of a market, half the spread below its price
+// and rounded down to the tick
+func (s *Server) bid(m *market) decimal.Decimal {
+	return m.instrument.RoundPrice(m.price.Mul(decimal.NewFromInt(1).Sub(s.halfSpread())), models.RoundFloor)
+}
+
+// ask returns the best ask of a market, half the spread above its price
+// and rounded up to the tick
+func (s *Server) ask(m *market) decimal.Decimal {
+	return m.instrument.RoundPrice(m.price.Mul(decimal.NewFromInt(1).Add(s.halfSpread())), models.RoundCeil)
+}
+
+func (s *Server) halfSpread() decimal.Decimal {
+	return decimal.NewFromFloat(s.cfg.Spread).Div(decimal.NewFromInt(2))
+}
+
+func orderSeq(id string) int {
+	seq, _ := strconv.Atoi(strings.TrimPrefix(id, "MOCK-"))
+	return seq
+}
+
+func decodeParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	return params, nil
+}
+
+func parsePositive(value string) (decimal.Decimal, error) {
+	v, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !v.IsPositive() {
+		return decimal.Zero, fmt.Errorf("value must be positive")
+	}
+	return v, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.APIError{Code: code, Message: message})
+}
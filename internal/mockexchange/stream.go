@@ -0,0 +1,267 @@
+package mockexchange
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/api"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// fundingInterval is the time between funding payments
+const fundingInterval = 8 * time.Hour
+
+// bookTopNotional is the quote value resting at the best bid and ask
+const bookTopNotional = 250000
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriber is a market data stream connection
+type subscriber struct {
+	conn          *websocket.Conn
+	send          chan []byte
+	topics        map[string]bool
+	requestURI    string // signed by the auth operation
+	authenticated bool
+}
+
+// handleStream serves the market data stream. Clients subscribe to topics
+// and receive an update for each of them on every tick. Authenticated
+// clients may subscribe to the account channels, which carry every order
+// change and fill as it happens.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	sub := &subscriber{
+		conn:       conn,
+		send:       make(chan []byte, 256),
+		topics:     make(map[string]bool),
+		requestURI: r.URL.RequestURI(),
+	}
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	go sub.writeLoop()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+		close(sub.send)
+		conn.Close()
+	}()
+
+	for {
+		var msg marketdata.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		s.handleStreamMessage(sub, msg)
+	}
+}
+
+// handleStreamMessage applies an auth, subscribe or unsubscribe request.
+// New market data topics get the current data straight away.
+func (s *Server) handleStreamMessage(sub *subscriber, msg marketdata.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Op {
+	case marketdata.OpAuth:
+		s.authenticate(sub, msg)
+		return
+	case marketdata.OpSubscribe, marketdata.OpUnsubscribe:
+	default:
+		sub.queue(marketdata.Message{Op: marketdata.OpError, Error: "unknown op " + msg.Op})
+		return
+	}
+
+	var accepted []string
+	var initial []marketdata.Message
+	for _, topic := range msg.Topics {
+		channel, symbol, err := marketdata.ParseTopic(topic)
+		if err != nil {
+			sub.queue(marketdata.Message{Op: marketdata.OpError, Error: err.Error()})
+			continue
+		}
+		if channel.IsAccount() {
+			if !sub.authenticated && msg.Op == marketdata.OpSubscribe {
+				sub.queue(marketdata.Message{Op: marketdata.OpError, Error: "authentication required for " + topic})
+				continue
+			}
+			accepted = append(accepted, topic)
+			if msg.Op == marketdata.OpSubscribe {
+				sub.topics[topic] = true
+			} else {
+				delete(sub.topics, topic)
+			}
+			continue
+		}
+		m, exists := s.markets[symbol]
+		if !exists {
+			sub.queue(marketdata.Message{Op: marketdata.OpError, Error: "unknown symbol " + symbol})
+			continue
+		}
+		accepted = append(accepted, topic)
+
+		if msg.Op == marketdata.OpUnsubscribe {
+			delete(sub.topics, topic)
+			continue
+		}
+		if !sub.topics[topic] {
+			sub.topics[topic] = true
+			initial = append(initial, s.streamUpdate(channel, symbol, m))
+		}
+	}
+
+	op := marketdata.OpSubscribed
+	if msg.Op == marketdata.OpUnsubscribe {
+		op = marketdata.OpUnsubscribe
+	}
+	sub.queue(marketdata.Message{Op: op, Topics: accepted})
+	for _, update := range initial {
+		sub.queue(update)
+	}
+}
+
+// authenticate checks the signature of an auth request as for REST
+// requests, signing the request URI of the stream. The caller must hold
+// s.mu.
+func (s *Server) authenticate(sub *subscriber, msg marketdata.Message) {
+	if s.cfg.APISecret != "" {
+		expected := api.Sign(s.cfg.APISecret, strconv.FormatInt(msg.Time, 10), http.MethodGet, sub.requestURI, nil)
+		if msg.APIKey != s.cfg.APIKey || msg.Signature != expected {
+			sub.queue(marketdata.Message{Op: marketdata.OpError, Error: "invalid API key or signature"})
+			return
+		}
+	}
+	sub.authenticated = true
+	sub.queue(marketdata.Message{Op: marketdata.OpAuthenticated})
+}
+
+// broadcast sends every subscriber the data of its topics. The caller must
+// hold s.mu.
+func (s *Server) broadcast() {
+	for sub := range s.subscribers {
+		for topic := range sub.topics {
+			channel, symbol, _ := marketdata.ParseTopic(topic)
+			if channel.IsAccount() {
+				continue
+			}
+			if m, exists := s.markets[symbol]; exists {
+				sub.queue(s.streamUpdate(channel, symbol, m))
+			}
+		}
+	}
+}
+
+// publishOrder sends the state of an order to the clients subscribed to
+// the orders channel. The caller must hold s.mu.
+func (s *Server) publishOrder(o *order) {
+	s.publishAccount(marketdata.ChannelOrders, o.update())
+}
+
+// publishFill sends a fill to the clients subscribed to the fills channel.
+// The caller must hold s.mu.
+func (s *Server) publishFill(f models.Fill) {
+	s.publishAccount(marketdata.ChannelFills, f)
+}
+
+func (s *Server) publishAccount(channel marketdata.Channel, data interface{}) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	msg := marketdata.Message{Channel: channel, Time: time.Now().UnixMilli(), Data: raw}
+	for sub := range s.subscribers {
+		if sub.authenticated && sub.topics[marketdata.Topic(channel, "")] {
+			sub.queue(msg)
+		}
+	}
+}
+
+// DisconnectStreams drops every market data connection, e.g. to exercise
+// client reconnects
+func (s *Server) DisconnectStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subscribers {
+		sub.conn.Close()
+	}
+}
+
+// streamUpdate builds the data message of a channel for a market. The
+// caller must hold s.mu.
+func (s *Server) streamUpdate(channel marketdata.Channel, symbol string, m *market) marketdata.Message {
+	now := time.Now()
+
+	var data interface{}
+	switch channel {
+	case marketdata.ChannelTicker:
+		data = marketdata.Ticker{
+			LastPrice: m.price,
+			BidPrice:  s.bid(m),
+			AskPrice:  s.ask(m),
+		}
+	case marketdata.ChannelMark:
+		data = marketdata.Mark{
+			MarkPrice:  m.price,
+			IndexPrice: m.price,
+		}
+	case marketdata.ChannelFunding:
+		data = marketdata.Funding{
+			Rate:        s.cfg.FundingRate,
+			NextFunding: now.UTC().Truncate(fundingInterval).Add(fundingInterval),
+		}
+	case marketdata.ChannelBook:
+		size := m.instrument.RoundQuantity(decimal.NewFromInt(bookTopNotional).Div(m.price))
+		data = marketdata.BookTop{
+			BidPrice: s.bid(m),
+			BidSize:  size,
+			AskPrice: s.ask(m),
+			AskSize:  size,
+		}
+	}
+
+	raw, _ := json.Marshal(data)
+	return marketdata.Message{
+		Channel: channel,
+		Symbol:  symbol,
+		Time:    now.UnixMilli(),
+		Data:    raw,
+	}
+}
+
+// queue hands a message to the writer, dropping it if the client is not
+// keeping up. The caller must hold s.mu.
+func (sub *subscriber) queue(msg marketdata.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case sub.send <- data:
+	default:
+	}
+}
+
+func (sub *subscriber) writeLoop() {
+	for data := range sub.send {
+		sub.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := sub.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			sub.conn.Close()
+			return
+		}
+	}
+}
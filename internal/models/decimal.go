@@ -0,0 +1,90 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Prices, quantities and money amounts are fixed-point decimals so that
+// sums and comparisons are exact. Rates, percentages and leverage are
+// ratios and stay float64.
+
+// QuantityDecimals and PriceDecimals are the precision orders are sent with
+const (
+	QuantityDecimals = 8
+	PriceDecimals    = 8
+)
+
+// RoundingMode selects how a value is rounded to a precision or increment
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds to the nearest value, ties to even. It is
+	// unbiased and the default for displayed amounts.
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds to the nearest value, ties away from zero
+	RoundHalfUp
+	// RoundDown truncates towards zero, e.g. so a position size never
+	// risks more than intended
+	RoundDown
+	// RoundUp rounds away from zero
+	RoundUp
+	// RoundFloor rounds towards negative infinity
+	RoundFloor
+	// RoundCeil rounds towards positive infinity
+	RoundCeil
+)
+
+// String returns the name of the rounding mode
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundHalfEven:
+		return "half-even"
+	case RoundHalfUp:
+		return "half-up"
+	case RoundDown:
+		return "down"
+	case RoundUp:
+		return "up"
+	case RoundFloor:
+		return "floor"
+	case RoundCeil:
+		return "ceil"
+	default:
+		return fmt.Sprintf("RoundingMode(%d)", int(m))
+	}
+}
+
+// Round rounds d to places decimal places
+func (m RoundingMode) Round(d decimal.Decimal, places int32) decimal.Decimal {
+	switch m {
+	case RoundHalfUp:
+		return d.Round(places)
+	case RoundDown:
+		return d.RoundDown(places)
+	case RoundUp:
+		return d.RoundUp(places)
+	case RoundFloor:
+		return d.RoundFloor(places)
+	case RoundCeil:
+		return d.RoundCeil(places)
+	default:
+		return d.RoundBank(places)
+	}
+}
+
+// RoundToIncrement rounds d to a multiple of increment, such as a price
+// tick or a quantity step. A non-positive increment leaves d unchanged.
+func (m RoundingMode) RoundToIncrement(d, increment decimal.Decimal) decimal.Decimal {
+	if !increment.IsPositive() {
+		return d
+	}
+	return m.Round(d.Div(increment), 0).Mul(increment)
+}
+
+// FormatDecimal formats d with a fixed number of decimal places, rounding
+// half to even
+func FormatDecimal(d decimal.Decimal, places int32) string {
+	return d.StringFixedBank(places)
+}
@@ -0,0 +1,68 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// Instrument holds the trading rules of a market. Quantities are in
+// contracts of ContractMultiplier base units each.
+type Instrument struct {
+	Symbol             string          `json:"symbol"`
+	TickSize           decimal.Decimal `json:"tick_size"`    // price increment
+	StepSize           decimal.Decimal `json:"step_size"`    // quantity increment
+	MinQuantity        decimal.Decimal `json:"min_quantity"` // smallest order
+	MaxQuantity        decimal.Decimal `json:"max_quantity"` // zero for no limit
+	MinNotional        decimal.Decimal `json:"min_notional"` // smallest order value in the quote currency
+	MaxLeverage        float64         `json:"max_leverage,string"`
+	ContractMultiplier decimal.Decimal `json:"contract_multiplier"` // zero means 1
+}
+
+// DefaultInstrument returns the rules assumed for a market whose exchange
+// publishes none: 8 decimal prices and quantities and no other limits
+func DefaultInstrument(symbol string) Instrument {
+	increment := decimal.New(1, -QuantityDecimals)
+	return Instrument{
+		Symbol:             symbol,
+		TickSize:           decimal.New(1, -PriceDecimals),
+		StepSize:           increment,
+		MinQuantity:        increment,
+		ContractMultiplier: decimal.NewFromInt(1),
+	}
+}
+
+// IsZero reports whether no rules are set, i.e. the instrument is unknown
+func (i Instrument) IsZero() bool {
+	return i.Symbol == "" && i.TickSize.IsZero() && i.StepSize.IsZero()
+}
+
+// Multiplier returns the base units per contract
+func (i Instrument) Multiplier() decimal.Decimal {
+	if !i.ContractMultiplier.IsPositive() {
+		return decimal.NewFromInt(1)
+	}
+	return i.ContractMultiplier
+}
+
+// Notional returns the quote value of quantity contracts at price
+func (i Instrument) Notional(quantity, price decimal.Decimal) decimal.Decimal {
+	return quantity.Mul(price).Mul(i.Multiplier())
+}
+
+// RoundPrice rounds price to the tick size
+func (i Instrument) RoundPrice(price decimal.Decimal, mode RoundingMode) decimal.Decimal {
+	return mode.RoundToIncrement(price, i.TickSize)
+}
+
+// RoundQuantity rounds quantity down to the step size, so an order is never
+// larger than intended
+func (i Instrument) RoundQuantity(quantity decimal.Decimal) decimal.Decimal {
+	return RoundDown.RoundToIncrement(quantity, i.StepSize)
+}
+
+// OnTick reports whether price is a multiple of the tick size
+func (i Instrument) OnTick(price decimal.Decimal) bool {
+	return !i.TickSize.IsPositive() || price.Mod(i.TickSize).IsZero()
+}
+
+// OnStep reports whether quantity is a multiple of the step size
+func (i Instrument) OnStep(quantity decimal.Decimal) bool {
+	return !i.StepSize.IsPositive() || quantity.Mod(i.StepSize).IsZero()
+}
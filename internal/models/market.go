@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Market describes a tradable perpetual swap market
+type Market struct {
+	Symbol string `json:"symbol"`
+	Base   string `json:"base"`
+	Quote  string `json:"quote"`
+}
+
+// Ticker holds the latest prices for a market
+type Ticker struct {
+	Symbol    string          `json:"symbol"`
+	LastPrice decimal.Decimal `json:"last_price"`
+	MarkPrice decimal.Decimal `json:"mark_price"`
+	BidPrice  decimal.Decimal `json:"bid_price"`
+	AskPrice  decimal.Decimal `json:"ask_price"`
+	Timestamp time.Time       `json:"timestamp"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// OrderStatus is the state of an order as the exchange reports it
+type OrderStatus string
+
+const (
+	// OrderStatusOpen rests on the book with nothing filled
+	OrderStatusOpen OrderStatus = "open"
+	// OrderStatusPartiallyFilled rests on the book with part filled
+	OrderStatusPartiallyFilled OrderStatus = "partially_filled"
+	// OrderStatusFilled has filled in full
+	OrderStatusFilled OrderStatus = "filled"
+	// OrderStatusCanceled was cancelled, possibly after partial fills
+	OrderStatusCanceled OrderStatus = "canceled"
+	// OrderStatusExpired left the book unfilled by its time in force, e.g.
+	// an IOC order that could not fill
+	OrderStatusExpired OrderStatus = "expired"
+	// OrderStatusRejected was refused by the matching engine
+	OrderStatusRejected OrderStatus = "rejected"
+)
+
+// IsFinal reports whether the order can no longer fill
+func (s OrderStatus) IsFinal() bool {
+	switch s {
+	case OrderStatusFilled, OrderStatusCanceled, OrderStatusExpired, OrderStatusRejected:
+		return true
+	}
+	return false
+}
+
+// OrderUpdate is the state of an exchange order after a change
+type OrderUpdate struct {
+	OrderID        string          `json:"order_id"`
+	ClientOrderID  string          `json:"client_order_id,omitempty"`
+	Symbol         string          `json:"symbol"`
+	Status         OrderStatus     `json:"status"`
+	Quantity       decimal.Decimal `json:"quantity"`
+	FilledQuantity decimal.Decimal `json:"filled_quantity"`
+	AveragePrice   decimal.Decimal `json:"average_price"` // zero until something fills
+	Timestamp      time.Time       `json:"timestamp"`
+}
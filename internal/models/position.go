@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Position represents an open position on the exchange
+type Position struct {
+	Symbol           string          `json:"symbol"`
+	Side             string          `json:"side"`
+	Size             decimal.Decimal `json:"size"`
+	EntryPrice       decimal.Decimal `json:"entry_price"`
+	MarkPrice        decimal.Decimal `json:"mark_price"`
+	Leverage         float64         `json:"leverage,string"`
+	UnrealizedPnL    decimal.Decimal `json:"unrealized_pnl"`
+	LiquidationPrice decimal.Decimal `json:"liquidation_price"`
+}
+
+// Fill represents an execution against one of our orders
+type Fill struct {
+	ID        string          `json:"id,omitempty"` // unique per fill, empty if the venue has none
+	OrderID   string          `json:"order_id"`
+	Symbol    string          `json:"symbol"`
+	Side      string          `json:"side"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Price     decimal.Decimal `json:"price"`
+	Fee       decimal.Decimal `json:"fee"`
+	Timestamp time.Time       `json:"timestamp"`
+}
@@ -1,5 +1,196 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidTrade = errors.New("invalid trade")
+
+// OrderSide is the direction of an order
+type OrderSide string
+
+const (
+	SideBuy  OrderSide = "BUY"
+	SideSell OrderSide = "SELL"
+)
+
+// ParseOrderSide parses BUY or SELL, case insensitively
+func ParseOrderSide(s string) (OrderSide, error) {
+	switch side := OrderSide(strings.ToUpper(s)); side {
+	case SideBuy, SideSell:
+		return side, nil
+	default:
+		return "", fmt.Errorf("%w: side must be BUY or SELL, got %q", ErrInvalidTrade, s)
+	}
+}
+
+// Opposite returns the side that closes a position opened on s
+func (s OrderSide) Opposite() OrderSide {
+	if s == SideBuy {
+		return SideSell
+	}
+	return SideBuy
+}
+
+// OrderType is how an order executes
+type OrderType string
+
+const (
+	// OrderTypeLimit rests on the book at Price
+	OrderTypeLimit OrderType = "limit"
+	// OrderTypeMarket fills immediately at the best available price
+	OrderTypeMarket OrderType = "market"
+	// OrderTypeStopMarket becomes a market order once the price trades
+	// through StopPrice
+	OrderTypeStopMarket OrderType = "stop"
+	// OrderTypeStopLimit becomes a limit order at Price once the price
+	// trades through StopPrice
+	OrderTypeStopLimit OrderType = "stop_limit"
+	// OrderTypeTrailingStop is a stop-market order whose StopPrice follows
+	// the best price since placement at TrailingDistance
+	OrderTypeTrailingStop OrderType = "trailing_stop"
+)
+
+// IsStop reports whether the order waits for its stop price to trade
+func (t OrderType) IsStop() bool {
+	return t == OrderTypeStopMarket || t == OrderTypeStopLimit || t == OrderTypeTrailingStop
+}
+
+// TimeInForce is how long an order stays on the book
+type TimeInForce string
+
+const (
+	// TimeInForceGTC rests until filled or cancelled
+	TimeInForceGTC TimeInForce = "GTC"
+	// TimeInForceIOC fills what it can immediately and cancels the rest
+	TimeInForceIOC TimeInForce = "IOC"
+	// TimeInForceFOK fills in full immediately or not at all
+	TimeInForceFOK TimeInForce = "FOK"
+)
+
+// Trade is an order request sent to an exchange
 type Trade struct {
-    // TODO: Define trade structure
+	ClientOrderID string          `json:"client_order_id,omitempty"`
+	Symbol        string          `json:"symbol"`
+	Side          OrderSide       `json:"side"`
+	Type          OrderType       `json:"type"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	Price         decimal.Decimal `json:"price"`      // limit price, zero for market and stop-market orders
+	StopPrice     decimal.Decimal `json:"stop_price"` // trigger price of stop orders
+	// TrailingDistance is how far a trailing stop follows the price
+	TrailingDistance decimal.Decimal `json:"trailing_distance,omitempty"`
+	TimeInForce      TimeInForce     `json:"time_in_force"`
+	ReduceOnly       bool            `json:"reduce_only,omitempty"`
+	PostOnly         bool            `json:"post_only,omitempty"`
+}
+
+// NewLimitTrade returns a good-til-cancelled limit order
+func NewLimitTrade(symbol string, side OrderSide, quantity, price decimal.Decimal) Trade {
+	return Trade{
+		Symbol:      symbol,
+		Side:        side,
+		Type:        OrderTypeLimit,
+		Quantity:    quantity,
+		Price:       price,
+		TimeInForce: TimeInForceGTC,
+	}
+}
+
+// NewMarketTrade returns an immediate-or-cancel market order
+func NewMarketTrade(symbol string, side OrderSide, quantity decimal.Decimal) Trade {
+	return Trade{
+		Symbol:      symbol,
+		Side:        side,
+		Type:        OrderTypeMarket,
+		Quantity:    quantity,
+		TimeInForce: TimeInForceIOC,
+	}
+}
+
+// NewStopMarketTrade returns a reduce-only stop-market order, the usual
+// shape of a stop loss
+func NewStopMarketTrade(symbol string, side OrderSide, quantity, stopPrice decimal.Decimal) Trade {
+	return Trade{
+		Symbol:      symbol,
+		Side:        side,
+		Type:        OrderTypeStopMarket,
+		Quantity:    quantity,
+		StopPrice:   stopPrice,
+		TimeInForce: TimeInForceGTC,
+		ReduceOnly:  true,
+	}
+}
+
+// NewTrailingStopTrade returns a reduce-only trailing stop starting at
+// stopPrice and following the price at distance
+func NewTrailingStopTrade(symbol string, side OrderSide, quantity, stopPrice, distance decimal.Decimal) Trade {
+	trade := NewStopMarketTrade(symbol, side, quantity, stopPrice)
+	trade.Type = OrderTypeTrailingStop
+	trade.TrailingDistance = distance
+	return trade
+}
+
+// ReferencePrice returns the price the order is expected to execute near:
+// the limit price, or the stop price of a stop-market order. It is zero for
+// market orders.
+func (t Trade) ReferencePrice() decimal.Decimal {
+	if t.Price.IsPositive() {
+		return t.Price
+	}
+	return t.StopPrice
+}
+
+// Validate checks the fields are consistent with the order type
+func (t Trade) Validate() error {
+	if t.Symbol == "" {
+		return fmt.Errorf("%w: symbol required", ErrInvalidTrade)
+	}
+	if _, err := ParseOrderSide(string(t.Side)); err != nil {
+		return err
+	}
+	if !t.Quantity.IsPositive() {
+		return fmt.Errorf("%w: quantity must be positive", ErrInvalidTrade)
+	}
+
+	switch t.Type {
+	case OrderTypeLimit:
+		if !t.Price.IsPositive() {
+			return fmt.Errorf("%w: limit order requires a price", ErrInvalidTrade)
+		}
+	case OrderTypeMarket:
+		if !t.Price.IsZero() {
+			return fmt.Errorf("%w: market order cannot have a price", ErrInvalidTrade)
+		}
+	case OrderTypeStopMarket:
+		if !t.StopPrice.IsPositive() {
+			return fmt.Errorf("%w: stop order requires a stop price", ErrInvalidTrade)
+		}
+	case OrderTypeStopLimit:
+		if !t.StopPrice.IsPositive() || !t.Price.IsPositive() {
+			return fmt.Errorf("%w: stop-limit order requires a stop price and a price", ErrInvalidTrade)
+		}
+	case OrderTypeTrailingStop:
+		if !t.StopPrice.IsPositive() || !t.TrailingDistance.IsPositive() {
+			return fmt.Errorf("%w: trailing stop requires a stop price and a trailing distance", ErrInvalidTrade)
+		}
+	default:
+		return fmt.Errorf("%w: unknown order type %q", ErrInvalidTrade, t.Type)
+	}
+
+	switch t.TimeInForce {
+	case TimeInForceGTC, TimeInForceIOC, TimeInForceFOK:
+	case "":
+		return fmt.Errorf("%w: time in force required", ErrInvalidTrade)
+	default:
+		return fmt.Errorf("%w: unknown time in force %q", ErrInvalidTrade, t.TimeInForce)
+	}
+
+	if t.PostOnly && (t.Type != OrderTypeLimit || t.TimeInForce != TimeInForceGTC) {
+		return fmt.Errorf("%w: post-only requires a GTC limit order", ErrInvalidTrade)
+	}
+	return nil
 }
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueueFull means a command was turned away because its priority lane
+// of the command queue was full
+var ErrQueueFull = errors.New("command queue is full")
+
+// Priority is the lane of the command queue a command waits in
+type Priority int
+
+const (
+	// PriorityHigh is for commands that take risk off or protect a
+	// position: cancels, modifies such as stop moves, and reduce-only
+	// orders
+	PriorityHigh Priority = iota
+	// PriorityNormal is for new entries
+	PriorityNormal
+
+	numPriorities = 2
+)
+
+// String returns the lane's name
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// PriorityOf returns the lane a command waits in
+func PriorityOf(cmd OrderCommand) Priority {
+	if cmd.Type != CommandPlaceOrder || cmd.Trade.ReduceOnly {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// QueueStats shows the load on a command queue, so backpressure is visible
+// before commands are turned away
+type QueueStats struct {
+	// Capacity is how many commands each priority lane holds
+	Capacity int
+	// Waiting is the number of commands queued in each lane
+	Waiting [numPriorities]int
+	// Running is the number of commands executing, at most one per symbol
+	Running int
+	// Enqueued, Rejected and Processed count commands since the queue
+	// was created. Rejected ones found their lane full.
+	Enqueued  int64
+	Rejected  int64
+	Processed int64
+	// LastWait and MaxWait are how long commands waited before executing,
+	// the latest and the longest
+	LastWait time.Duration
+	MaxWait  time.Duration
+}
+
+// Queued returns the number of commands waiting in every lane
+func (s QueueStats) Queued() int {
+	total := 0
+	for _, n := range s.Waiting {
+		total += n
+	}
+	return total
+}
+
+// Stats returns the queue's current load
+func (q *CommandQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Capacity = q.capacity
+	return stats
+}
+
+// queuedCommand is a command waiting in a lane
+type queuedCommand struct {
+	cmd      OrderCommand
+	queuedAt time.Time
+}
+
+// symbolLanes are the commands waiting for one symbol
+type symbolLanes struct {
+	lanes   [numPriorities][]queuedCommand
+	running bool // a worker is executing the symbol's commands
+}
+
+// next removes the oldest command of the highest priority lane with one
+func (s *symbolLanes) next() (queuedCommand, Priority, bool) {
+	for p := range s.lanes {
+		if len(s.lanes[p]) > 0 {
+			qc := s.lanes[p][0]
+			s.lanes[p] = s.lanes[p][1:]
+			return qc, Priority(p), true
+		}
+	}
+	return queuedCommand{}, 0, false
+}
+
+// push queues a command for symbol in a lane and dispatches a worker for
+// the symbol if none is running
+func (q *CommandQueue) push(symbol string, priority Priority, cmd OrderCommand) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if waiting := q.stats.Waiting[priority]; waiting >= q.capacity {
+		q.stats.Rejected++
+		return fmt.Errorf("%w: %d %s priority commands waiting", ErrQueueFull, waiting, priority)
+	}
+	s, exists := q.symbols[symbol]
+	if !exists {
+		s = &symbolLanes{}
+		q.symbols[symbol] = s
+	}
+	s.lanes[priority] = append(s.lanes[priority], queuedCommand{cmd: cmd, queuedAt: time.Now()})
+	q.stats.Waiting[priority]++
+	q.stats.Enqueued++
+	q.dispatch(symbol)
+	return nil
+}
+
+// dispatch starts a worker for symbol unless one is running or the queue
+// has not started. It must be called with q.mu held.
+func (q *CommandQueue) dispatch(symbol string) {
+	if q.ctx == nil || q.symbols[symbol].running {
+		return
+	}
+	q.symbols[symbol].running = true
+	q.wg.Add(1)
+	go q.work(symbol)
+}
+
+// work executes the commands of symbol until none are left or the queue's
+// context ends
+func (q *CommandQueue) work(symbol string) {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		s := q.symbols[symbol]
+		if q.ctx.Err() != nil {
+			// What is left stays queued for a later Start
+			s.running = false
+			q.mu.Unlock()
+			return
+		}
+		qc, priority, ok := s.next()
+		if !ok {
+			delete(q.symbols, symbol)
+			q.mu.Unlock()
+			return
+		}
+		wait := time.Since(qc.queuedAt)
+		q.stats.Waiting[priority]--
+		q.stats.Running++
+		q.stats.LastWait = wait
+		q.stats.MaxWait = max(q.stats.MaxWait, wait)
+		ctx, executor := q.ctx, q.executor
+		q.mu.Unlock()
+
+		q.processCommand(ctx, qc.cmd, executor)
+
+		q.mu.Lock()
+		q.stats.Running--
+		q.stats.Processed++
+		q.mu.Unlock()
+	}
+}
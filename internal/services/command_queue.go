@@ -3,23 +3,52 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
 	"github.com/sub0xdai/n0xtilus/internal/validation"
 )
 
 // OrderCommand represents a trading command to be executed
 type OrderCommand struct {
-	Type           CommandType
-	Symbol         string
-	Side           string
-	Quantity       string
-	Price          string
+	Type CommandType
+	// Trade is the order to place. Modify commands carry the new quantity
+	// and price in it.
+	Trade          models.Trade
+	// Instrument holds the symbol's trading rules the order is validated
+	// against; zero means the default 8 decimal grid
+	Instrument     models.Instrument
 	OrderID        string
 	Timestamp      time.Time
 	Leverage       float64
 	RiskPercentage float64
+	AccountBalance decimal.Decimal
+	// StopLoss and LiquidationPrice are set on entry orders so validation
+	// can reject stops beyond liquidation
+	StopLoss         decimal.Decimal
+	LiquidationPrice decimal.Decimal
+	// CommandID is the journal event recording the command, set when it
+	// is queued; what it causes is journaled against it
+	CommandID int64
+	// Result, if set, receives the outcome of a cancel or modify command
+	// once it has run; it should be buffered. A command the exchange
+	// refused leaves the order in the state the exchange reports for it.
+	Result chan<- error
+}
+
+// reply sends the outcome of a command to its Result channel, if any
+func (cmd OrderCommand) reply(err error) {
+	if cmd.Result == nil {
+		return
+	}
+	select {
+	case cmd.Result <- err:
+	default:
+	}
 }
 
 type CommandType int
@@ -30,57 +59,126 @@ const (
 	CommandModifyOrder
 )
 
-// CommandQueue manages the order execution queue
+// String returns the command as the order journal records it
+func (t CommandType) String() string {
+	switch t {
+	case CommandPlaceOrder:
+		return "place"
+	case CommandCancelOrder:
+		return "cancel"
+	case CommandModifyOrder:
+		return "modify"
+	default:
+		return fmt.Sprintf("CommandType(%d)", int(t))
+	}
+}
+
+// CommandQueue manages the order execution queue. Commands run one at a
+// time per symbol, in the order they were queued, and in parallel across
+// symbols. Within a symbol, high priority commands overtake new entries,
+// see PriorityOf.
 type CommandQueue struct {
-	commands     chan OrderCommand
-	wg          sync.WaitGroup
+	mu       sync.Mutex
+	symbols  map[string]*symbolLanes // commands waiting, by symbol
+	capacity int                     // commands each priority lane holds
+	stats    QueueStats
+	ctx      context.Context // set by Start
+	executor OrderExecutor
+
+	wg           sync.WaitGroup
 	stateManager *OrderStateManager
-	validator   *validation.OrderValidator
+	validator    *validation.OrderValidator
+	retries      map[CommandType]RetryPolicy
 }
 
-// NewCommandQueue creates a new command queue with specified buffer size
-func NewCommandQueue(bufferSize int) *CommandQueue {
+// NewCommandQueue creates a new command queue holding up to bufferSize
+// waiting commands in each priority lane. Orders are tracked in
+// stateManager, which may be shared with whatever reconciles exchange
+// updates; nil tracks them privately.
+func NewCommandQueue(bufferSize int, stateManager *OrderStateManager) *CommandQueue {
+	if stateManager == nil {
+		stateManager = NewOrderStateManager()
+	}
 	return &CommandQueue{
-		commands:     make(chan OrderCommand, bufferSize),
-		stateManager: NewOrderStateManager(),
-		validator:    validation.NewOrderValidator(0.00001, 1000000, 0.00001, 1000000, 100, 5), // Example limits
+		symbols:      make(map[string]*symbolLanes),
+		capacity:     bufferSize,
+		stateManager: stateManager,
+		validator:    validation.NewOrderValidator(100, 5), // Example limits
+		retries:      DefaultRetryPolicies(),
 	}
 }
 
-// Start begins processing commands from the queue
+// SetRetryPolicy sets how commands of type t are retried after network
+// errors. Call it before Start.
+func (q *CommandQueue) SetRetryPolicy(t CommandType, policy RetryPolicy) {
+	q.retries[t] = policy
+}
+
+// Start begins processing commands from the queue, including those queued
+// before it. Processing stops once ctx ends, leaving later commands queued.
 func (q *CommandQueue) Start(ctx context.Context, executor OrderExecutor) {
-	q.wg.Add(1)
-	go func() {
-		defer q.wg.Done()
-		for {
-			select {
-			case cmd := <-q.commands:
-				q.processCommand(cmd, executor)
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ctx, q.executor = ctx, executor
+	for symbol := range q.symbols {
+		q.dispatch(symbol)
+	}
 }
 
-// Stop gracefully stops the command queue
+// Stop waits for the commands executing when the queue's context ended
 func (q *CommandQueue) Stop() {
 	q.wg.Wait()
 }
 
-// Enqueue adds a command to the queue
+// Enqueue adds a command to the queue. New orders are validated and
+// tracked; cancel and modify commands act on an order already tracked. It
+// fails with ErrQueueFull if the command's priority lane is full.
 func (q *CommandQueue) Enqueue(cmd OrderCommand) error {
+	if cmd.Type != CommandPlaceOrder {
+		order, exists := q.stateManager.GetOrder(cmd.OrderID)
+		if !exists {
+			return errors.New("order not found")
+		}
+		event := OrderEvent{Type: EventCommand, Command: cmd.Type.String()}
+		if cmd.Type == CommandModifyOrder {
+			event.Trade = &cmd.Trade
+		}
+		cmd.CommandID = order.emit(event)
+
+		// Acting on an order still waiting to be placed must not overtake
+		// the placement, so it joins the placement's lane
+		priority := PriorityOf(cmd)
+		if order.GetState() == OrderStatePending {
+			priority = PriorityOf(OrderCommand{Type: CommandPlaceOrder, Trade: order.Trade})
+		}
+		if err := q.push(order.Symbol, priority, cmd); err != nil {
+			order.emit(OrderEvent{Type: EventRejected, Command: cmd.Type.String(), Error: err.Error(), CausationID: cmd.CommandID})
+			return err
+		}
+		return nil
+	}
+
+	// The client order ID finds the order on the exchange if the app stops
+	// before the place call returns
+	if cmd.Trade.ClientOrderID == "" {
+		cmd.Trade.ClientOrderID = cmd.OrderID
+	}
+
 	// Create atomic order and add to state manager
 	atomicOrder := NewAtomicOrder(cmd, q.validator)
+	if err := atomicOrder.Validate(cmd.AccountBalance); err != nil {
+		return fmt.Errorf("order validation failed: %w", err)
+	}
 	q.stateManager.AddOrder(atomicOrder)
+	record := atomicOrder.record()
+	cmd.CommandID = atomicOrder.emit(OrderEvent{Type: EventCommand, Command: cmd.Type.String(), Order: &record})
 
-	select {
-	case q.commands <- cmd:
-		return nil
-	default:
+	if err := q.push(cmd.Trade.Symbol, PriorityOf(cmd), cmd); err != nil {
+		atomicOrder.fail(err, cmd.CommandID)
 		q.stateManager.RemoveOrder(cmd.OrderID)
-		return errors.New("command queue is full")
+		return err
 	}
+	return nil
 }
 
 // GetStatus returns the status of an order
@@ -91,45 +189,83 @@ func (q *CommandQueue) GetStatus(orderID string) (OrderCommand, error) {
 	}
 
 	return OrderCommand{
+		Trade:     order.Trade,
 		OrderID:   order.ID,
-		Symbol:    order.Symbol,
-		Side:      order.Side,
-		Quantity:  order.Quantity,
-		Price:     order.Price,
 		Timestamp: order.timestamp,
 	}, order.GetError()
 }
 
-func (q *CommandQueue) processCommand(cmd OrderCommand, executor OrderExecutor) {
+// GetOrder returns a tracked order by its internal ID
+func (q *CommandQueue) GetOrder(orderID string) (*AtomicOrder, bool) {
+	return q.stateManager.GetOrder(orderID)
+}
+
+func (q *CommandQueue) processCommand(ctx context.Context, cmd OrderCommand, executor OrderExecutor) {
 	order, exists := q.stateManager.GetOrder(cmd.OrderID)
 	if !exists {
 		return // Order was removed or doesn't exist
 	}
 
-	// Update order to active state
-	err := q.stateManager.UpdateOrderState(cmd.OrderID, OrderStateActive)
-	if err != nil {
-		order.SetError(err)
-		return
-	}
-
-	var execErr error
+	// Changes are journaled as caused by the command
 	switch cmd.Type {
 	case CommandPlaceOrder:
-		_, execErr = executor.PlaceOrder(cmd.Symbol, cmd.Side, cmd.Quantity, cmd.Price)
+		// Update order to active state. An invalid transition fails the
+		// order.
+		if !order.transition(OrderStateActive, cmd.CommandID) {
+			return
+		}
+
+		exchangeOrderID, err := q.place(ctx, order, cmd, executor)
+		if err != nil {
+			order.fail(err, cmd.CommandID)
+			return
+		}
+
+		// The order stays active until the exchange reports its fills,
+		// which may already have arrived. A fill that cannot be applied
+		// does not undo the placement.
+		if err := q.stateManager.bindExchangeOrderID(cmd.OrderID, exchangeOrderID, cmd.CommandID); err != nil {
+			log.Printf("Order %s: %v", cmd.OrderID, err)
+		}
+
 	case CommandCancelOrder:
-		execErr = executor.CancelOrder(cmd.OrderID)
+		if order.IsTerminal() {
+			cmd.reply(fmt.Errorf("order already %s", order.GetState()))
+			return
+		}
+		canceled, err := q.cancel(ctx, order, cmd, executor)
+		if err != nil {
+			q.refused(order, cmd, err, executor)
+		} else if canceled && IsValidTransition(order.GetState(), OrderStateCanceled) {
+			order.transition(OrderStateCanceled, cmd.CommandID)
+		}
+		cmd.reply(err)
+
 	case CommandModifyOrder:
-		execErr = executor.ModifyOrder(cmd.OrderID, cmd.Quantity, cmd.Price)
+		if order.IsTerminal() {
+			cmd.reply(fmt.Errorf("order already %s", order.GetState()))
+			return
+		}
+		err := q.modify(ctx, order, cmd, executor)
+		if err != nil {
+			q.refused(order, cmd, err, executor)
+		}
+		cmd.reply(err)
 	}
+}
 
-	if execErr != nil {
-		order.SetError(execErr)
-		return
+// refused journals a cancel or modify that failed. The order keeps its
+// state: the exchange usually refuses because the order has just filled or
+// closed, so it is looked up and what the exchange reports applied.
+func (q *CommandQueue) refused(order *AtomicOrder, cmd OrderCommand, err error, executor OrderExecutor) {
+	order.emit(OrderEvent{Type: EventRejected, Command: cmd.Type.String(), Error: err.Error(), CausationID: cmd.CommandID})
+	update, lookupErr := executor.FindOrder(order.ClientOrderID)
+	if lookupErr == nil {
+		lookupErr = q.stateManager.ApplyOrderUpdate(update)
+	}
+	if lookupErr != nil {
+		log.Printf("Order %s: %s failed (%v) and looking it up failed: %v", order.ID, cmd.Type, err, lookupErr)
 	}
-
-	// Update to filled state for successful execution
-	_ = q.stateManager.UpdateOrderState(cmd.OrderID, OrderStateFilled)
 }
 
 // GetPendingOrders returns all pending orders
@@ -137,9 +273,11 @@ func (q *CommandQueue) GetPendingOrders() []*AtomicOrder {
 	return q.stateManager.GetOrdersByState(OrderStatePending)
 }
 
-// GetActiveOrders returns all active orders
+// GetActiveOrders returns all active orders, including partially filled
+// ones
 func (q *CommandQueue) GetActiveOrders() []*AtomicOrder {
-	return q.stateManager.GetOrdersByState(OrderStateActive)
+	return append(q.stateManager.GetOrdersByState(OrderStateActive),
+		q.stateManager.GetOrdersByState(OrderStatePartiallyFilled)...)
 }
 
 // GetFilledOrders returns all filled orders
@@ -172,7 +310,10 @@ func (q *CommandQueue) ClearTerminalOrders() {
 
 // OrderExecutor interface defines methods for executing orders
 type OrderExecutor interface {
-	PlaceOrder(symbol, side, quantity, price string) (string, error)
+	PlaceOrder(trade models.Trade) (string, error)
 	CancelOrder(orderID string) error
-	ModifyOrder(orderID, quantity, price string) error
+	ModifyOrder(orderID string, quantity, price decimal.Decimal) error
+	// FindOrder looks up an order by client order ID, failing with
+	// exchange.ErrOrderNotFound if there is none
+	FindOrder(clientOrderID string) (models.OrderUpdate, error)
 }
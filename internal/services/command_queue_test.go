@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// fakeExecutor answers commands from canned results. Cancels and modifies
+// return their errors in turn, then succeed; FindOrder reports the order
+// as the exchange has it, or not found.
+type fakeExecutor struct {
+	mu         sync.Mutex
+	placed     []models.Trade
+	cancelled  []string
+	modified   []decimal.Decimal // prices amended to
+	cancelErrs []error
+	modifyErrs []error
+	orders     map[string]models.OrderUpdate // by client order ID
+}
+
+func (f *fakeExecutor) PlaceOrder(trade models.Trade) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.placed = append(f.placed, trade)
+	id := fmt.Sprintf("EX-P%d", len(f.placed))
+	if f.orders == nil {
+		f.orders = make(map[string]models.OrderUpdate)
+	}
+	f.orders[trade.ClientOrderID] = models.OrderUpdate{OrderID: id, ClientOrderID: trade.ClientOrderID, Symbol: trade.Symbol, Status: models.OrderStatusOpen, Quantity: trade.Quantity}
+	return id, nil
+}
+
+func (f *fakeExecutor) CancelOrder(orderID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled = append(f.cancelled, orderID)
+	return popErr(&f.cancelErrs)
+}
+
+func (f *fakeExecutor) ModifyOrder(orderID string, quantity, price decimal.Decimal) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modified = append(f.modified, price)
+	return popErr(&f.modifyErrs)
+}
+
+func (f *fakeExecutor) FindOrder(clientOrderID string) (models.OrderUpdate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if update, exists := f.orders[clientOrderID]; exists {
+		return update, nil
+	}
+	return models.OrderUpdate{}, exchange.ErrOrderNotFound
+}
+
+func popErr(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+// restoredOrder tracks an order resting on the exchange as EX-1
+func restoredOrder(m *OrderStateManager) *AtomicOrder {
+	return m.RestoreOrder(OrderRecord{
+		ID:              "ORD-1",
+		ExchangeOrderID: "EX-1",
+		Trade:           models.Trade{ClientOrderID: "ORD-1", Symbol: "BTC/USDT", Side: models.SideBuy, Type: models.OrderTypeLimit, Quantity: dec("1"), Price: dec("100")},
+		State:           OrderStateActive,
+	})
+}
+
+func startQueue(t *testing.T, m *OrderStateManager, executor OrderExecutor) *CommandQueue {
+	t.Helper()
+	q := NewCommandQueue(10, m)
+	for typ := range q.retries {
+		q.SetRetryPolicy(typ, RetryPolicy{MaxRetries: 2})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Start(ctx, executor)
+	t.Cleanup(func() {
+		cancel()
+		q.Stop()
+	})
+	return q
+}
+
+func TestRefusedCancelFollowsTheExchange(t *testing.T) {
+	filled := models.OrderUpdate{OrderID: "EX-1", ClientOrderID: "ORD-1", Status: models.OrderStatusFilled, Quantity: dec("1"), FilledQuantity: dec("1"), AveragePrice: dec("100")}
+	open := models.OrderUpdate{OrderID: "EX-1", ClientOrderID: "ORD-1", Status: models.OrderStatusOpen, Quantity: dec("1")}
+
+	tests := []struct {
+		name    string
+		exists  map[string]models.OrderUpdate
+		wantErr bool
+		want    OrderState
+	}{
+		// The entry filled just before the cancel arrived
+		{"filled meanwhile", map[string]models.OrderUpdate{"ORD-1": filled}, false, OrderStateFilled},
+		{"still open", map[string]models.OrderUpdate{"ORD-1": open}, true, OrderStateActive},
+		{"lookup fails", nil, true, OrderStateActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewOrderStateManager()
+			order := restoredOrder(m)
+			executor := &fakeExecutor{cancelErrs: []error{exchange.ErrOrderNotFound}, orders: tt.exists}
+			q := startQueue(t, m, executor)
+
+			err := cancelQueued(q, order.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cancel error = %v, want error %v", err, tt.wantErr)
+			}
+			if state := order.GetState(); state != tt.want {
+				t.Fatalf("state = %s, want %s", state, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefusedModifyKeepsTheOrder(t *testing.T) {
+	m := NewOrderStateManager()
+	order := restoredOrder(m)
+	refused := errors.New("price off the tick")
+	executor := &fakeExecutor{modifyErrs: []error{refused}}
+	q := startQueue(t, m, executor)
+
+	result := make(chan error, 1)
+	trade := order.Trade
+	trade.Price = dec("101")
+	if err := q.Enqueue(OrderCommand{Type: CommandModifyOrder, OrderID: order.ID, Trade: trade, Result: result}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	select {
+	case err := <-result:
+		if !errors.Is(err, refused) {
+			t.Fatalf("modify error = %v, want %v", err, refused)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+	if state := order.GetState(); state != OrderStateActive {
+		t.Fatalf("state = %s, want Active", state)
+	}
+
+	// Fills still apply to the order
+	if err := m.ApplyFill(models.Fill{ID: "F1", OrderID: "EX-1", Quantity: dec("1"), Price: dec("100"), Timestamp: time.Now()}); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if state := order.GetState(); state != OrderStateFilled {
+		t.Fatalf("state after fill = %s, want Filled", state)
+	}
+}
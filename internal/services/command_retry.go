@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// RetryPolicy is how a command is retried after a network error left its
+// outcome unknown
+type RetryPolicy struct {
+	// MaxRetries is how many times the command is retried, zero for never
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the jittered exponential delay
+	// before each retry
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicies returns the retry policy of each command type.
+// Cancels are retried the most, they usually take risk off.
+func DefaultRetryPolicies() map[CommandType]RetryPolicy {
+	return map[CommandType]RetryPolicy{
+		CommandPlaceOrder:  {MaxRetries: 2, MinBackoff: 250 * time.Millisecond, MaxBackoff: 2 * time.Second},
+		CommandCancelOrder: {MaxRetries: 4, MinBackoff: 250 * time.Millisecond, MaxBackoff: 2 * time.Second},
+		CommandModifyOrder: {MaxRetries: 2, MinBackoff: 250 * time.Millisecond, MaxBackoff: 2 * time.Second},
+	}
+}
+
+// Backoff returns the delay before retry n, doubling from MinBackoff up to
+// MaxBackoff with up to half of it as random jitter
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.MinBackoff
+	for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// wait journals a retry of cmd after err and sleeps its backoff. It
+// reports false if ctx ended first.
+func (p RetryPolicy) wait(ctx context.Context, order *AtomicOrder, cmd OrderCommand, retry int, err error) bool {
+	order.emit(OrderEvent{Type: EventRetry, Command: cmd.Type.String(), Attempt: retry, Error: err.Error(), CausationID: cmd.CommandID})
+	timer := time.NewTimer(p.Backoff(retry))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// place places an order at most once. After a network error the order may
+// have reached the exchange, so it is looked up by its client order ID and
+// only placed again if the exchange has none. It returns the exchange
+// order ID.
+func (q *CommandQueue) place(ctx context.Context, order *AtomicOrder, cmd OrderCommand, executor OrderExecutor) (string, error) {
+	policy := q.retries[cmd.Type]
+	exchangeOrderID, err := executor.PlaceOrder(cmd.Trade)
+	for retry := 1; isAmbiguous(err) && cmd.Trade.ClientOrderID != "" && retry <= policy.MaxRetries; retry++ {
+		if !policy.wait(ctx, order, cmd, retry, err) {
+			break
+		}
+		placed, lookupErr := executor.FindOrder(cmd.Trade.ClientOrderID)
+		switch {
+		case lookupErr == nil:
+			return placed.OrderID, nil
+		case errors.Is(lookupErr, exchange.ErrOrderNotFound):
+			exchangeOrderID, err = executor.PlaceOrder(cmd.Trade)
+		default:
+			// Still unknown, look again before placing
+			err = lookupErr
+		}
+	}
+	if isAmbiguous(err) {
+		return "", fmt.Errorf("%w: %w", ErrPlacementUnknown, err)
+	}
+	return exchangeOrderID, err
+}
+
+// cancel cancels an order, retrying after network errors unless the
+// exchange shows the order has already closed. It reports whether the
+// order is known to be cancelled; otherwise the exchange's update says how
+// it closed.
+func (q *CommandQueue) cancel(ctx context.Context, order *AtomicOrder, cmd OrderCommand, executor OrderExecutor) (bool, error) {
+	policy := q.retries[cmd.Type]
+	err := executor.CancelOrder(order.GetExchangeOrderID())
+	for retry := 1; errors.Is(err, exchange.ErrNetwork) && retry <= policy.MaxRetries; retry++ {
+		if !policy.wait(ctx, order, cmd, retry, err) {
+			break
+		}
+		if update, lookupErr := executor.FindOrder(order.ClientOrderID); lookupErr == nil && update.Status.IsFinal() {
+			return false, q.stateManager.ApplyOrderUpdate(update)
+		}
+		err = executor.CancelOrder(order.GetExchangeOrderID())
+	}
+	return err == nil, err
+}
+
+// modify amends an order, retrying after network errors unless the
+// exchange shows the order has already closed. Amending to the same
+// quantity and price again is harmless.
+func (q *CommandQueue) modify(ctx context.Context, order *AtomicOrder, cmd OrderCommand, executor OrderExecutor) error {
+	policy := q.retries[cmd.Type]
+	err := executor.ModifyOrder(order.GetExchangeOrderID(), cmd.Trade.Quantity, cmd.Trade.Price)
+	for retry := 1; errors.Is(err, exchange.ErrNetwork) && retry <= policy.MaxRetries; retry++ {
+		if !policy.wait(ctx, order, cmd, retry, err) {
+			break
+		}
+		if update, lookupErr := executor.FindOrder(order.ClientOrderID); lookupErr == nil && update.Status.IsFinal() {
+			if err := q.stateManager.ApplyOrderUpdate(update); err != nil {
+				return err
+			}
+			return fmt.Errorf("order %s before it could be amended", update.Status)
+		}
+		err = executor.ModifyOrder(order.GetExchangeOrderID(), cmd.Trade.Quantity, cmd.Trade.Price)
+	}
+	return err
+}
+
+// isAmbiguous reports whether a failed placement may have placed the order
+func isAmbiguous(err error) bool {
+	return errors.Is(err, exchange.ErrNetwork) || errors.Is(err, exchange.ErrDuplicateOrder)
+}
+
+// FindOrder looks up an order by the client order ID it was placed with
+func (s *OrderService) FindOrder(clientOrderID string) (models.OrderUpdate, error) {
+	return s.client.GetOrderByClientID(clientOrderID)
+}
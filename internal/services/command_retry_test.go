@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+func TestModifyRetryStopsOnceTheOrderCloses(t *testing.T) {
+	m := NewOrderStateManager()
+	order := restoredOrder(m)
+	executor := &fakeExecutor{
+		modifyErrs: []error{exchange.ErrNetwork},
+		orders: map[string]models.OrderUpdate{
+			"ORD-1": {OrderID: "EX-1", ClientOrderID: "ORD-1", Status: models.OrderStatusCanceled, Quantity: dec("1")},
+		},
+	}
+	q := startQueue(t, m, executor)
+
+	result := make(chan error, 1)
+	trade := order.Trade
+	trade.Price = dec("101")
+	if err := q.Enqueue(OrderCommand{Type: CommandModifyOrder, OrderID: order.ID, Trade: trade, Result: result}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("amending a cancelled order succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+
+	executor.mu.Lock()
+	amends := len(executor.modified)
+	executor.mu.Unlock()
+	if amends != 1 {
+		t.Errorf("%d amends sent, want the first only", amends)
+	}
+	if state := order.GetState(); state != OrderStateCanceled {
+		t.Errorf("state = %s, want Canceled", state)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// OrderEventType is what an order event records
+type OrderEventType string
+
+const (
+	// EventCommand is a command queued for the order. Placing it carries
+	// the order as queued.
+	EventCommand OrderEventType = "command"
+	// EventRejected is a cancel or modify command the queue could not take
+	// or that failed on the exchange
+	EventRejected OrderEventType = "rejected"
+	// EventState is a change of state
+	EventState OrderEventType = "state"
+	// EventPlaced is the exchange accepting the order under an ID
+	EventPlaced OrderEventType = "placed"
+	// EventUpdate is the exchange reporting a change of the order
+	EventUpdate OrderEventType = "update"
+	// EventFill is a fill applied to the order
+	EventFill OrderEventType = "fill"
+	// EventAmended is the order quantity changing on the exchange
+	EventAmended OrderEventType = "amended"
+	// EventError is an error set on the order
+	EventError OrderEventType = "error"
+	// EventRestored is the order being reloaded after a restart
+	EventRestored OrderEventType = "restored"
+	// EventRetry is a command being retried after a network error
+	EventRetry OrderEventType = "retry"
+)
+
+// OrderEvent is one immutable entry in the order journal. Only the fields
+// of its type are set.
+type OrderEvent struct {
+	// ID numbers the events of a journal in the order they were written
+	ID      int64          `json:"id"`
+	OrderID string         `json:"order_id"`
+	Type    OrderEventType `json:"type"`
+	Time    time.Time      `json:"time"`
+	// CausationID is the ID of the event this one follows from, zero for
+	// commands and events the exchange started
+	CausationID int64 `json:"causation_id,omitempty"`
+
+	// Command events
+	Command string        `json:"command,omitempty"`
+	Order   *OrderRecord  `json:"order,omitempty"` // place commands
+	Trade   *models.Trade `json:"trade,omitempty"` // modify commands, the new quantity and price
+
+	From            OrderState          `json:"from,omitempty"`
+	To              OrderState          `json:"to,omitempty"`
+	ExchangeOrderID string              `json:"exchange_order_id,omitempty"`
+	Quantity        *decimal.Decimal    `json:"quantity,omitempty"` // amendments
+	Update          *models.OrderUpdate `json:"update,omitempty"`
+	Fill            *Fill               `json:"fill,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	Attempt         int                 `json:"attempt,omitempty"` // retries
+}
+
+// Describe summarises the event in a line for an order's timeline
+func (e OrderEvent) Describe() string {
+	switch e.Type {
+	case EventCommand:
+		if e.Order != nil {
+			t := e.Order.Trade
+			desc := fmt.Sprintf("place %s %s %s %s", t.Type, t.Side, t.Quantity, t.Symbol)
+			if price := t.ReferencePrice(); price.IsPositive() {
+				desc += " @ " + price.String()
+			}
+			if t.ReduceOnly {
+				desc += " reduce-only"
+			}
+			return desc
+		}
+		if e.Trade != nil {
+			return fmt.Sprintf("%s to %s @ %s", e.Command, e.Trade.Quantity, e.Trade.Price)
+		}
+		return e.Command
+	case EventRejected:
+		return fmt.Sprintf("%s rejected: %s", e.Command, e.Error)
+	case EventState:
+		return fmt.Sprintf("%s -> %s", e.From, e.To)
+	case EventPlaced:
+		return "accepted as " + e.ExchangeOrderID
+	case EventUpdate:
+		u := e.Update
+		desc := fmt.Sprintf("exchange reports %s, %s of %s filled", u.Status, u.FilledQuantity, u.Quantity)
+		if u.AveragePrice.IsPositive() {
+			desc += " @ " + u.AveragePrice.String()
+		}
+		return desc
+	case EventFill:
+		if e.Fill.Implied {
+			return fmt.Sprintf("filled %s @ %s, implied by the update", e.Fill.Quantity, e.Fill.Price)
+		}
+		return fmt.Sprintf("filled %s @ %s", e.Fill.Quantity, e.Fill.Price)
+	case EventAmended:
+		return "quantity amended to " + e.Quantity.String()
+	case EventError:
+		return e.Error
+	case EventRestored:
+		return "reloaded after restart"
+	case EventRetry:
+		return fmt.Sprintf("%s retry %d after: %s", e.Command, e.Attempt, e.Error)
+	}
+	return string(e.Type)
+}
+
+// ProjectOrders folds a journal into the state of every order it records,
+// in the order they were placed. Recovering the records rebuilds the
+// OrderStateManager that wrote the journal.
+func ProjectOrders(events []OrderEvent) []OrderRecord {
+	var records []OrderRecord
+	index := make(map[string]int) // order ID to index in records
+	for _, e := range events {
+		if e.Type == EventCommand && e.Order != nil {
+			if _, exists := index[e.OrderID]; !exists {
+				index[e.OrderID] = len(records)
+				records = append(records, *e.Order)
+			}
+			continue
+		}
+
+		i, exists := index[e.OrderID]
+		if !exists {
+			continue
+		}
+		r := &records[i]
+		r.UpdatedAt = e.Time
+		switch e.Type {
+		case EventState:
+			// Concurrent changes may be journaled out of order. States
+			// only move towards a terminal one, so a change that is no
+			// longer valid was overtaken.
+			if IsValidTransition(r.State, e.To) {
+				r.State = e.To
+			}
+		case EventPlaced:
+			r.ExchangeOrderID = e.ExchangeOrderID
+		case EventFill:
+			r.Fills = mergeFill(r.Fills, *e.Fill)
+		case EventAmended:
+			r.Trade.Quantity = *e.Quantity
+		case EventError:
+			r.Error = e.Error
+		}
+	}
+	return records
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// ErrOrderRejected is set on an order the exchange accepted and later
+// refused
+var ErrOrderRejected = errors.New("order rejected by the exchange")
+
+// parkedEventTTL is how long an event for an unknown exchange order is kept
+// for the order it may belong to. The stream can report a fill before the
+// place call that created the order has returned.
+const parkedEventTTL = time.Minute
+
+// parkedEvent is a fill or order update waiting for its order
+type parkedEvent struct {
+	fill     *models.Fill
+	update   *models.OrderUpdate
+	received time.Time
+}
+
+// BindExchangeOrderID records the ID the exchange assigned to an order and
+// applies any events that arrived for it first
+func (m *OrderStateManager) BindExchangeOrderID(orderID, exchangeOrderID string) error {
+	return m.bindExchangeOrderID(orderID, exchangeOrderID, 0)
+}
+
+// bindExchangeOrderID is BindExchangeOrderID journaling the event that
+// caused it
+func (m *OrderStateManager) bindExchangeOrderID(orderID, exchangeOrderID string, cause int64) error {
+	order, exists := m.GetOrder(orderID)
+	if !exists {
+		return errors.New("order not found")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order.setExchangeOrderID(exchangeOrderID, cause)
+	m.exchangeIDs[exchangeOrderID] = orderID
+
+	events := m.parked[exchangeOrderID]
+	delete(m.parked, exchangeOrderID)
+	var errs []error
+	for _, e := range events {
+		if err := m.apply(order, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetOrderByExchangeID retrieves an order by the ID the exchange assigned
+// to it
+func (m *OrderStateManager) GetOrderByExchangeID(exchangeOrderID string) (*AtomicOrder, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lookup(exchangeOrderID)
+}
+
+// ApplyFill records a fill the exchange reported against the order it
+// belongs to. Fills already applied are ignored, so streamed and polled
+// fills may overlap. Fills for orders the manager does not know yet are
+// held back briefly in case the order is still being placed.
+func (m *OrderStateManager) ApplyFill(fill models.Fill) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, exists := m.lookup(fill.OrderID)
+	if !exists {
+		m.park(fill.OrderID, parkedEvent{fill: &fill})
+		return nil
+	}
+	return m.applyFill(order, fill)
+}
+
+// ApplyOrderUpdate moves an order to the state the exchange reports. Fills
+// drive the filled quantity; an update reporting more filled than the fills
+// applied, e.g. after fills were missed while disconnected, is made up with
+// an implied fill at the implied price. The exchange's fills replace it as
+// they arrive.
+func (m *OrderStateManager) ApplyOrderUpdate(update models.OrderUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, exists := m.lookup(update.OrderID)
+	if !exists {
+		m.park(update.OrderID, parkedEvent{update: &update})
+		return nil
+	}
+	return m.applyUpdate(order, update)
+}
+
+// The helpers below must be called with m.mu held
+
+func (m *OrderStateManager) lookup(exchangeOrderID string) (*AtomicOrder, bool) {
+	orderID, exists := m.exchangeIDs[exchangeOrderID]
+	if !exists {
+		return nil, false
+	}
+	return m.GetOrder(orderID)
+}
+
+func (m *OrderStateManager) apply(order *AtomicOrder, e parkedEvent) error {
+	if e.fill != nil {
+		return m.applyFill(order, *e.fill)
+	}
+	return m.applyUpdate(order, *e.update)
+}
+
+func (m *OrderStateManager) applyFill(order *AtomicOrder, fill models.Fill) error {
+	key := fillKey(fill)
+	seen := m.fillKeys[fill.OrderID]
+	if seen[key] {
+		return nil
+	}
+
+	if err := order.AddFill(Fill{ID: key, Quantity: fill.Quantity, Price: fill.Price, Timestamp: fill.Timestamp}); err != nil {
+		return fmt.Errorf("failed to apply fill to order %s: %w", order.ID, err)
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+		m.fillKeys[fill.OrderID] = seen
+	}
+	seen[key] = true
+	return nil
+}
+
+func (m *OrderStateManager) applyUpdate(order *AtomicOrder, update models.OrderUpdate) error {
+	if order.IsTerminal() {
+		// A repeated or late update, e.g. from polling after the stream
+		return nil
+	}
+	amended := !update.Status.IsFinal() && update.Quantity.IsPositive() && !update.Quantity.Equal(order.Quantity)
+	filled := order.GetFilledQuantity()
+	missing := update.FilledQuantity.Sub(filled)
+	if !amended && !missing.IsPositive() && !update.Status.IsFinal() {
+		// Nothing new, as for most polls of a resting order
+		return nil
+	}
+
+	// The changes below are journaled as caused by the update
+	cause := order.emit(OrderEvent{Type: EventUpdate, Update: &update})
+	if amended {
+		order.setQuantity(update.Quantity, cause)
+	}
+
+	if missing.IsPositive() && update.AveragePrice.IsPositive() {
+		// The fills seen and the missing one average to the reported price
+		value := update.AveragePrice.Mul(update.FilledQuantity).Sub(order.GetAverageFilledPrice().Mul(filled))
+		price := models.RoundHalfEven.Round(value.Div(missing), models.PriceDecimals)
+		if err := order.addFill(Fill{Quantity: missing, Price: price, Timestamp: update.Timestamp, Implied: true}, cause); err != nil {
+			return fmt.Errorf("failed to apply fills of order %s: %w", order.ID, err)
+		}
+	}
+
+	switch update.Status {
+	case models.OrderStatusFilled:
+		// Reduce-only orders fill no more than the position, short of
+		// their quantity
+		if !order.IsTerminal() {
+			order.transition(OrderStateFilled, cause)
+		}
+	case models.OrderStatusCanceled, models.OrderStatusExpired:
+		if !order.IsTerminal() {
+			order.transition(OrderStateCanceled, cause)
+		}
+	case models.OrderStatusRejected:
+		order.fail(fmt.Errorf("%w: %s", ErrOrderRejected, update.OrderID), cause)
+	}
+	return nil
+}
+
+// park holds an event for an unknown order and drops expired ones
+func (m *OrderStateManager) park(exchangeOrderID string, e parkedEvent) {
+	now := time.Now()
+	for id, events := range m.parked {
+		kept := events[:0]
+		for _, parked := range events {
+			if now.Sub(parked.received) < parkedEventTTL {
+				kept = append(kept, parked)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.parked, id)
+		} else {
+			m.parked[id] = kept
+		}
+	}
+
+	e.received = now
+	m.parked[exchangeOrderID] = append(m.parked[exchangeOrderID], e)
+}
+
+// fillKey identifies a fill, by the venue's fill ID where there is one
+func fillKey(fill models.Fill) string {
+	if fill.ID != "" {
+		return fill.ID
+	}
+	return fmt.Sprintf("%s/%s@%s/%d", fill.OrderID, fill.Quantity, fill.Price, fill.Timestamp.UnixNano())
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestImpliedFillsAreReplacedByExchangeFills(t *testing.T) {
+	m := NewOrderStateManager()
+	order := m.RestoreOrder(OrderRecord{
+		ID:              "ORD-1",
+		ExchangeOrderID: "EX-1",
+		Trade:           models.Trade{Symbol: "BTC/USDT", Quantity: dec("1")},
+		State:           OrderStateActive,
+	})
+	now := time.Now()
+
+	// The update reports a fill not seen yet
+	if err := m.ApplyOrderUpdate(models.OrderUpdate{OrderID: "EX-1", Status: models.OrderStatusPartiallyFilled, Quantity: dec("1"), FilledQuantity: dec("0.4"), AveragePrice: dec("100")}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := order.GetFilledQuantity(); !got.Equal(dec("0.4")) {
+		t.Fatalf("filled after update = %s, want 0.4", got)
+	}
+
+	// The missed fill arriving replaces the implied one
+	if err := m.ApplyFill(models.Fill{ID: "F1", OrderID: "EX-1", Quantity: dec("0.4"), Price: dec("100"), Timestamp: now}); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if got := order.GetFilledQuantity(); !got.Equal(dec("0.4")) {
+		t.Fatalf("filled after the missed fill = %s, want 0.4", got)
+	}
+	if fills := order.GetFills(); len(fills) != 1 || fills[0].Implied {
+		t.Fatalf("fills = %+v, want the exchange fill only", fills)
+	}
+
+	// The order fills in full by update, the rest of its fills arrive late
+	if err := m.ApplyOrderUpdate(models.OrderUpdate{OrderID: "EX-1", Status: models.OrderStatusFilled, Quantity: dec("1"), FilledQuantity: dec("1"), AveragePrice: dec("103")}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if state := order.GetState(); state != OrderStateFilled {
+		t.Fatalf("state = %s, want Filled", state)
+	}
+	for i, q := range []string{"0.2", "0.4"} {
+		fill := models.Fill{ID: "F" + string(rune('2'+i)), OrderID: "EX-1", Quantity: dec(q), Price: dec("105"), Timestamp: now}
+		if err := m.ApplyFill(fill); err != nil {
+			t.Fatalf("late fill %d: %v", i, err)
+		}
+	}
+	if got := order.GetFilledQuantity(); !got.Equal(dec("1")) {
+		t.Fatalf("filled = %s, want 1", got)
+	}
+	if got := order.GetAverageFilledPrice(); !got.Equal(dec("103")) {
+		t.Fatalf("average price = %s, want 103", got)
+	}
+	for _, f := range order.GetFills() {
+		if f.Implied {
+			t.Fatalf("implied fill %+v left after every fill arrived", f)
+		}
+	}
+}
+
+func TestMergeFill(t *testing.T) {
+	implied := func(q string) Fill { return Fill{Quantity: dec(q), Price: dec("10"), Implied: true} }
+	exchangeFill := func(q string) Fill { return Fill{ID: q, Quantity: dec(q), Price: dec("10")} }
+
+	tests := []struct {
+		name   string
+		fills  []Fill
+		fill   Fill
+		filled string
+		n      int
+	}{
+		{"real fill adds", []Fill{exchangeFill("1")}, exchangeFill("2"), "3", 2},
+		{"implied fill adds", []Fill{exchangeFill("1")}, implied("2"), "3", 2},
+		{"real fill replaces part of implied", []Fill{implied("3")}, exchangeFill("1"), "3", 2},
+		{"real fill replaces implied", []Fill{implied("3")}, exchangeFill("3"), "3", 1},
+		{"real fill beyond implied adds the rest", []Fill{implied("1")}, exchangeFill("3"), "3", 1},
+		{"oldest implied replaced first", []Fill{implied("1"), implied("2")}, exchangeFill("2"), "3", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeFill(tt.fills, tt.fill)
+			if got := sumQuantity(merged); !got.Equal(dec(tt.filled)) {
+				t.Errorf("filled = %s, want %s", got, tt.filled)
+			}
+			if len(merged) != tt.n {
+				t.Errorf("%d fills, want %d: %+v", len(merged), tt.n, merged)
+			}
+		})
+	}
+}
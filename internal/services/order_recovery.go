@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// ErrPlacementUnknown is set on an order whose placement could not be
+// confirmed: it was being placed when the app stopped and the exchange has
+// no order under its client order ID, or retries after network errors ran
+// out. It may have been rejected or never arrived, check the exchange.
+var ErrPlacementUnknown = errors.New("placement outcome unknown")
+
+// errNotPlaced is set on a recovered order that was still queued when the
+// app stopped and was never sent
+var errNotPlaced = errors.New("not placed before restart")
+
+// RecoveryReport describes what Recover found
+type RecoveryReport struct {
+	// Restored is the number of open orders reloaded from the journal
+	Restored int
+	// Lost are the orders whose placement could not be confirmed, failed
+	// with errNotPlaced or ErrPlacementUnknown
+	Lost []*AtomicOrder
+	// Unresolved are orders being placed when the app stopped that could
+	// not be looked up. They stay tracked, without an exchange order ID,
+	// and are looked up again on the next start.
+	Unresolved []*AtomicOrder
+	// Untracked are open orders on the exchange the store does not know,
+	// e.g. placed by hand
+	Untracked []models.OrderUpdate
+}
+
+// Recover reloads the orders still open in records, projected from the
+// order journal, and reconciles them with the exchange's open orders. It
+// must run before new commands are queued. Orders the exchange accepted
+// are tracked again and polled for what happened while the app was down.
+// Orders that were being placed are matched to open orders by client order
+// ID, or else looked up by it, as they may have filled or been cancelled
+// meanwhile. It fails, restoring nothing, only if the open orders cannot
+// be fetched; errors reconciling single orders are logged.
+func (s *OrderSync) Recover(records []OrderRecord) (RecoveryReport, error) {
+	var report RecoveryReport
+	open, err := s.client.GetOpenOrders("")
+	if err != nil {
+		return report, fmt.Errorf("failed to get open orders: %w", err)
+	}
+	byClientID := make(map[string]models.OrderUpdate)
+	for _, o := range open {
+		if o.ClientOrderID != "" {
+			byClientID[o.ClientOrderID] = o
+		}
+	}
+
+	for _, record := range records {
+		if record.State.IsTerminal() {
+			continue
+		}
+		order := s.orders.RestoreOrder(record)
+		report.Restored++
+
+		// What recovery decides is journaled as caused by the restart
+		restored := order.emit(OrderEvent{Type: EventRestored})
+		if record.ExchangeOrderID != "" {
+			continue
+		}
+		placed, found := byClientID[record.Trade.ClientOrderID]
+		if !found && record.Trade.ClientOrderID != "" {
+			var err error
+			placed, err = s.client.GetOrderByClientID(record.Trade.ClientOrderID)
+			if err != nil && !errors.Is(err, exchange.ErrOrderNotFound) {
+				log.Printf("Order sync: failed to look up order %s: %v", order.ID, err)
+				report.Unresolved = append(report.Unresolved, order)
+				continue
+			}
+			found = err == nil
+		}
+		switch {
+		case found:
+			// The place command may not have been journaled as started
+			if order.GetState() == OrderStatePending {
+				order.transition(OrderStateActive, restored)
+			}
+			if err := s.orders.bindExchangeOrderID(order.ID, placed.OrderID, restored); err != nil {
+				log.Printf("Order sync: %v", err)
+			}
+		case order.GetState() == OrderStateActive:
+			order.fail(ErrPlacementUnknown, restored)
+			report.Lost = append(report.Lost, order)
+		default:
+			order.fail(errNotPlaced, restored)
+			report.Lost = append(report.Lost, order)
+		}
+	}
+
+	// Catch up on fills and cancels, including of orders no longer open
+	if err := s.Poll(); err != nil {
+		log.Printf("Order sync: %v", err)
+	}
+
+	for _, o := range open {
+		if _, tracked := s.orders.GetOrderByExchangeID(o.OrderID); !tracked {
+			report.Untracked = append(report.Untracked, o)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// fakeExchange serves orders and fills from memory. Methods it does not
+// override panic on the nil embedded Exchange.
+type fakeExchange struct {
+	exchange.Exchange
+	orders    []models.OrderUpdate
+	fills     []models.Fill
+	lookupErr map[string]error // by client order ID
+}
+
+func (f *fakeExchange) GetOpenOrders(symbol string) ([]models.OrderUpdate, error) {
+	var open []models.OrderUpdate
+	for _, o := range f.orders {
+		if !o.Status.IsFinal() && (symbol == "" || o.Symbol == symbol) {
+			open = append(open, o)
+		}
+	}
+	return open, nil
+}
+
+func (f *fakeExchange) GetOrder(orderID string) (models.OrderUpdate, error) {
+	for _, o := range f.orders {
+		if o.OrderID == orderID {
+			return o, nil
+		}
+	}
+	return models.OrderUpdate{}, exchange.ErrOrderNotFound
+}
+
+func (f *fakeExchange) GetOrderByClientID(clientOrderID string) (models.OrderUpdate, error) {
+	if err := f.lookupErr[clientOrderID]; err != nil {
+		return models.OrderUpdate{}, err
+	}
+	for _, o := range f.orders {
+		if o.ClientOrderID == clientOrderID {
+			return o, nil
+		}
+	}
+	return models.OrderUpdate{}, exchange.ErrOrderNotFound
+}
+
+func (f *fakeExchange) GetFills(symbol string) ([]models.Fill, error) {
+	var fills []models.Fill
+	for _, fill := range f.fills {
+		if symbol == "" || fill.Symbol == symbol {
+			fills = append(fills, fill)
+		}
+	}
+	return fills, nil
+}
+
+func TestRecoverLooksUpOrdersBeingPlaced(t *testing.T) {
+	client := &fakeExchange{
+		orders: []models.OrderUpdate{
+			{OrderID: "EX-A", ClientOrderID: "ORD-A", Symbol: "BTC/USDT", Status: models.OrderStatusOpen, Quantity: dec("1")},
+			{OrderID: "EX-B", ClientOrderID: "ORD-B", Symbol: "BTC/USDT", Status: models.OrderStatusFilled, Quantity: dec("1"), FilledQuantity: dec("1"), AveragePrice: dec("100")},
+		},
+		fills: []models.Fill{
+			{ID: "F1", OrderID: "EX-B", Symbol: "BTC/USDT", Quantity: dec("1"), Price: dec("100"), Timestamp: time.Now()},
+		},
+		lookupErr: map[string]error{"ORD-D": fmt.Errorf("%w: timeout", exchange.ErrNetwork)},
+	}
+	record := func(id string, state OrderState) OrderRecord {
+		return OrderRecord{
+			ID:    id,
+			Trade: models.Trade{ClientOrderID: id, Symbol: "BTC/USDT", Side: models.SideBuy, Type: models.OrderTypeLimit, Quantity: dec("1"), Price: dec("100")},
+			State: state,
+		}
+	}
+
+	m := NewOrderStateManager()
+	report, err := NewOrderSync(client, m).Recover([]OrderRecord{
+		record("ORD-A", OrderStateActive),  // resting
+		record("ORD-B", OrderStateActive),  // filled while the app was down
+		record("ORD-C", OrderStateActive),  // never arrived
+		record("ORD-D", OrderStateActive),  // lookup fails
+		record("ORD-E", OrderStatePending), // never sent
+	})
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	tests := []struct {
+		id         string
+		state      OrderState
+		exchangeID string
+		err        error
+	}{
+		{"ORD-A", OrderStateActive, "EX-A", nil},
+		{"ORD-B", OrderStateFilled, "EX-B", nil},
+		{"ORD-C", OrderStateFailed, "", ErrPlacementUnknown},
+		{"ORD-D", OrderStateActive, "", nil},
+		{"ORD-E", OrderStateFailed, "", errNotPlaced},
+	}
+	for _, tt := range tests {
+		order, exists := m.GetOrder(tt.id)
+		if !exists {
+			t.Errorf("%s not restored", tt.id)
+			continue
+		}
+		if state := order.GetState(); state != tt.state {
+			t.Errorf("%s state = %s, want %s", tt.id, state, tt.state)
+		}
+		if id := order.GetExchangeOrderID(); id != tt.exchangeID {
+			t.Errorf("%s exchange order ID = %q, want %q", tt.id, id, tt.exchangeID)
+		}
+		if err := order.GetError(); !errors.Is(err, tt.err) {
+			t.Errorf("%s error = %v, want %v", tt.id, err, tt.err)
+		}
+	}
+
+	if len(report.Lost) != 2 {
+		t.Errorf("%d orders lost, want ORD-C and ORD-E", len(report.Lost))
+	}
+	if len(report.Unresolved) != 1 || report.Unresolved[0].ID != "ORD-D" {
+		t.Errorf("unresolved = %v, want ORD-D", report.Unresolved)
+	}
+	if len(report.Untracked) != 0 {
+		t.Errorf("untracked = %v, want none", report.Untracked)
+	}
+}
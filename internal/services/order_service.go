@@ -2,45 +2,122 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/sub0xdai/n0xtilus/internal/api"
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
 	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
 )
 
+// ErrUnprotectedPosition means a position was left open without its stop
+// loss and must be closed by hand
+var ErrUnprotectedPosition = errors.New("position left open without stop loss")
+
+// errEntryNotFilled means an entry closed without filling, so there is
+// nothing to protect or unwind
+var errEntryNotFilled = errors.New("entry not filled")
+
+const (
+	// acceptTimeout is how long an order may take to be accepted
+	acceptTimeout = 5 * time.Second
+	// cancelTimeout is how long a cancelled order may take to be reported
+	// closed
+	cancelTimeout = 10 * time.Second
+)
+
 type OrderService struct {
-	client         *api.APIClient
+	client         exchange.Exchange
 	riskCalculator risk_calculator.RiskCalculatorService
+	costs          risk_calculator.CostModel
+	marginMode     risk_calculator.MarginMode
+	tiers          []risk_calculator.MaintenanceTier
+	instruments    *exchange.InstrumentCache
 }
 
-func NewOrderService(client *api.APIClient, riskCalculator risk_calculator.RiskCalculatorService) *OrderService {
+func NewOrderService(client exchange.Exchange, riskCalculator risk_calculator.RiskCalculatorService) *OrderService {
 	return &OrderService{
 		client:         client,
 		riskCalculator: riskCalculator,
+		instruments:    exchange.NewInstrumentCache(client, exchange.DefaultInstrumentTTL),
 	}
 }
 
+// SetInstrumentCache shares an instrument cache, e.g. with the UI, instead
+// of the service fetching its own
+func (s *OrderService) SetInstrumentCache(instruments *exchange.InstrumentCache) {
+	s.instruments = instruments
+}
+
+// SetCostModel sets the fees and slippage accounted for when sizing positions
+func (s *OrderService) SetCostModel(costs risk_calculator.CostModel) {
+	s.costs = costs
+}
+
+// SetMarginConfig sets the margin mode and maintenance tiers used for
+// liquidation prices
+func (s *OrderService) SetMarginConfig(mode risk_calculator.MarginMode, tiers []risk_calculator.MaintenanceTier) {
+	s.marginMode = mode
+	s.tiers = tiers
+}
+
 type OrderServicer interface {
-	CalculatePositionSize(riskPercentage, entryPrice, stopLossPrice float64) (float64, error)
-	PlaceOrder(symbol, side, quantity, price string) (string, error)
+	GetInstrument(symbol string) (models.Instrument, error)
+	CalculatePositionSize(inst models.Instrument, balance decimal.Decimal, riskPercentage float64, entryPrice, stopLossPrice decimal.Decimal) (decimal.Decimal, error)
+	CalculateLiquidationPrice(inst models.Instrument, side string, entryPrice, quantity decimal.Decimal, leverage float64) (decimal.Decimal, error)
+	CalculateTakeProfits(inst models.Instrument, entryPrice, stopLossPrice, quantity decimal.Decimal, targets []risk_calculator.TakeProfitTarget) ([]risk_calculator.TakeProfitLevel, error)
+	PlaceOrder(trade models.Trade) (string, error)
+	LinkOCO(orderIDs ...string) error
 	CancelOrder(orderID string) error
-	ModifyOrder(orderID, quantity, price string) error
+	ModifyOrder(orderID string, quantity, price decimal.Decimal) error
+	FindOrder(clientOrderID string) (models.OrderUpdate, error)
 }
 
 type TradeExecutor struct {
-	client         *api.APIClient
+	client         exchange.Exchange
 	orderService   OrderServicer
 	riskPercentage float64
 	symbol         string
 	side           string
-	entryPrice     float64
-	stopLossPrice  float64
+	entryPrice     decimal.Decimal
+	stopLossPrice  decimal.Decimal
+	leverage       float64
+	takeProfits    []risk_calculator.TakeProfitTarget
+	fillTimeout    time.Duration
+	cancelUnfilled bool
 	commandQueue   *CommandQueue
+	sharedQueue    bool // commandQueue runs on its own, see SetCommandQueue
+}
+
+// TradeResult describes the orders placed for an executed trade
+type TradeResult struct {
+	OrderID          string
+	StopLossOrderID  string
+	Symbol           string
+	Side             string
+	Quantity         decimal.Decimal
+	EntryPrice       decimal.Decimal
+	StopLossPrice    decimal.Decimal
+	LiquidationPrice decimal.Decimal
+	Leverage         float64
+	Balance          decimal.Decimal
+
+	// TakeProfits and TakeProfitOrderIDs are the scaled exits placed, in
+	// target order
+	TakeProfits        []risk_calculator.TakeProfitLevel
+	TakeProfitOrderIDs []string
+
+	// Warnings describe failures that left the trade open, such as a take
+	// profit the exchange rejected
+	Warnings []string
 }
 
-func NewTradeExecutor(client *api.APIClient, orderService OrderServicer, riskPercentage float64, symbol string, side string, entryPrice float64, stopLossPrice float64) *TradeExecutor {
+func NewTradeExecutor(client exchange.Exchange, orderService OrderServicer, riskPercentage float64, symbol string, side string, entryPrice decimal.Decimal, stopLossPrice decimal.Decimal, leverage float64) *TradeExecutor {
 	return &TradeExecutor{
 		client:         client,
 		orderService:   orderService,
@@ -49,130 +126,556 @@ func NewTradeExecutor(client *api.APIClient, orderService OrderServicer, riskPer
 		side:           side,
 		entryPrice:     entryPrice,
 		stopLossPrice:  stopLossPrice,
-		commandQueue:   NewCommandQueue(100), // Buffer size of 100 commands
+		leverage:       leverage,
+		commandQueue:   NewCommandQueue(100, nil), // Buffer size of 100 commands
 	}
 }
 
-func (te *TradeExecutor) Execute() error {
+// SetCommandQueue queues the trade's orders on a shared queue already
+// started, so they take their turn with every other command. The queue's
+// state manager and retry policies apply.
+func (te *TradeExecutor) SetCommandQueue(q *CommandQueue) {
+	te.commandQueue = q
+	te.sharedQueue = true
+}
+
+// SetFillTimeout sets how long Execute waits for the entry to fill before
+// placing the exits, zero to place them once it is accepted, as by default.
+// Fills only reach a state manager kept in sync with the exchange, so wait
+// only on a queue sharing one, see SetCommandQueue. With cancelUnfilled an
+// entry still resting then is cancelled and what filled is protected;
+// otherwise the exits are placed for the full size.
+func (te *TradeExecutor) SetFillTimeout(timeout time.Duration, cancelUnfilled bool) {
+	te.fillTimeout = timeout
+	te.cancelUnfilled = cancelUnfilled
+}
+
+// SetTakeProfits sets the scaled exits placed once the entry fills
+func (te *TradeExecutor) SetTakeProfits(targets []risk_calculator.TakeProfitTarget) {
+	te.takeProfits = targets
+}
+
+func (te *TradeExecutor) Execute() (TradeResult, error) {
+	result := TradeResult{
+		Symbol:        te.symbol,
+		Side:          te.side,
+		EntryPrice:    te.entryPrice,
+		StopLossPrice: te.stopLossPrice,
+		Leverage:      te.leverage,
+	}
+
 	// Validate trade parameters
 	if err := te.validateTrade(); err != nil {
-		return fmt.Errorf("trade validation failed: %w", err)
+		return result, fmt.Errorf("trade validation failed: %w", err)
+	}
+
+	inst, err := te.orderService.GetInstrument(te.symbol)
+	if err != nil {
+		return result, fmt.Errorf("failed to get instrument: %w", err)
+	}
+
+	// Orders off the tick are rejected, size from the prices actually sent
+	te.entryPrice = inst.RoundPrice(te.entryPrice, models.RoundHalfEven)
+	te.stopLossPrice = inst.RoundPrice(te.stopLossPrice, models.RoundHalfEven)
+	if te.entryPrice.Equal(te.stopLossPrice) {
+		return result, fmt.Errorf("trade validation failed: entry and stop loss round to the same tick %s", inst.TickSize)
 	}
+	result.EntryPrice = te.entryPrice
+	result.StopLossPrice = te.stopLossPrice
 
-	// Calculate position size
+	balance, err := te.client.GetBalance()
+	if err != nil {
+		return result, fmt.Errorf("failed to get account balance: %w", err)
+	}
+
+	// Size from the balance the entry is validated against
 	posSize, err := te.orderService.CalculatePositionSize(
+		inst,
+		balance,
 		te.riskPercentage,
 		te.entryPrice,
 		te.stopLossPrice,
 	)
 	if err != nil {
-		return fmt.Errorf("position size calculation failed: %w", err)
+		return result, fmt.Errorf("position size calculation failed: %w", err)
 	}
+	result.Quantity = posSize
 
-	// Start the command queue
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	te.commandQueue.Start(ctx, te.orderService)
-	defer te.commandQueue.Stop()
+	liquidationPrice, err := te.orderService.CalculateLiquidationPrice(inst, te.side, te.entryPrice, posSize, te.leverage)
+	if err != nil {
+		return result, fmt.Errorf("liquidation price calculation failed: %w", err)
+	}
+	result.LiquidationPrice = liquidationPrice
+
+	if len(te.takeProfits) > 0 {
+		result.TakeProfits, err = te.orderService.CalculateTakeProfits(inst, te.entryPrice, te.stopLossPrice, posSize, te.takeProfits)
+		if err != nil {
+			return result, fmt.Errorf("take profit calculation failed: %w", err)
+		}
+	}
+
+	// Start the command queue, unless it is shared and running
+	if !te.sharedQueue {
+		ctx, cancel := context.WithCancel(context.Background())
+		te.commandQueue.Start(ctx, te.orderService)
+		defer func() {
+			cancel()
+			te.commandQueue.Stop()
+		}()
+	}
 
 	// Create main order command
 	mainOrderCmd := OrderCommand{
-		Type:      CommandPlaceOrder,
-		Symbol:    te.symbol,
-		Side:      te.side,
-		Quantity:  fmt.Sprintf("%.8f", posSize),
-		Price:     fmt.Sprintf("%.8f", te.entryPrice),
-		OrderID:   generateOrderID(),
-		Timestamp: time.Now(),
+		Type:             CommandPlaceOrder,
+		Trade:            models.NewLimitTrade(te.symbol, models.OrderSide(te.side), posSize, te.entryPrice),
+		Instrument:       inst,
+		OrderID:          generateOrderID(),
+		Timestamp:        time.Now(),
+		Leverage:         te.leverage,
+		RiskPercentage:   te.riskPercentage,
+		AccountBalance:   balance,
+		StopLoss:         te.stopLossPrice,
+		LiquidationPrice: liquidationPrice,
 	}
 
 	// Enqueue main order
 	if err := te.commandQueue.Enqueue(mainOrderCmd); err != nil {
-		return fmt.Errorf("failed to enqueue main order: %w", err)
+		return result, fmt.Errorf("failed to enqueue main order: %w", err)
 	}
 
-	// Wait for main order to complete
-	mainOrderStatus, err := te.waitForOrderCompletion(mainOrderCmd.OrderID)
+	// Wait for the exchange to accept the entry
+	mainOrderStatus, err := te.waitForOrderAccepted(mainOrderCmd.OrderID)
 	if err != nil {
-		return fmt.Errorf("main order failed: %w", err)
+		err = fmt.Errorf("main order failed: %w", err)
+		if te.exchangeOrderID(mainOrderCmd.OrderID) != "" {
+			// The exchange accepted the entry, it may have filled
+			return result, te.rollback(mainOrderCmd, err)
+		}
+		return result, err
+	}
+	result.OrderID = te.exchangeOrderID(mainOrderStatus.OrderID)
+
+	// Exits go on once the entry fills, sized to what filled
+	protected, err := te.waitForEntryFill(mainOrderCmd.OrderID, posSize, &result)
+	if errors.Is(err, errEntryNotFilled) {
+		return result, fmt.Errorf("main order failed: %w", err)
+	}
+	if err != nil {
+		return result, te.rollback(mainOrderCmd, fmt.Errorf("main order failed: %w", err))
+	}
+	if !protected.Equal(posSize) {
+		result.Quantity = protected
+		if len(te.takeProfits) > 0 {
+			result.TakeProfits, err = te.orderService.CalculateTakeProfits(inst, te.entryPrice, te.stopLossPrice, protected, te.takeProfits)
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("take profits not placed for the partial fill: %v", err))
+			}
+		}
 	}
 
-	// Create stop loss order command
+	// Protect the entry with a reduce-only stop-market order. If that
+	// fails the position must not be left open.
 	stopLossCmd := OrderCommand{
-		Type:      CommandPlaceOrder,
-		Symbol:    te.symbol,
-		Side:      te.getOpposingSide(),
-		Quantity:  fmt.Sprintf("%.8f", posSize),
-		Price:     fmt.Sprintf("%.8f", te.stopLossPrice),
-		OrderID:   generateOrderID(),
-		Timestamp: time.Now(),
-	}
-
-	// Enqueue stop loss order
-	if err := te.commandQueue.Enqueue(stopLossCmd); err != nil {
-		// If stop loss fails, try to cancel the main order
-		cancelCmd := OrderCommand{
-			Type:      CommandCancelOrder,
-			OrderID:   mainOrderStatus.OrderID,
-			Timestamp: time.Now(),
+		Type:           CommandPlaceOrder,
+		Trade:          models.NewStopMarketTrade(te.symbol, te.getOpposingSide(), protected, te.stopLossPrice),
+		Instrument:     inst,
+		OrderID:        generateOrderID(),
+		Timestamp:      time.Now(),
+		Leverage:       te.leverage,
+		RiskPercentage: te.riskPercentage,
+		AccountBalance: balance,
+	}
+
+	err = te.commandQueue.Enqueue(stopLossCmd)
+	if err == nil {
+		_, err = te.waitForOrderAccepted(stopLossCmd.OrderID)
+	}
+	if err != nil {
+		return result, te.rollback(mainOrderCmd, fmt.Errorf("failed to place stop loss order: %w", err))
+	}
+	result.StopLossOrderID = te.exchangeOrderID(stopLossCmd.OrderID)
+
+	// The position is protected by the stop, a rejected take profit is
+	// reported but does not unwind the trade
+	var takeProfitIDs []string
+	for i, tp := range result.TakeProfits {
+		orderID, err := te.placeTakeProfit(tp, inst, balance)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("take profit %d at %s not placed: %v", i+1, tp.Price.StringFixed(2), err))
+			continue
 		}
-		_ = te.commandQueue.Enqueue(cancelCmd)
-		return fmt.Errorf("failed to enqueue stop loss order: %w", err)
+		takeProfitIDs = append(takeProfitIDs, orderID)
+		result.TakeProfitOrderIDs = append(result.TakeProfitOrderIDs, te.exchangeOrderID(orderID))
 	}
 
-	return nil
+	// Link the exits so whichever closes the position cancels the rest
+	if len(result.TakeProfitOrderIDs) > 0 {
+		exits := append([]string{result.StopLossOrderID}, result.TakeProfitOrderIDs...)
+		if err := te.orderService.LinkOCO(exits...); err != nil {
+			// Unlinked take profits would outlive the stop, remove them
+			result.Warnings = append(result.Warnings, fmt.Sprintf("take profits cancelled, linking them to the stop failed: %v", err))
+			for i, id := range takeProfitIDs {
+				if cancelErr := te.cancelOrder(id); cancelErr != nil {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("cancelling take profit %s failed: %v", result.TakeProfitOrderIDs[i], cancelErr))
+				}
+			}
+			result.TakeProfitOrderIDs = nil
+		}
+	}
+
+	if result.Balance, err = te.client.GetBalance(); err != nil {
+		result.Balance = balance
+	}
+
+	return result, nil
+}
+
+// rollback unwinds an entry that could not be protected. The entry is
+// cancelled and whatever filled is closed with a reduce-only market order,
+// both through the command queue so they are journaled and retried without
+// being sent twice. The returned error wraps cause, and
+// ErrUnprotectedPosition if the position could not be closed.
+func (te *TradeExecutor) rollback(entry OrderCommand, cause error) error {
+	// A fully filled entry can no longer be cancelled, carry on and flatten
+	cancelErr := te.cancelOrder(entry.OrderID)
+
+	filled, err := te.filledQuantity(te.exchangeOrderID(entry.OrderID))
+	if err != nil {
+		// Reduce-only caps the close at the open position, so closing the
+		// full size is safe when the fills are unknown
+		filled = entry.Trade.Quantity
+	}
+	if !filled.IsPositive() {
+		if cancelErr != nil {
+			return fmt.Errorf("%w (cancelling entry also failed: %v)", cause, cancelErr)
+		}
+		return fmt.Errorf("%w: entry cancelled", cause)
+	}
+
+	closeTrade := models.NewMarketTrade(te.symbol, te.getOpposingSide(), filled)
+	closeTrade.ReduceOnly = true
+	closeCmd := OrderCommand{
+		Type:           CommandPlaceOrder,
+		Trade:          closeTrade,
+		Instrument:     entry.Instrument,
+		OrderID:        generateOrderID(),
+		Timestamp:      time.Now(),
+		Leverage:       entry.Leverage,
+		RiskPercentage: entry.RiskPercentage,
+		AccountBalance: entry.AccountBalance,
+	}
+	err = te.commandQueue.Enqueue(closeCmd)
+	if err == nil {
+		_, err = te.waitForOrderAccepted(closeCmd.OrderID)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w: closing %s %s at market failed: %v", cause, ErrUnprotectedPosition, filled, te.symbol, err)
+	}
+	return fmt.Errorf("%w: entry cancelled and %s %s closed at market", cause, filled, te.symbol)
+}
+
+// cancelOrder cancels an order placed through the queue and waits until it
+// is closed. An order already closed is left as it is.
+func (te *TradeExecutor) cancelOrder(orderID string) error {
+	return cancelQueued(te.commandQueue, orderID)
+}
+
+// filledQuantity returns how much of an exchange order has filled
+func (te *TradeExecutor) filledQuantity(orderID string) (decimal.Decimal, error) {
+	fills, err := te.client.GetFills(te.symbol)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	filled := decimal.Zero
+	for _, f := range fills {
+		if f.OrderID == orderID {
+			filled = filled.Add(f.Quantity)
+		}
+	}
+	return filled, nil
+}
+
+// placeTakeProfit places a reduce-only exit for one take profit level and
+// returns its tracking ID
+func (te *TradeExecutor) placeTakeProfit(tp risk_calculator.TakeProfitLevel, inst models.Instrument, balance decimal.Decimal) (string, error) {
+	trade := models.NewLimitTrade(te.symbol, te.getOpposingSide(), tp.Quantity, tp.Price)
+	trade.ReduceOnly = true
+	cmd := OrderCommand{
+		Type:           CommandPlaceOrder,
+		Trade:          trade,
+		Instrument:     inst,
+		OrderID:        generateOrderID(),
+		Timestamp:      time.Now(),
+		Leverage:       te.leverage,
+		RiskPercentage: te.riskPercentage,
+		AccountBalance: balance,
+	}
+	if err := te.commandQueue.Enqueue(cmd); err != nil {
+		return "", err
+	}
+	if _, err := te.waitForOrderAccepted(cmd.OrderID); err != nil {
+		return "", err
+	}
+	return cmd.OrderID, nil
+}
+
+// waitForOrderAccepted waits until the exchange has accepted an order
+func (te *TradeExecutor) waitForOrderAccepted(orderID string) (OrderCommand, error) {
+	return waitForAccepted(te.commandQueue, orderID)
+}
+
+// waitForAccepted waits until the exchange has accepted an order placed
+// through q. Fills arrive later through the state manager. An order
+// cancelled before it could rest, such as an IOC order that found nothing
+// to fill, fails.
+func waitForAccepted(q *CommandQueue, orderID string) (OrderCommand, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), acceptTimeout)
+	defer cancel()
+	order, err := q.stateManager.WaitForOrder(ctx, orderID, func(o *AtomicOrder) bool {
+		return o.GetExchangeOrderID() != "" || o.IsTerminal()
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return OrderCommand{}, errors.New("order timed out")
+	}
+	if err != nil {
+		return OrderCommand{}, err
+	}
+
+	switch order.GetState() {
+	case OrderStateFailed:
+		return OrderCommand{}, order.GetError()
+	case OrderStateCanceled:
+		if !order.GetFilledQuantity().IsPositive() {
+			return OrderCommand{}, errors.New("order cancelled by the exchange")
+		}
+	}
+	return q.GetStatus(orderID)
 }
 
-func (te *TradeExecutor) waitForOrderCompletion(orderID string) (OrderCommand, error) {
-	maxAttempts := 10
-	for i := 0; i < maxAttempts; i++ {
-		cmd, err := te.commandQueue.GetStatus(orderID)
-		if err == nil {
-			return cmd, nil
+// cancelQueued cancels an order placed through q and waits until it is
+// closed. An order already closed is left as it is. A cancel that fails
+// is reported unless the order turns out to have closed meanwhile, e.g.
+// filled just before.
+func cancelQueued(q *CommandQueue, orderID string) error {
+	order, exists := q.GetOrder(orderID)
+	if !exists {
+		return errors.New("order not found")
+	}
+	if order.IsTerminal() {
+		return nil
+	}
+	result := make(chan error, 1)
+	if err := q.Enqueue(OrderCommand{Type: CommandCancelOrder, OrderID: orderID, Timestamp: time.Now(), Result: result}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	select {
+	case err := <-result:
+		// The order was looked up after a failed cancel, its state tells
+		if err != nil && !order.IsTerminal() {
+			return err
 		}
-		if te.commandQueue.HasFailed(orderID) {
-			return OrderCommand{}, err
+	case <-ctx.Done():
+		return fmt.Errorf("not cancelled within %s", cancelTimeout)
+	}
+
+	order, err := q.stateManager.WaitForOrder(ctx, orderID, func(o *AtomicOrder) bool {
+		return o.IsTerminal()
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("not cancelled within %s", cancelTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	if order.GetState() == OrderStateFailed {
+		return order.GetError()
+	}
+	return nil
+}
+
+// amendQueued amends an order placed through q to the quantity and price
+// of trade and waits for the outcome. A refused amendment leaves the order
+// as the exchange reports it.
+func amendQueued(q *CommandQueue, orderID string, trade models.Trade) error {
+	result := make(chan error, 1)
+	if err := q.Enqueue(OrderCommand{Type: CommandModifyOrder, OrderID: orderID, Trade: trade, Timestamp: time.Now(), Result: result}); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(acceptTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return errors.New("amendment timed out")
+	}
+}
+
+// waitForEntryFill waits up to the fill timeout for an accepted entry to
+// fill and returns the quantity to protect. An entry still resting then is
+// cancelled, keeping what filled, or with cancelling off protected in full
+// while it rests. It fails with errEntryNotFilled if nothing filled and
+// the entry is no longer open.
+func (te *TradeExecutor) waitForEntryFill(orderID string, quantity decimal.Decimal, result *TradeResult) (decimal.Decimal, error) {
+	if te.fillTimeout <= 0 {
+		return quantity, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), te.fillTimeout)
+	defer cancel()
+	state, err := te.commandQueue.stateManager.WaitForState(ctx, orderID, OrderStateFilled)
+	switch {
+	case err == nil:
+		return quantity, nil
+	case errors.Is(err, context.DeadlineExceeded):
+		if !te.cancelUnfilled {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("entry not filled within %s, exits rest for the full size", te.fillTimeout))
+			return quantity, nil
 		}
-		time.Sleep(100 * time.Millisecond)
+	case state == OrderStateCanceled:
+		// Cancelled by the exchange, e.g. by hand
+		return te.entryFilled(orderID, quantity, "cancelled by the exchange", result)
+	default:
+		return decimal.Zero, err
+	}
+
+	// Cancel the rest, what filled up to the cancel is kept. The entry may
+	// fill meanwhile, its state tells.
+	if err := te.cancelOrder(orderID); err != nil {
+		return decimal.Zero, fmt.Errorf("entry not filled within %s and not cancelled: %w", te.fillTimeout, err)
+	}
+	if order, exists := te.commandQueue.GetOrder(orderID); exists && order.GetState() == OrderStateFilled {
+		return quantity, nil
 	}
-	return OrderCommand{}, errors.New("order timed out")
+	return te.entryFilled(orderID, quantity, fmt.Sprintf("cancelled after %s", te.fillTimeout), result)
+}
+
+// entryFilled returns the filled quantity of an entry closed before it
+// filled in full, warning of the partial fill. reason says why it closed.
+func (te *TradeExecutor) entryFilled(orderID string, quantity decimal.Decimal, reason string, result *TradeResult) (decimal.Decimal, error) {
+	order, exists := te.commandQueue.GetOrder(orderID)
+	if !exists {
+		return decimal.Zero, errors.New("order not found")
+	}
+	filled := order.GetFilledQuantity()
+	if !filled.IsPositive() {
+		return decimal.Zero, fmt.Errorf("%w: %s", errEntryNotFilled, reason)
+	}
+	result.Warnings = append(result.Warnings, fmt.Sprintf("entry %s, %s of %s filled and protected", reason, filled, quantity))
+	return filled, nil
+}
+
+// exchangeOrderID returns the exchange assigned ID of a tracked order
+func (te *TradeExecutor) exchangeOrderID(orderID string) string {
+	if order, exists := te.commandQueue.GetOrder(orderID); exists {
+		return order.GetExchangeOrderID()
+	}
+	return ""
 }
 
 func (te *TradeExecutor) validateTrade() error {
-	if te.symbol == "" || te.side == "" {
+	if te.symbol == "" {
 		return errors.New("invalid trade parameters")
 	}
-	if te.entryPrice <= 0 || te.stopLossPrice <= 0 {
+	if _, err := models.ParseOrderSide(te.side); err != nil {
+		return err
+	}
+	if !te.entryPrice.IsPositive() || !te.stopLossPrice.IsPositive() {
 		return errors.New("invalid prices")
 	}
+	if te.leverage < 1 {
+		return errors.New("invalid leverage")
+	}
 	if te.riskPercentage <= 0 || te.riskPercentage > 100 {
 		return errors.New("invalid risk percentage")
 	}
 	return nil
 }
 
-func (te *TradeExecutor) getOpposingSide() string {
-	if te.side == "BUY" {
-		return "SELL"
-	}
-	return "BUY"
+func (te *TradeExecutor) getOpposingSide() models.OrderSide {
+	return models.OrderSide(te.side).Opposite()
 }
 
+// orderSeq keeps fallback IDs generated within the same clock tick apart
+var orderSeq atomic.Int64
+
+// generateOrderID returns a new order ID, also sent as the client order
+// ID. Its random part keeps IDs unique across restarts and app instances,
+// so the exchange can tell a retried placement from a new order.
 func generateOrderID() string {
-	return fmt.Sprintf("ORD-%d", time.Now().UnixNano())
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("ORD-%d-%d", time.Now().UnixNano(), orderSeq.Add(1))
+	}
+	return fmt.Sprintf("ORD-%s-%x", strconv.FormatInt(time.Now().UnixMilli(), 36), b)
+}
+
+// GetInstrument returns the cached trading rules of a symbol
+func (s *OrderService) GetInstrument(symbol string) (models.Instrument, error) {
+	return s.instruments.Get(symbol)
+}
+
+// CalculatePositionSize sizes a position on the instrument's lot grid so
+// that a stop out, including trading costs, loses at most riskPercentage of
+// balance
+func (s *OrderService) CalculatePositionSize(inst models.Instrument, balance decimal.Decimal, riskPercentage float64, entryPrice, stopLossPrice decimal.Decimal) (decimal.Decimal, error) {
+	result, err := s.riskCalculator.CalculatePositionSizeWithCosts(risk_calculator.SizingParams{
+		AccountBalance: balance,
+		RiskPercentage: riskPercentage,
+		EntryPrice:     entryPrice,
+		StopLossPrice:  stopLossPrice,
+		Costs:          s.costs,
+		Instrument:     inst,
+	})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return result.Quantity, nil
+}
+
+// CalculateLiquidationPrice returns the liquidation price of a position
+// under the configured margin mode
+func (s *OrderService) CalculateLiquidationPrice(inst models.Instrument, side string, entryPrice, quantity decimal.Decimal, leverage float64) (decimal.Decimal, error) {
+	params := risk_calculator.LiquidationParams{
+		Side:       side,
+		EntryPrice: entryPrice,
+		Quantity:   quantity,
+		Leverage:   leverage,
+		Mode:       s.marginMode,
+		Tiers:      s.tiers,
+		Instrument: inst,
+	}
+	if s.marginMode == risk_calculator.MarginCross {
+		balance, err := s.client.GetBalance()
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("failed to get account balance: %w", err)
+		}
+		params.AccountBalance = balance
+	}
+	return s.riskCalculator.CalculateLiquidationPrice(params)
+}
+
+// CalculateTakeProfits resolves take profit targets to prices and
+// quantities
+func (s *OrderService) CalculateTakeProfits(inst models.Instrument, entryPrice, stopLossPrice, quantity decimal.Decimal, targets []risk_calculator.TakeProfitTarget) ([]risk_calculator.TakeProfitLevel, error) {
+	return s.riskCalculator.CalculateTakeProfits(inst, entryPrice, stopLossPrice, quantity, targets)
+}
+
+func (s *OrderService) PlaceOrder(trade models.Trade) (string, error) {
+	return s.client.PlaceOrder(trade)
 }
 
-func (s *OrderService) PlaceOrder(symbol, side, quantity, price string) (string, error) {
-	// Implement the order placement logic here
-	// For now, we'll just call the API client's PlaceOrder method
-	return s.client.PlaceOrder(symbol, side, quantity, price)
+func (s *OrderService) LinkOCO(orderIDs ...string) error {
+	return s.client.LinkOCO(orderIDs...)
 }
 
 func (s *OrderService) CancelOrder(orderID string) error {
 	return s.client.CancelOrder(orderID)
 }
 
-func (s *OrderService) ModifyOrder(orderID, quantity, price string) error {
-	// Implement order modification logic
-	return errors.New("modify order not implemented")
+func (s *OrderService) ModifyOrder(orderID string, quantity, price decimal.Decimal) error {
+	return s.client.AmendOrder(orderID, quantity, price)
 }
@@ -5,9 +5,11 @@ import (
 	"time"
 	"errors"
 	"fmt"
+	"log"
 	"sync"
-	"strconv"
 
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
 	"github.com/sub0xdai/n0xtilus/internal/validation"
 )
 
@@ -19,6 +21,7 @@ const (
 	OrderStateValidating
 	OrderStatePending
 	OrderStateActive
+	OrderStatePartiallyFilled
 	OrderStateFilled
 	OrderStateCanceled
 	OrderStateFailed
@@ -35,6 +38,8 @@ func (s OrderState) String() string {
 		return "Pending"
 	case OrderStateActive:
 		return "Active"
+	case OrderStatePartiallyFilled:
+		return "PartiallyFilled"
 	case OrderStateFilled:
 		return "Filled"
 	case OrderStateCanceled:
@@ -46,6 +51,27 @@ func (s OrderState) String() string {
 	}
 }
 
+// IsTerminal reports whether an order in the state can no longer change
+func (s OrderState) IsTerminal() bool {
+	return s == OrderStateFilled || s == OrderStateCanceled || s == OrderStateFailed
+}
+
+// MarshalText encodes the state by name, so stored orders stay readable
+func (s OrderState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state encoded by MarshalText
+func (s *OrderState) UnmarshalText(text []byte) error {
+	for state := OrderStateUnknown; state <= OrderStateFailed; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown order state %q", text)
+}
+
 // OrderStateTransition represents a valid state transition
 type OrderStateTransition struct {
 	From OrderState
@@ -58,9 +84,13 @@ var ValidStateTransitions = []OrderStateTransition{
 	{OrderStateValidating, OrderStateFailed},
 	{OrderStatePending, OrderStateActive},
 	{OrderStatePending, OrderStateFailed},
+	{OrderStateActive, OrderStatePartiallyFilled},
 	{OrderStateActive, OrderStateFilled},
 	{OrderStateActive, OrderStateCanceled},
 	{OrderStateActive, OrderStateFailed},
+	{OrderStatePartiallyFilled, OrderStateFilled},
+	{OrderStatePartiallyFilled, OrderStateCanceled},
+	{OrderStatePartiallyFilled, OrderStateFailed},
 }
 
 // IsValidTransition checks if a state transition is valid
@@ -75,38 +105,45 @@ func IsValidTransition(from, to OrderState) bool {
 
 // AtomicOrder represents an order with atomic state management
 type AtomicOrder struct {
+	models.Trade
 	ID            string
-	Symbol        string
-	Side          string
-	Quantity      string
-	Price         string
 	Leverage      float64
 	RiskPercentage float64
+	StopLoss      decimal.Decimal
+	LiquidationPrice decimal.Decimal
+	Instrument    models.Instrument
+	exchangeOrderID string
 	state         int32
 	timestamp     time.Time
 	error         atomic.Value // stores error
 	fills         atomic.Value // stores []Fill
 	validator     *validation.OrderValidator
+	journal       func(OrderEvent) int64 // set by the manager tracking the order
+	notify        func(*AtomicOrder)     // likewise, tells its subscribers of changes
 	mu            sync.RWMutex // for non-atomic fields
 }
 
 // Fill represents a partial fill of an order
 type Fill struct {
-	Quantity    string
-	Price       string
-	Timestamp   time.Time
+	ID          string          `json:"id,omitempty"` // identifies the exchange fill applied, see fillKey
+	Quantity    decimal.Decimal `json:"quantity"`
+	Price       decimal.Decimal `json:"price"`
+	Timestamp   time.Time       `json:"timestamp"`
+	// Implied marks a fill made up from an order update reporting more
+	// filled than the fills seen. The exchange's fills replace it.
+	Implied bool `json:"implied,omitempty"`
 }
 
 // NewAtomicOrder creates a new atomic order with validation
 func NewAtomicOrder(cmd OrderCommand, validator *validation.OrderValidator) *AtomicOrder {
 	order := &AtomicOrder{
+		Trade:         cmd.Trade,
 		ID:            cmd.OrderID,
-		Symbol:        cmd.Symbol,
-		Side:          cmd.Side,
-		Quantity:      cmd.Quantity,
-		Price:         cmd.Price,
 		Leverage:      cmd.Leverage,
 		RiskPercentage: cmd.RiskPercentage,
+		StopLoss:      cmd.StopLoss,
+		LiquidationPrice: cmd.LiquidationPrice,
+		Instrument:    cmd.Instrument,
 		state:         int32(OrderStateValidating),
 		timestamp:     time.Now(),
 		validator:     validator,
@@ -115,6 +152,28 @@ func NewAtomicOrder(cmd OrderCommand, validator *validation.OrderValidator) *Ato
 	return order
 }
 
+// SetExchangeOrderID records the ID the exchange assigned to the order
+func (o *AtomicOrder) SetExchangeOrderID(id string) {
+	o.setExchangeOrderID(id, 0)
+}
+
+// setExchangeOrderID is SetExchangeOrderID journaling the event that
+// caused it
+func (o *AtomicOrder) setExchangeOrderID(id string, cause int64) {
+	o.mu.Lock()
+	o.exchangeOrderID = id
+	o.mu.Unlock()
+	o.emit(OrderEvent{Type: EventPlaced, ExchangeOrderID: id, CausationID: cause})
+	o.changed()
+}
+
+// GetExchangeOrderID returns the ID the exchange assigned to the order
+func (o *AtomicOrder) GetExchangeOrderID() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.exchangeOrderID
+}
+
 // GetState returns the current state of the order
 func (o *AtomicOrder) GetState() OrderState {
 	return OrderState(atomic.LoadInt32(&o.state))
@@ -122,60 +181,126 @@ func (o *AtomicOrder) GetState() OrderState {
 
 // SetState atomically updates the order state with validation
 func (o *AtomicOrder) SetState(newState OrderState) bool {
+	return o.transition(newState, 0)
+}
+
+// transition is SetState journaling the event that caused it
+func (o *AtomicOrder) transition(newState OrderState, cause int64) bool {
 	currentState := o.GetState()
 	
 	// Validate state transition
 	if !IsValidTransition(currentState, newState) {
-		o.SetError(fmt.Errorf("invalid state transition from %s to %s", currentState, newState))
+		o.fail(fmt.Errorf("invalid state transition from %s to %s", currentState, newState), cause)
 		return false
 	}
 
-	return atomic.CompareAndSwapInt32(&o.state, int32(currentState), int32(newState))
+	if !atomic.CompareAndSwapInt32(&o.state, int32(currentState), int32(newState)) {
+		return false
+	}
+	o.emit(OrderEvent{Type: EventState, From: currentState, To: newState, CausationID: cause})
+	o.changed()
+	return true
 }
 
-// AddFill atomically adds a fill to the order
+// AddFill atomically adds a fill to the order, moving it to partially
+// filled or filled
 func (o *AtomicOrder) AddFill(fill Fill) error {
-	if o.GetState() != OrderStateActive {
-		return errors.New("cannot add fill: order not active")
+	return o.addFill(fill, 0)
+}
+
+// addFill is AddFill journaling the event that caused it. The state change
+// is journaled as caused by the fill.
+func (o *AtomicOrder) addFill(fill Fill, cause int64) error {
+	if !fill.Quantity.IsPositive() {
+		return errors.New("fill quantity must be positive")
 	}
 
 	o.mu.Lock()
-	defer o.mu.Unlock()
-
 	fills := o.GetFills()
-	totalFilled := 0.0
-	for _, f := range fills {
-		qty, _ := strconv.ParseFloat(f.Quantity, 64)
-		totalFilled += qty
+	newFills := mergeFill(fills, fill)
+	filled := sumQuantity(newFills)
+	grows := filled.GreaterThan(sumQuantity(fills))
+	// A fill replacing an implied one adds nothing, whatever the state
+	if state := o.GetState(); grows && state != OrderStateActive && state != OrderStatePartiallyFilled {
+		o.mu.Unlock()
+		return errors.New("cannot add fill: order not active")
 	}
-
-	newQty, _ := strconv.ParseFloat(fill.Quantity, 64)
-	orderQty, _ := strconv.ParseFloat(o.Quantity, 64)
-
-	if totalFilled + newQty > orderQty {
+	if filled.GreaterThan(o.Quantity) {
+		o.mu.Unlock()
 		return errors.New("fill would exceed order quantity")
 	}
 
-	newFills := append(fills, fill)
 	o.fills.Store(newFills)
+	complete := filled.Equal(o.Quantity)
+	o.mu.Unlock()
+
+	fillEvent := o.emit(OrderEvent{Type: EventFill, Fill: &fill, CausationID: cause})
+	if !grows {
+		return nil
+	}
 
 	// Check if order is completely filled
-	if totalFilled + newQty == orderQty {
-		o.SetState(OrderStateFilled)
+	if complete {
+		o.transition(OrderStateFilled, fillEvent)
+	} else if o.GetState() == OrderStateActive {
+		o.transition(OrderStatePartiallyFilled, fillEvent)
 	}
 
 	return nil
 }
 
+// setQuantity follows an amendment of the order on the exchange
+func (o *AtomicOrder) setQuantity(quantity decimal.Decimal, cause int64) {
+	o.mu.Lock()
+	o.Quantity = quantity
+	o.mu.Unlock()
+	o.emit(OrderEvent{Type: EventAmended, Quantity: &quantity, CausationID: cause})
+}
+
+// emit journals an event of the order through the manager tracking it and
+// returns the event ID, zero if the order is not journaled. It must be
+// called without o.mu held.
+func (o *AtomicOrder) emit(e OrderEvent) int64 {
+	o.mu.RLock()
+	journal := o.journal
+	o.mu.RUnlock()
+	if journal == nil {
+		return 0
+	}
+	e.OrderID = o.ID
+	return journal(e)
+}
+
+// changed tells the subscribers of the manager tracking the order that it
+// changed. It must be called without o.mu held.
+func (o *AtomicOrder) changed() {
+	o.mu.RLock()
+	notify := o.notify
+	o.mu.RUnlock()
+	if notify != nil {
+		notify(o)
+	}
+}
+
 // GetFills returns all fills for the order
 func (o *AtomicOrder) GetFills() []Fill {
 	return o.fills.Load().([]Fill)
 }
 
-// SetError atomically sets an error and updates state
+// SetError atomically sets an error and fails the order, unless it has
+// already reached a state it cannot leave
 func (o *AtomicOrder) SetError(err error) {
+	o.fail(err, 0)
+}
+
+// fail is SetError journaling the event that caused it. The state change
+// is journaled as caused by the error.
+func (o *AtomicOrder) fail(err error, cause int64) {
 	o.error.Store(err)
-	o.SetState(OrderStateFailed)
+	errorEvent := o.emit(OrderEvent{Type: EventError, Error: err.Error(), CausationID: cause})
+	if IsValidTransition(o.GetState(), OrderStateFailed) {
+		o.transition(OrderStateFailed, errorEvent)
+	}
 }
 
 // GetError returns the error associated with the order
@@ -187,15 +312,15 @@ func (o *AtomicOrder) GetError() error {
 }
 
 // Validate performs comprehensive order validation
-func (o *AtomicOrder) Validate(accountBalance float64) error {
+func (o *AtomicOrder) Validate(accountBalance decimal.Decimal) error {
 	orderParams := &validation.Order{
-		Symbol:         o.Symbol,
-		Side:           o.Side,
-		Quantity:       o.Quantity,
-		Price:          o.Price,
-		RiskPercentage: o.RiskPercentage,
-		Leverage:       o.Leverage,
-		AccountBalance: accountBalance,
+		Trade:            o.Trade,
+		Instrument:       o.Instrument,
+		RiskPercentage:   o.RiskPercentage,
+		Leverage:         o.Leverage,
+		AccountBalance:   accountBalance,
+		StopLoss:         o.StopLoss,
+		LiquidationPrice: o.LiquidationPrice,
 	}
 
 	if err := o.validator.ValidateOrder(orderParams); err != nil {
@@ -213,52 +338,115 @@ func (o *AtomicOrder) Validate(accountBalance float64) error {
 
 // IsTerminal returns true if the order is in a terminal state
 func (o *AtomicOrder) IsTerminal() bool {
-	state := o.GetState()
-	return state == OrderStateFilled || state == OrderStateCanceled || state == OrderStateFailed
+	return o.GetState().IsTerminal()
 }
 
 // GetFilledQuantity returns the total filled quantity
-func (o *AtomicOrder) GetFilledQuantity() float64 {
+func (o *AtomicOrder) GetFilledQuantity() decimal.Decimal {
+	return sumQuantity(o.GetFills())
+}
+
+// GetAverageFilledPrice returns the quantity weighted average filled
+// price, rounded half to even to the price precision
+func (o *AtomicOrder) GetAverageFilledPrice() decimal.Decimal {
 	fills := o.GetFills()
-	var total float64
+	totalQuantity, totalValue := decimal.Zero, decimal.Zero
 	for _, fill := range fills {
-		qty, _ := strconv.ParseFloat(fill.Quantity, 64)
-		total += qty
+		totalQuantity = totalQuantity.Add(fill.Quantity)
+		totalValue = totalValue.Add(fill.Quantity.Mul(fill.Price))
 	}
-	return total
+	if totalQuantity.IsZero() {
+		return decimal.Zero
+	}
+	return models.RoundHalfEven.Round(totalValue.Div(totalQuantity), models.PriceDecimals)
 }
 
-// GetAverageFilledPrice returns the average filled price
-func (o *AtomicOrder) GetAverageFilledPrice() float64 {
-	fills := o.GetFills()
-	var totalQuantity, totalValue float64
-	for _, fill := range fills {
-		qty, _ := strconv.ParseFloat(fill.Quantity, 64)
-		price, _ := strconv.ParseFloat(fill.Price, 64)
-		totalQuantity += qty
-		totalValue += qty * price
+// mergeFill returns fills with fill added. A fill from the exchange first
+// replaces the quantity of implied fills, oldest first, so a fill an order
+// update already accounted for is not counted twice.
+func mergeFill(fills []Fill, fill Fill) []Fill {
+	merged := make([]Fill, 0, len(fills)+1)
+	covered := decimal.Zero
+	if !fill.Implied {
+		covered = fill.Quantity
 	}
-	if totalQuantity == 0 {
-		return 0
+	for _, f := range fills {
+		if f.Implied && covered.IsPositive() {
+			take := decimal.Min(f.Quantity, covered)
+			covered = covered.Sub(take)
+			if f.Quantity = f.Quantity.Sub(take); !f.Quantity.IsPositive() {
+				continue
+			}
+		}
+		merged = append(merged, f)
 	}
-	return totalValue / totalQuantity
+	return append(merged, fill)
+}
+
+// sumQuantity returns the total quantity of fills
+func sumQuantity(fills []Fill) decimal.Decimal {
+	total := decimal.Zero
+	for _, fill := range fills {
+		total = total.Add(fill.Quantity)
+	}
+	return total
 }
 
 // OrderStateManager manages the state of multiple orders
 type OrderStateManager struct {
 	orders sync.Map // map[string]*AtomicOrder
+
+	// mu serialises reconciliation of exchange events, see
+	// order_reconcile.go
+	mu          sync.Mutex
+	exchangeIDs map[string]string          // exchange order ID to order ID
+	fillKeys    map[string]map[string]bool // fills applied, by exchange order ID
+	parked      map[string][]parkedEvent   // events for orders not yet placed
+
+	store *OrderStore // nil keeps orders in memory only
+
+	subMu       sync.Mutex
+	subscribers map[string][]chan OrderChange // by order ID, see Subscribe
 }
 
 // NewOrderStateManager creates a new order state manager
 func NewOrderStateManager() *OrderStateManager {
-	return &OrderStateManager{}
+	return &OrderStateManager{
+		exchangeIDs: make(map[string]string),
+		fillKeys:    make(map[string]map[string]bool),
+		parked:      make(map[string][]parkedEvent),
+		subscribers: make(map[string][]chan OrderChange),
+	}
+}
+
+// SetStore journals every order added from now on, and each change to it,
+// so the orders can be recovered after a restart and their history read
+func (m *OrderStateManager) SetStore(store *OrderStore) {
+	m.store = store
 }
 
 // AddOrder adds a new order to the manager
 func (m *OrderStateManager) AddOrder(order *AtomicOrder) {
+	order.mu.Lock()
+	order.notify = m.notify
+	if m.store != nil {
+		order.journal = m.journal
+	}
+	order.mu.Unlock()
 	m.orders.Store(order.ID, order)
 }
 
+// journal appends an event to the store and returns its ID. A failed write
+// is logged rather than failing the change, the exchange remains the record
+// of what happened.
+func (m *OrderStateManager) journal(e OrderEvent) int64 {
+	id, err := m.store.Append(e)
+	if err != nil {
+		log.Printf("Order store: %v", err)
+	}
+	return id
+}
+
 // GetOrder retrieves an order from the manager
 func (m *OrderStateManager) GetOrder(orderID string) (*AtomicOrder, bool) {
 	value, exists := m.orders.Load(orderID)
@@ -289,6 +477,14 @@ func (m *OrderStateManager) UpdateOrderState(orderID string, newState OrderState
 
 // RemoveOrder removes an order from the manager
 func (m *OrderStateManager) RemoveOrder(orderID string) {
+	if order, exists := m.GetOrder(orderID); exists {
+		m.mu.Lock()
+		if exchangeOrderID := order.GetExchangeOrderID(); exchangeOrderID != "" {
+			delete(m.exchangeIDs, exchangeOrderID)
+			delete(m.fillKeys, exchangeOrderID)
+		}
+		m.mu.Unlock()
+	}
 	m.orders.Delete(orderID)
 }
 
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// OrderRecord is the state of an order, as queued or as projected from the
+// order journal
+type OrderRecord struct {
+	ID               string            `json:"id"`
+	ExchangeOrderID  string            `json:"exchange_order_id,omitempty"`
+	Trade            models.Trade      `json:"trade"`
+	Instrument       models.Instrument `json:"instrument"`
+	Leverage         float64           `json:"leverage"`
+	RiskPercentage   float64           `json:"risk_percentage"`
+	StopLoss         decimal.Decimal   `json:"stop_loss"`
+	LiquidationPrice decimal.Decimal   `json:"liquidation_price"`
+	State            OrderState        `json:"state"`
+	Fills            []Fill            `json:"fills,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// record snapshots the order
+func (o *AtomicOrder) record() OrderRecord {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	r := OrderRecord{
+		ID:               o.ID,
+		ExchangeOrderID:  o.exchangeOrderID,
+		Trade:            o.Trade,
+		Instrument:       o.Instrument,
+		Leverage:         o.Leverage,
+		RiskPercentage:   o.RiskPercentage,
+		StopLoss:         o.StopLoss,
+		LiquidationPrice: o.LiquidationPrice,
+		State:            o.GetState(),
+		Fills:            o.GetFills(),
+		CreatedAt:        o.timestamp,
+	}
+	if err := o.GetError(); err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// restore rebuilds the order the record was saved from. Its error is only
+// kept as text.
+func (r OrderRecord) restore() *AtomicOrder {
+	order := &AtomicOrder{
+		Trade:            r.Trade,
+		ID:               r.ID,
+		Leverage:         r.Leverage,
+		RiskPercentage:   r.RiskPercentage,
+		StopLoss:         r.StopLoss,
+		LiquidationPrice: r.LiquidationPrice,
+		Instrument:       r.Instrument,
+		exchangeOrderID:  r.ExchangeOrderID,
+		state:            int32(r.State),
+		timestamp:        r.CreatedAt,
+	}
+	fills := r.Fills
+	if fills == nil {
+		fills = make([]Fill, 0)
+	}
+	order.fills.Store(fills)
+	if r.Error != "" {
+		order.error.Store(errors.New(r.Error))
+	}
+	return order
+}
+
+// RestoreOrder tracks an order projected from the journal as it was last
+// recorded. Its exchange ID and the fills already applied are restored too,
+// so the exchange's events for it are applied once.
+func (m *OrderStateManager) RestoreOrder(record OrderRecord) *AtomicOrder {
+	order := record.restore()
+	m.AddOrder(order)
+
+	if record.ExchangeOrderID != "" {
+		m.mu.Lock()
+		m.exchangeIDs[record.ExchangeOrderID] = order.ID
+		seen := make(map[string]bool)
+		for _, fill := range record.Fills {
+			if fill.ID != "" {
+				seen[fill.ID] = true
+			}
+		}
+		m.fillKeys[record.ExchangeOrderID] = seen
+		m.mu.Unlock()
+	}
+	return order
+}
+
+// OrderStore keeps the order journal, an append-only log of OrderEvents as
+// JSON lines. Projecting the log recovers the orders after a restart, and
+// it keeps each order's history for when something went wrong. A line cut
+// short by a crash is ignored.
+type OrderStore struct {
+	mu     sync.Mutex
+	file   *os.File
+	lastID int64
+}
+
+// OpenOrderStore loads the journal at path, returning its events, and opens
+// it to append more
+func OpenOrderStore(path string) (*OrderStore, []OrderEvent, error) {
+	events, end, err := readOrderEvents(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open order store: %w", err)
+	}
+
+	// Start new events on a line of their own
+	info, err := file.Stat()
+	switch {
+	case err != nil:
+	case info.Size() > end:
+		// Drop the line a crash cut short
+		err = file.Truncate(end)
+	case info.Size() < end:
+		// The last line only lost its newline
+		_, err = file.Write([]byte("\n"))
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to open order store: %w", err)
+	}
+
+	store := &OrderStore{file: file}
+	if len(events) > 0 {
+		store.lastID = events[len(events)-1].ID
+	}
+	return store, events, nil
+}
+
+// Append numbers and timestamps an event and writes it to the journal,
+// returning its ID. The write is synced, so the event survives a crash
+// straight after it.
+func (s *OrderStore) Append(e OrderEvent) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return 0, errors.New("order store closed")
+	}
+
+	e.ID = s.lastID + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s event of order %s: %w", e.Type, e.OrderID, err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return 0, fmt.Errorf("failed to journal order %s: %w", e.OrderID, err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to journal order %s: %w", e.OrderID, err)
+	}
+	s.lastID = e.ID
+	return e.ID, nil
+}
+
+// Close closes the journal. Events appended after it fail.
+func (s *OrderStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// LoadOrderEvents reads the journal at path. A missing journal has no
+// events.
+func LoadOrderEvents(path string) ([]OrderEvent, error) {
+	events, _, err := readOrderEvents(path)
+	return events, err
+}
+
+// readOrderEvents reads the journal at path and returns the offset just
+// past the newline of its last complete event
+func readOrderEvents(path string) ([]OrderEvent, int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open order store: %w", err)
+	}
+	defer file.Close()
+
+	var events []OrderEvent
+	var end int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line, corrupt := 0, 0
+	for scanner.Scan() {
+		line++
+		if corrupt > 0 {
+			// Only the last line can have been cut short by a crash
+			return nil, 0, fmt.Errorf("order store %s is corrupt at line %d", path, corrupt)
+		}
+
+		var e OrderEvent
+		if len(scanner.Bytes()) > 0 {
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID == 0 {
+				corrupt = line
+				continue
+			}
+			events = append(events, e)
+		}
+		end += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read order store: %w", err)
+	}
+	return events, end, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// OrderChange is sent to the subscribers of an order when it changes state
+// or the exchange accepts it
+type OrderChange struct {
+	OrderID         string
+	State           OrderState
+	ExchangeOrderID string
+}
+
+// Subscribe returns a channel receiving the changes of an order, and a
+// function to stop them. The order need not be tracked yet. Only the
+// latest change waits for a slow subscriber, so read the order itself for
+// anything else.
+func (m *OrderStateManager) Subscribe(orderID string) (<-chan OrderChange, func()) {
+	ch := make(chan OrderChange, 1)
+	m.subMu.Lock()
+	m.subscribers[orderID] = append(m.subscribers[orderID], ch)
+	m.subMu.Unlock()
+
+	return ch, func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+		subs := slices.DeleteFunc(m.subscribers[orderID], func(c chan OrderChange) bool { return c == ch })
+		if len(subs) == 0 {
+			delete(m.subscribers, orderID)
+		} else {
+			m.subscribers[orderID] = subs
+		}
+	}
+}
+
+// notify sends a change of the order to its subscribers, replacing any
+// change they have not read yet
+func (m *OrderStateManager) notify(order *AtomicOrder) {
+	change := OrderChange{OrderID: order.ID, State: order.GetState(), ExchangeOrderID: order.GetExchangeOrderID()}
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for _, ch := range m.subscribers[order.ID] {
+		select {
+		case <-ch:
+		default:
+		}
+		// Only notify sends, so there is room now
+		ch <- change
+	}
+}
+
+// WaitForOrder blocks until done reports true for a tracked order, checking
+// it now and on every change. It fails if the order is not tracked or ctx
+// ends first.
+func (m *OrderStateManager) WaitForOrder(ctx context.Context, orderID string, done func(*AtomicOrder) bool) (*AtomicOrder, error) {
+	changes, unsubscribe := m.Subscribe(orderID)
+	defer unsubscribe()
+
+	for {
+		order, exists := m.GetOrder(orderID)
+		if !exists {
+			return nil, errors.New("order not found")
+		}
+		if done(order) {
+			return order, nil
+		}
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			return order, ctx.Err()
+		}
+	}
+}
+
+// WaitForState blocks until an order reaches one of states and returns it.
+// It fails if the order reaches another state it cannot leave, carrying
+// the order's error if it failed, or if ctx ends first.
+func (m *OrderStateManager) WaitForState(ctx context.Context, orderID string, states ...OrderState) (OrderState, error) {
+	order, err := m.WaitForOrder(ctx, orderID, func(o *AtomicOrder) bool {
+		return slices.Contains(states, o.GetState()) || o.IsTerminal()
+	})
+	if err != nil {
+		return OrderStateUnknown, err
+	}
+
+	state := order.GetState()
+	if slices.Contains(states, state) {
+		return state, nil
+	}
+	if err := order.GetError(); err != nil {
+		return state, fmt.Errorf("order %s: %w", state, err)
+	}
+	return state, fmt.Errorf("order %s", state)
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// DefaultOrderPollInterval is how often open orders are polled while the
+// account stream is down
+const DefaultOrderPollInterval = 2 * time.Second
+
+// AccountStream delivers the account's order updates and fills as they
+// happen. *marketdata.Stream implements it.
+type AccountStream interface {
+	AccountUpdates() <-chan marketdata.AccountUpdate
+	AccountLive() (live bool, gaps int)
+}
+
+// OrderSync keeps the orders tracked by an OrderStateManager in step with
+// the exchange. Updates come from the account stream while it is live.
+// Without one, and to catch up on what was missed while it was down, open
+// orders are polled.
+type OrderSync struct {
+	client exchange.Exchange
+	orders *OrderStateManager
+	stream AccountStream
+	onFill func(models.Fill)
+}
+
+// NewOrderSync creates a sync that polls client for the orders in orders
+func NewOrderSync(client exchange.Exchange, orders *OrderStateManager) *OrderSync {
+	return &OrderSync{
+		client: client,
+		orders: orders,
+	}
+}
+
+// SetStream takes updates from an authenticated account stream, polling
+// only while it is down
+func (s *OrderSync) SetStream(stream AccountStream) {
+	s.stream = stream
+}
+
+// SetFillHandler registers a function called with every streamed fill,
+// including fills of orders placed outside the app, e.g. to keep positions
+// current
+func (s *OrderSync) SetFillHandler(onFill func(models.Fill)) {
+	s.onFill = onFill
+}
+
+// Run applies streamed updates as they arrive and polls at interval while
+// the stream is down, until ctx is done
+func (s *OrderSync) Run(ctx context.Context, interval time.Duration) {
+	var updates <-chan marketdata.AccountUpdate
+	if s.stream != nil {
+		updates = s.stream.AccountUpdates()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Poll once the stream is live to pick up what happened before, and
+	// again after every gap
+	syncedGaps := -1
+	for {
+		select {
+		case update := <-updates:
+			if err := s.Apply(update); err != nil {
+				log.Printf("Order sync: %v", err)
+			}
+		case <-ticker.C:
+			live, gaps := false, 0
+			if s.stream != nil {
+				live, gaps = s.stream.AccountLive()
+			}
+			if live && gaps == syncedGaps {
+				continue
+			}
+			if err := s.Poll(); err != nil {
+				log.Printf("Order sync: %v", err)
+				continue
+			}
+			if live {
+				syncedGaps = gaps
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Apply reconciles one streamed account update
+func (s *OrderSync) Apply(update marketdata.AccountUpdate) error {
+	switch update.Channel {
+	case marketdata.ChannelOrders:
+		return s.orders.ApplyOrderUpdate(update.Order)
+	case marketdata.ChannelFills:
+		if s.onFill != nil {
+			s.onFill(update.Fill)
+		}
+		return s.orders.ApplyFill(update.Fill)
+	}
+	return fmt.Errorf("unexpected account channel %q", update.Channel)
+}
+
+// Poll fetches the fills and state of every open order from the exchange.
+// Fills are applied before the order state so the filled quantity comes
+// from the fills themselves.
+func (s *OrderSync) Poll() error {
+	open := make(map[string]*AtomicOrder)
+	symbols := make(map[string]bool)
+	for _, order := range s.orders.GetAllOrders() {
+		exchangeOrderID := order.GetExchangeOrderID()
+		if exchangeOrderID == "" || order.IsTerminal() {
+			continue
+		}
+		open[exchangeOrderID] = order
+		symbols[order.Symbol] = true
+	}
+
+	var errs []error
+	for symbol := range symbols {
+		fills, err := s.client.GetFills(symbol)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get %s fills: %w", symbol, err))
+			continue
+		}
+		for _, fill := range fills {
+			if _, tracked := open[fill.OrderID]; !tracked {
+				continue
+			}
+			if err := s.orders.ApplyFill(fill); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for exchangeOrderID := range open {
+		update, err := s.client.GetOrder(exchangeOrderID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get order %s: %w", exchangeOrderID, err))
+			continue
+		}
+		if err := s.orders.ApplyOrderUpdate(update); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
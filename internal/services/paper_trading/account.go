@@ -0,0 +1,194 @@
+package paper_trading
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+var ErrInsufficientMargin = errors.New("insufficient margin")
+
+// Position is a simulated isolated-margin position
+type Position struct {
+	Symbol     string          `json:"symbol"`
+	Size       decimal.Decimal `json:"size"` // positive for long, negative for short
+	EntryPrice decimal.Decimal `json:"entry_price"`
+	Leverage   float64         `json:"leverage"`
+	MarkPrice  decimal.Decimal `json:"mark_price"`
+}
+
+// Order is a resting simulated order
+type Order struct {
+	ID            string           `json:"id"`
+	ClientOrderID string           `json:"client_order_id,omitempty"`
+	Symbol        string           `json:"symbol"`
+	Side          string           `json:"side"`
+	Type          models.OrderType `json:"type,omitempty"` // empty for limit
+	Quantity      decimal.Decimal  `json:"quantity"`
+	Price         decimal.Decimal  `json:"price"`
+	StopPrice     decimal.Decimal  `json:"stop_price"`
+	Trailing      decimal.Decimal  `json:"trailing_distance,omitempty"` // trailing stops only
+	Triggered     bool             `json:"triggered,omitempty"`
+	ReduceOnly    bool             `json:"reduce_only,omitempty"`
+	OCO           string           `json:"oco,omitempty"`
+}
+
+// Account is the paper trading ledger. It is persisted as JSON between
+// sessions.
+type Account struct {
+	Balance     decimal.Decimal      `json:"balance"`
+	RealizedPnL decimal.Decimal      `json:"realized_pnl"`
+	FeesPaid    decimal.Decimal      `json:"fees_paid"`
+	Positions   map[string]*Position `json:"positions"`
+	Orders      map[string]*Order    `json:"orders"`
+	Fills       []models.Fill        `json:"fills"`
+	NextOrderID int                  `json:"next_order_id"`
+	NextOCOID   int                  `json:"next_oco_id"`
+}
+
+// NewAccount creates an empty account funded with balance
+func NewAccount(balance decimal.Decimal) *Account {
+	return &Account{
+		Balance:   balance,
+		Positions: make(map[string]*Position),
+		Orders:    make(map[string]*Order),
+	}
+}
+
+// LoadAccount reads an account from path. If the file does not exist a new
+// account funded with balance is returned.
+func LoadAccount(path string, balance decimal.Decimal) (*Account, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewAccount(balance), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paper account: %w", err)
+	}
+
+	account := NewAccount(decimal.Zero)
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, fmt.Errorf("failed to parse paper account: %w", err)
+	}
+	return account, nil
+}
+
+// Save atomically writes the account to path
+func (a *Account) Save(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode paper account: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".paper-account-*")
+	if err != nil {
+		return fmt.Errorf("failed to save paper account: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save paper account: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save paper account: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Margin returns the initial margin locked by a position
+func (p *Position) Margin() decimal.Decimal {
+	return p.Size.Abs().Mul(p.EntryPrice).Div(decimal.NewFromFloat(p.Leverage))
+}
+
+// UnrealizedPnL returns the position's profit at the mark price
+func (p *Position) UnrealizedPnL() decimal.Decimal {
+	return p.Size.Mul(p.MarkPrice.Sub(p.EntryPrice))
+}
+
+// LiquidationPrice returns the isolated-margin liquidation price
+func (p *Position) LiquidationPrice(maintenanceMarginRate float64) decimal.Decimal {
+	rate := 1/p.Leverage - maintenanceMarginRate
+	if p.Size.IsPositive() {
+		rate = -rate
+	}
+	return p.EntryPrice.Mul(decimal.NewFromFloat(1 + rate))
+}
+
+// UsedMargin returns the margin locked by positions and resting orders
+func (a *Account) UsedMargin(leverage float64) decimal.Decimal {
+	used := decimal.Zero
+	for _, p := range a.Positions {
+		used = used.Add(p.Margin())
+	}
+	for _, o := range a.Orders {
+		used = used.Add(o.Quantity.Mul(o.Price).Div(decimal.NewFromFloat(leverage)))
+	}
+	return used
+}
+
+// UnrealizedPnL returns the total unrealised profit of all positions
+func (a *Account) UnrealizedPnL() decimal.Decimal {
+	pnl := decimal.Zero
+	for _, p := range a.Positions {
+		pnl = pnl.Add(p.UnrealizedPnL())
+	}
+	return pnl
+}
+
+// Equity returns the balance plus unrealised profit
+func (a *Account) Equity() decimal.Decimal {
+	return a.Balance.Add(a.UnrealizedPnL())
+}
+
+// AvailableMargin returns the equity not locked by positions or orders
+func (a *Account) AvailableMargin(leverage float64) decimal.Decimal {
+	return a.Equity().Sub(a.UsedMargin(leverage))
+}
+
+// applyFill updates the position, balance and fee totals for a fill and
+// records it
+func (a *Account) applyFill(fill models.Fill, leverage float64) {
+	signed := fill.Quantity
+	if fill.Side == "SELL" {
+		signed = signed.Neg()
+	}
+
+	a.Balance = a.Balance.Sub(fill.Fee)
+	a.FeesPaid = a.FeesPaid.Add(fill.Fee)
+
+	p, exists := a.Positions[fill.Symbol]
+	if !exists {
+		p = &Position{Symbol: fill.Symbol, Leverage: leverage}
+		a.Positions[fill.Symbol] = p
+	}
+	p.MarkPrice = fill.Price
+
+	if p.Size.IsZero() || p.Size.Sign() == signed.Sign() {
+		size := p.Size.Abs()
+		p.EntryPrice = size.Mul(p.EntryPrice).Add(fill.Quantity.Mul(fill.Price)).Div(size.Add(fill.Quantity))
+		p.Size = p.Size.Add(signed)
+	} else {
+		closing := decimal.Min(fill.Quantity, p.Size.Abs())
+		direction := decimal.NewFromInt(int64(p.Size.Sign()))
+		pnl := closing.Mul(fill.Price.Sub(p.EntryPrice)).Mul(direction)
+		a.Balance = a.Balance.Add(pnl)
+		a.RealizedPnL = a.RealizedPnL.Add(pnl)
+		p.Size = p.Size.Add(signed)
+		if !p.Size.IsZero() && p.Size.Sign() != direction.Sign() {
+			// Position reversed, the remainder opened at the fill price
+			p.EntryPrice = fill.Price
+			p.Leverage = leverage
+		}
+	}
+
+	if p.Size.IsZero() {
+		delete(a.Positions, fill.Symbol)
+	}
+	a.Fills = append(a.Fills, fill)
+}
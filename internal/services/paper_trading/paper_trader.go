@@ -0,0 +1,722 @@
+// Package paper_trading provides a simulated account that fills orders
+// against real or recorded prices, so the risk workflow can be rehearsed
+// before trading live
+package paper_trading
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services"
+)
+
+var (
+	ErrInvalidOrder  = errors.New("invalid paper order")
+	ErrOrderNotFound = fmt.Errorf("paper %w", exchange.ErrOrderNotFound)
+)
+
+// LiquidationOrderID marks fills generated by a liquidation
+const LiquidationOrderID = "LIQUIDATION"
+
+// PriceSource supplies the markets and prices paper orders fill against.
+// Any exchange.Exchange is a valid source.
+type PriceSource interface {
+	GetMarkets() ([]models.Market, error)
+	GetTicker(symbol string) (models.Ticker, error)
+}
+
+// Config holds the paper trading settings
+type Config struct {
+	AccountFile           string
+	StartingBalance       decimal.Decimal
+	Leverage              float64
+	MakerFeeRate          float64
+	TakerFeeRate          float64
+	MaintenanceMarginRate float64
+}
+
+// DefaultConfig returns typical perpetual swap fees and margin rates
+func DefaultConfig() Config {
+	return Config{
+		AccountFile:           "paper_account.json",
+		StartingBalance:       decimal.NewFromInt(10000),
+		Leverage:              10,
+		MakerFeeRate:          0.0002,
+		TakerFeeRate:          0.0005,
+		MaintenanceMarginRate: 0.005,
+	}
+}
+
+// Summary is a snapshot of the paper account
+type Summary struct {
+	Balance         decimal.Decimal
+	Equity          decimal.Decimal
+	UsedMargin      decimal.Decimal
+	AvailableMargin decimal.Decimal
+	RealizedPnL     decimal.Decimal
+	UnrealizedPnL   decimal.Decimal
+	FeesPaid        decimal.Decimal
+}
+
+// PaperTrader simulates order execution on a persisted paper account. It
+// implements both services.OrderExecutor and exchange.Exchange so it can
+// stand in for a live venue anywhere in the trade flow.
+type PaperTrader struct {
+	mu      sync.Mutex
+	cfg     Config
+	source  PriceSource
+	account *Account
+}
+
+var (
+	_ services.OrderExecutor = (*PaperTrader)(nil)
+	_ exchange.Exchange      = (*PaperTrader)(nil)
+)
+
+// NewPaperTrader loads the paper account from cfg.AccountFile, creating a
+// new one funded with cfg.StartingBalance if none exists
+func NewPaperTrader(source PriceSource, cfg Config) (*PaperTrader, error) {
+	if cfg.Leverage <= 0 {
+		return nil, fmt.Errorf("%w: leverage must be positive", ErrInvalidOrder)
+	}
+
+	account, err := LoadAccount(cfg.AccountFile, cfg.StartingBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaperTrader{
+		cfg:     cfg,
+		source:  source,
+		account: account,
+	}, nil
+}
+
+// PlaceOrder places a simulated order. Marketable orders fill immediately
+// at the touch and pay the taker fee; resting limit orders pay the maker fee
+// once the price crosses them. Stops trigger on the last price. Reduce-only
+// orders only fill against an opposite position, clamped to its size, and
+// rest while there is none. Market, IOC and FOK orders that cannot fill at
+// once expire, and post-only orders that would take liquidity are rejected.
+func (pt *PaperTrader) PlaceOrder(trade models.Trade) (string, error) {
+	if err := trade.Validate(); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidOrder, err)
+	}
+	order := newOrder(trade)
+
+	ticker, err := pt.source.GetTicker(order.Symbol)
+	if err != nil {
+		return "", fmt.Errorf("failed to get price for %s: %w", order.Symbol, err)
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if err := pt.checkMargin(order, ticker.LastPrice); err != nil {
+		return "", err
+	}
+	if trade.PostOnly {
+		if _, _, marketable := matchPrice(order, quoteOf(ticker), true); marketable {
+			return "", fmt.Errorf("%w: post-only order would take liquidity", ErrInvalidOrder)
+		}
+	}
+
+	pt.account.NextOrderID++
+	order.ID = fmt.Sprintf("PAPER-%d", pt.account.NextOrderID)
+	pt.account.Orders[order.ID] = order
+
+	pt.process(order.Symbol, ticker, order.ID)
+
+	// Orders fill in full, so IOC and FOK behave alike
+	if trade.Type == models.OrderTypeMarket || trade.TimeInForce != models.TimeInForceGTC {
+		delete(pt.account.Orders, order.ID)
+	}
+
+	// The order stands even if the account cannot be written, returning
+	// the error would have the caller place it twice
+	if err := pt.save(); err != nil {
+		log.Printf("Paper order %s placed but not saved: %v", order.ID, err)
+	}
+	return order.ID, nil
+}
+
+// LinkOCO links resting paper orders so that once a fill leaves the
+// position flat the rest are cancelled
+func (pt *PaperTrader) LinkOCO(orderIDs ...string) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if len(orderIDs) < 2 {
+		return fmt.Errorf("%w: at least two orders required", ErrInvalidOrder)
+	}
+	orders := make([]*Order, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		o, exists := pt.account.Orders[id]
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+		}
+		if len(orders) > 0 && o.Symbol != orders[0].Symbol {
+			return fmt.Errorf("%w: OCO orders must share a symbol", ErrInvalidOrder)
+		}
+		orders = append(orders, o)
+	}
+
+	pt.account.NextOCOID++
+	group := fmt.Sprintf("OCO-%d", pt.account.NextOCOID)
+	for _, o := range orders {
+		o.OCO = group
+	}
+	return pt.save()
+}
+
+// CancelOrder cancels a resting paper order
+func (pt *PaperTrader) CancelOrder(orderID string) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if _, exists := pt.account.Orders[orderID]; !exists {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, orderID)
+	}
+	delete(pt.account.Orders, orderID)
+	return pt.save()
+}
+
+// ModifyOrder changes the quantity and price of a resting paper order. For
+// untriggered stops price is the stop price.
+func (pt *PaperTrader) ModifyOrder(orderID string, quantity, price decimal.Decimal) error {
+	if !quantity.IsPositive() || !price.IsPositive() {
+		return fmt.Errorf("%w: quantity and price must be positive", ErrInvalidOrder)
+	}
+
+	pt.mu.Lock()
+	order, exists := pt.account.Orders[orderID]
+	pt.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, orderID)
+	}
+
+	ticker, err := pt.source.GetTicker(order.Symbol)
+	if err != nil {
+		return fmt.Errorf("failed to get price for %s: %w", order.Symbol, err)
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if _, exists := pt.account.Orders[orderID]; !exists {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, orderID)
+	}
+
+	modified := *order
+	modified.Quantity = quantity
+	if order.Type.IsStop() && !order.Triggered {
+		modified.StopPrice = price
+	} else {
+		modified.Price = price
+	}
+
+	delete(pt.account.Orders, orderID)
+	if err := pt.checkMargin(&modified, ticker.LastPrice); err != nil {
+		pt.account.Orders[orderID] = order
+		return err
+	}
+	pt.account.Orders[orderID] = &modified
+
+	pt.process(order.Symbol, ticker, orderID)
+	return pt.save()
+}
+
+// AmendOrder implements exchange.Exchange
+func (pt *PaperTrader) AmendOrder(orderID string, quantity, price decimal.Decimal) error {
+	return pt.ModifyOrder(orderID, quantity, price)
+}
+
+// GetBalance returns the paper wallet balance
+func (pt *PaperTrader) GetBalance() (decimal.Decimal, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	return pt.account.Balance, nil
+}
+
+// GetMarkets returns the markets of the price source
+func (pt *PaperTrader) GetMarkets() ([]models.Market, error) {
+	return pt.source.GetMarkets()
+}
+
+// GetInstruments returns the trading rules of the price source, or
+// models.DefaultInstrument for each market if it publishes none
+func (pt *PaperTrader) GetInstruments() ([]models.Instrument, error) {
+	if source, ok := pt.source.(exchange.InstrumentSource); ok {
+		return source.GetInstruments()
+	}
+	markets, err := pt.source.GetMarkets()
+	if err != nil {
+		return nil, err
+	}
+	instruments := make([]models.Instrument, 0, len(markets))
+	for _, m := range markets {
+		instruments = append(instruments, models.DefaultInstrument(m.Symbol))
+	}
+	return instruments, nil
+}
+
+// GetTicker returns the latest prices from the price source
+func (pt *PaperTrader) GetTicker(symbol string) (models.Ticker, error) {
+	return pt.source.GetTicker(symbol)
+}
+
+// GetPositions returns the open paper positions
+func (pt *PaperTrader) GetPositions() ([]models.Position, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	positions := make([]models.Position, 0, len(pt.account.Positions))
+	for _, p := range pt.account.Positions {
+		side := "LONG"
+		if p.Size.IsNegative() {
+			side = "SHORT"
+		}
+		positions = append(positions, models.Position{
+			Symbol:           p.Symbol,
+			Side:             side,
+			Size:             p.Size.Abs(),
+			EntryPrice:       p.EntryPrice,
+			MarkPrice:        p.MarkPrice,
+			Leverage:         p.Leverage,
+			UnrealizedPnL:    p.UnrealizedPnL(),
+			LiquidationPrice: p.LiquidationPrice(pt.cfg.MaintenanceMarginRate),
+		})
+	}
+	return positions, nil
+}
+
+// GetOrder reports the state of a paper order. Paper orders fill in full,
+// so one that no longer rests has filled if it has fills and was cancelled
+// or expired otherwise.
+func (pt *PaperTrader) GetOrder(orderID string) (models.OrderUpdate, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if o, resting := pt.account.Orders[orderID]; resting {
+		return models.OrderUpdate{
+			OrderID:       o.ID,
+			ClientOrderID: o.ClientOrderID,
+			Symbol:        o.Symbol,
+			Status:        models.OrderStatusOpen,
+			Quantity:      o.Quantity,
+			Timestamp:     time.Now(),
+		}, nil
+	}
+
+	seq, err := strconv.Atoi(strings.TrimPrefix(orderID, "PAPER-"))
+	if err != nil || seq < 1 || seq > pt.account.NextOrderID {
+		return models.OrderUpdate{}, fmt.Errorf("%w: %s", ErrOrderNotFound, orderID)
+	}
+
+	update := models.OrderUpdate{OrderID: orderID, Status: models.OrderStatusCanceled, Timestamp: time.Now()}
+	value := decimal.Zero
+	for _, f := range pt.account.Fills {
+		if f.OrderID != orderID {
+			continue
+		}
+		update.Symbol = f.Symbol
+		update.FilledQuantity = update.FilledQuantity.Add(f.Quantity)
+		value = value.Add(f.Quantity.Mul(f.Price))
+		update.Timestamp = f.Timestamp
+	}
+	if update.FilledQuantity.IsPositive() {
+		update.Status = models.OrderStatusFilled
+		update.Quantity = update.FilledQuantity
+		update.AveragePrice = models.RoundHalfEven.Round(value.Div(update.FilledQuantity), models.PriceDecimals)
+	}
+	return update, nil
+}
+
+// GetOrderByClientID reports the state of a resting paper order placed with
+// a client order ID. Paper orders that no longer rest are not found, paper
+// placement cannot fail in transit so they need not be.
+func (pt *PaperTrader) GetOrderByClientID(clientOrderID string) (models.OrderUpdate, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	for _, o := range pt.account.Orders {
+		if clientOrderID != "" && o.ClientOrderID == clientOrderID {
+			return models.OrderUpdate{
+				OrderID:       o.ID,
+				ClientOrderID: o.ClientOrderID,
+				Symbol:        o.Symbol,
+				Status:        models.OrderStatusOpen,
+				Quantity:      o.Quantity,
+				Timestamp:     time.Now(),
+			}, nil
+		}
+	}
+	return models.OrderUpdate{}, fmt.Errorf("%w: client order ID %s", ErrOrderNotFound, clientOrderID)
+}
+
+// FindOrder looks up a resting paper order by client order ID
+func (pt *PaperTrader) FindOrder(clientOrderID string) (models.OrderUpdate, error) {
+	return pt.GetOrderByClientID(clientOrderID)
+}
+
+// GetOpenOrders returns the resting paper orders in placement order,
+// optionally filtered by symbol
+func (pt *PaperTrader) GetOpenOrders(symbol string) ([]models.OrderUpdate, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	var resting []*Order
+	for _, o := range pt.account.Orders {
+		if symbol == "" || o.Symbol == symbol {
+			resting = append(resting, o)
+		}
+	}
+	sortOrders(resting)
+
+	orders := make([]models.OrderUpdate, 0, len(resting))
+	for _, o := range resting {
+		orders = append(orders, models.OrderUpdate{
+			OrderID:       o.ID,
+			ClientOrderID: o.ClientOrderID,
+			Symbol:        o.Symbol,
+			Status:        models.OrderStatusOpen,
+			Quantity:      o.Quantity,
+			Timestamp:     time.Now(),
+		})
+	}
+	return orders, nil
+}
+
+// GetFills returns paper fills, optionally filtered by symbol
+func (pt *PaperTrader) GetFills(symbol string) ([]models.Fill, error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	var fills []models.Fill
+	for _, f := range pt.account.Fills {
+		if symbol == "" || f.Symbol == symbol {
+			fills = append(fills, f)
+		}
+	}
+	return fills, nil
+}
+
+// Summary returns a snapshot of the paper account
+func (pt *PaperTrader) Summary() Summary {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	return Summary{
+		Balance:         pt.account.Balance,
+		Equity:          pt.account.Equity(),
+		UsedMargin:      pt.account.UsedMargin(pt.cfg.Leverage),
+		AvailableMargin: pt.account.AvailableMargin(pt.cfg.Leverage),
+		RealizedPnL:     pt.account.RealizedPnL,
+		UnrealizedPnL:   pt.account.UnrealizedPnL(),
+		FeesPaid:        pt.account.FeesPaid,
+	}
+}
+
+// Update fetches prices for every symbol with a position or resting order,
+// fills crossed orders, marks positions to market and liquidates those past
+// their liquidation price
+func (pt *PaperTrader) Update() error {
+	pt.mu.Lock()
+	symbols := make(map[string]bool)
+	for symbol := range pt.account.Positions {
+		symbols[symbol] = true
+	}
+	for _, o := range pt.account.Orders {
+		symbols[o.Symbol] = true
+	}
+	pt.mu.Unlock()
+
+	var errs []error
+	for symbol := range symbols {
+		ticker, err := pt.source.GetTicker(symbol)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get price for %s: %w", symbol, err))
+			continue
+		}
+
+		pt.mu.Lock()
+		pt.process(symbol, ticker, "")
+		pt.mu.Unlock()
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if err := pt.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Run calls Update at the given interval until ctx is done
+func (pt *PaperTrader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := pt.Update(); err != nil {
+				log.Printf("Paper trading update failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process fills crossed orders for a symbol and marks its position. placed
+// is the order just placed or modified, if any: only it takes liquidity at
+// the touch, orders that were already resting fill at their limit as makers.
+// The caller must hold pt.mu.
+func (pt *PaperTrader) process(symbol string, ticker models.Ticker, placed string) {
+	q := quoteOf(ticker)
+
+	for _, o := range pt.ordersFor(symbol) {
+		if _, resting := pt.account.Orders[o.ID]; !resting {
+			// Cancelled by an earlier OCO fill
+			continue
+		}
+
+		price, taker, ok := matchPrice(o, q, o.ID == placed)
+		if !ok {
+			continue
+		}
+
+		quantity := o.Quantity
+		if o.ReduceOnly {
+			quantity = decimal.Min(quantity, pt.reducible(o))
+			if !quantity.IsPositive() {
+				continue
+			}
+		}
+
+		feeRate := decimal.NewFromFloat(pt.cfg.MakerFeeRate)
+		if taker {
+			feeRate = decimal.NewFromFloat(pt.cfg.TakerFeeRate)
+		}
+
+		delete(pt.account.Orders, o.ID)
+		pt.account.applyFill(models.Fill{
+			OrderID:   o.ID,
+			Symbol:    o.Symbol,
+			Side:      o.Side,
+			Quantity:  quantity,
+			Price:     price,
+			Fee:       quantity.Mul(price).Mul(feeRate),
+			Timestamp: time.Now(),
+		}, pt.cfg.Leverage)
+
+		if _, open := pt.account.Positions[symbol]; !open {
+			pt.cancelOCO(o)
+		}
+	}
+
+	p, exists := pt.account.Positions[symbol]
+	if !exists {
+		return
+	}
+
+	mark := ticker.MarkPrice
+	if mark.IsZero() {
+		mark = q.last
+	}
+	p.MarkPrice = mark
+
+	liquidation := p.LiquidationPrice(pt.cfg.MaintenanceMarginRate)
+	if (p.Size.IsPositive() && mark.LessThanOrEqual(liquidation)) || (p.Size.IsNegative() && mark.GreaterThanOrEqual(liquidation)) {
+		side := "SELL"
+		if p.Size.IsNegative() {
+			side = "BUY"
+		}
+		// The remaining maintenance margin is forfeited as the liquidation fee
+		pt.account.applyFill(models.Fill{
+			OrderID:   LiquidationOrderID,
+			Symbol:    symbol,
+			Side:      side,
+			Quantity:  p.Size.Abs(),
+			Price:     liquidation,
+			Fee:       p.Size.Abs().Mul(liquidation).Mul(decimal.NewFromFloat(pt.cfg.MaintenanceMarginRate)),
+			Timestamp: time.Now(),
+		}, pt.cfg.Leverage)
+	}
+}
+
+// cancelOCO cancels the resting orders linked to a filled order. The
+// caller must hold pt.mu.
+func (pt *PaperTrader) cancelOCO(filled *Order) {
+	if filled.OCO == "" {
+		return
+	}
+	for id, o := range pt.account.Orders {
+		if o.OCO == filled.OCO {
+			delete(pt.account.Orders, id)
+		}
+	}
+}
+
+// reducible returns how much of the open position a reduce-only order may
+// close. The caller must hold pt.mu.
+func (pt *PaperTrader) reducible(order *Order) decimal.Decimal {
+	p, exists := pt.account.Positions[order.Symbol]
+	if !exists || (p.Size.IsPositive() && order.Side == "BUY") || (p.Size.IsNegative() && order.Side == "SELL") {
+		return decimal.Zero
+	}
+	return p.Size.Abs()
+}
+
+// checkMargin ensures the account can fund the exposure an order would
+// add. Orders that only reduce a position need no margin. The caller must
+// hold pt.mu.
+func (pt *PaperTrader) checkMargin(order *Order, last decimal.Decimal) error {
+	if order.ReduceOnly {
+		return nil
+	}
+	price := order.Price
+	if order.Type.IsStop() {
+		price = order.StopPrice
+	} else if order.Type == models.OrderTypeMarket {
+		price = last
+	}
+	opening := order.Quantity
+	if p, exists := pt.account.Positions[order.Symbol]; exists {
+		if (p.Size.IsPositive() && order.Side == "SELL") || (p.Size.IsNegative() && order.Side == "BUY") {
+			opening = decimal.Max(decimal.Zero, order.Quantity.Sub(p.Size.Abs()))
+		}
+	}
+
+	required := opening.Mul(price).Mul(decimal.NewFromFloat(1/pt.cfg.Leverage + pt.cfg.TakerFeeRate))
+	if available := pt.account.AvailableMargin(pt.cfg.Leverage); required.GreaterThan(available) {
+		return fmt.Errorf("%w: order requires %s, available %s", ErrInsufficientMargin, required.StringFixed(2), available.StringFixed(2))
+	}
+	return nil
+}
+
+// ordersFor returns the resting orders for a symbol in placement order. The
+// caller must hold pt.mu.
+func (pt *PaperTrader) ordersFor(symbol string) []*Order {
+	var orders []*Order
+	for _, o := range pt.account.Orders {
+		if o.Symbol == symbol {
+			orders = append(orders, o)
+		}
+	}
+	sortOrders(orders)
+	return orders
+}
+
+// save persists the account. The caller must hold pt.mu.
+func (pt *PaperTrader) save() error {
+	if pt.cfg.AccountFile == "" {
+		return nil
+	}
+	return pt.account.Save(pt.cfg.AccountFile)
+}
+
+func newOrder(trade models.Trade) *Order {
+	return &Order{
+		ClientOrderID: trade.ClientOrderID,
+		Symbol:        trade.Symbol,
+		Side:          string(trade.Side),
+		Type:          trade.Type,
+		Quantity:      trade.Quantity,
+		Price:         trade.Price,
+		StopPrice:     trade.StopPrice,
+		Trailing:      trade.TrailingDistance,
+		ReduceOnly:    trade.ReduceOnly,
+	}
+}
+
+// quote is the top of book an order matches against
+type quote struct {
+	bid, ask, last decimal.Decimal
+}
+
+func quoteOf(ticker models.Ticker) quote {
+	q := quote{
+		bid:  ticker.BidPrice,
+		ask:  ticker.AskPrice,
+		last: ticker.LastPrice,
+	}
+	if q.bid.IsZero() || q.ask.IsZero() {
+		q.bid, q.ask = q.last, q.last
+	}
+	if q.last.IsZero() {
+		q.last = decimal.Avg(q.bid, q.ask)
+	}
+	return q
+}
+
+// matchPrice reports whether an order is executable against q, at what
+// price and whether it takes liquidity. A stop whose stop price has traded
+// is marked triggered; a triggered stop-limit then matches as a limit order.
+// A trailing stop first moves its stop price after a favourable last price.
+func matchPrice(o *Order, q quote, onPlacement bool) (price decimal.Decimal, taker, ok bool) {
+	switch o.Type {
+	case models.OrderTypeMarket:
+		return touch(o.Side, q.bid, q.ask), true, true
+	case models.OrderTypeStopMarket, models.OrderTypeStopLimit, models.OrderTypeTrailingStop:
+		if o.Type == models.OrderTypeTrailingStop {
+			if o.Side == "SELL" {
+				o.StopPrice = decimal.Max(o.StopPrice, q.last.Sub(o.Trailing))
+			} else {
+				o.StopPrice = decimal.Min(o.StopPrice, q.last.Add(o.Trailing))
+			}
+		}
+		if !o.Triggered {
+			if (o.Side == "BUY" && q.last.LessThan(o.StopPrice)) || (o.Side == "SELL" && q.last.GreaterThan(o.StopPrice)) {
+				return decimal.Zero, false, false
+			}
+			o.Triggered = true
+			onPlacement = true
+		}
+		if o.Type != models.OrderTypeStopLimit {
+			return touch(o.Side, q.bid, q.ask), true, true
+		}
+	}
+
+	switch {
+	case o.Side == "BUY" && q.ask.LessThanOrEqual(o.Price):
+		if onPlacement {
+			return q.ask, true, true
+		}
+		return o.Price, false, true
+	case o.Side == "SELL" && q.bid.GreaterThanOrEqual(o.Price):
+		if onPlacement {
+			return q.bid, true, true
+		}
+		return o.Price, false, true
+	}
+	return decimal.Zero, false, false
+}
+
+// touch returns the price a marketable order on side fills at
+func touch(side string, bid, ask decimal.Decimal) decimal.Decimal {
+	if side == "BUY" {
+		return ask
+	}
+	return bid
+}
+
+func sortOrders(orders []*Order) {
+	seq := func(id string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(id, "PAPER-"))
+		return n
+	}
+	sort.Slice(orders, func(i, j int) bool { return seq(orders[i].ID) < seq(orders[j].ID) })
+}
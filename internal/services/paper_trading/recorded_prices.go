@@ -0,0 +1,100 @@
+package paper_trading
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// RecordedPrices is a PriceSource replaying prices from a CSV file of
+// symbol,price rows. Each GetTicker call advances the symbol to its next
+// recorded price, holding the last one once the recording is exhausted.
+type RecordedPrices struct {
+	mu     sync.Mutex
+	prices map[string][]decimal.Decimal
+	pos    map[string]int
+}
+
+// LoadRecordedPrices reads a price recording. A header row is skipped.
+func LoadRecordedPrices(path string) (*RecordedPrices, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price recording: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price recording: %w", err)
+	}
+
+	rp := &RecordedPrices{
+		prices: make(map[string][]decimal.Decimal),
+		pos:    make(map[string]int),
+	}
+	for i, row := range rows {
+		price, err := decimal.NewFromString(row[1])
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("invalid price on line %d: %q", i+1, row[1])
+		}
+		rp.prices[row[0]] = append(rp.prices[row[0]], price)
+	}
+
+	if len(rp.prices) == 0 {
+		return nil, fmt.Errorf("price recording %s is empty", path)
+	}
+	return rp, nil
+}
+
+// GetMarkets returns a market for every recorded symbol
+func (rp *RecordedPrices) GetMarkets() ([]models.Market, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	markets := make([]models.Market, 0, len(rp.prices))
+	for symbol := range rp.prices {
+		base, quote, _ := strings.Cut(symbol, "/")
+		markets = append(markets, models.Market{Symbol: symbol, Base: base, Quote: quote})
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Symbol < markets[j].Symbol })
+	return markets, nil
+}
+
+// GetTicker returns the next recorded price for symbol
+func (rp *RecordedPrices) GetTicker(symbol string) (models.Ticker, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	prices, exists := rp.prices[symbol]
+	if !exists {
+		return models.Ticker{}, fmt.Errorf("no recorded prices for %s", symbol)
+	}
+
+	pos := rp.pos[symbol]
+	price := prices[pos]
+	if pos < len(prices)-1 {
+		rp.pos[symbol] = pos + 1
+	}
+
+	return models.Ticker{
+		Symbol:    symbol,
+		LastPrice: price,
+		MarkPrice: price,
+		BidPrice:  price,
+		AskPrice:  price,
+		Timestamp: time.Now(),
+	}, nil
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
+)
+
+// ErrNoPosition means an action named a symbol with no open position
+var ErrNoPosition = errors.New("no open position")
+
+// PositionActionKind is what to do with an open position
+type PositionActionKind string
+
+const (
+	// PositionClose closes the whole position at market
+	PositionClose PositionActionKind = "close"
+	// PositionReduce closes a fraction of the position at market
+	PositionReduce PositionActionKind = "reduce"
+	// PositionFlip closes the position at market and opens one of the same
+	// size on the other side. The new position has no stop loss until it is
+	// moved to breakeven or trailed.
+	PositionFlip PositionActionKind = "flip"
+	// PositionBreakeven moves the stop loss to the entry price plus fees
+	PositionBreakeven PositionActionKind = "be"
+	// PositionTrail makes the stop loss trail the price
+	PositionTrail PositionActionKind = "trail"
+)
+
+// MovesStop reports whether the action changes the stop loss rather than
+// the position
+func (k PositionActionKind) MovesStop() bool {
+	return k == PositionBreakeven || k == PositionTrail
+}
+
+// PositionAction is a change to one open position
+type PositionAction struct {
+	Kind   PositionActionKind
+	Symbol string
+	// Fraction is the share of the position a reduce closes, in (0, 1]
+	Fraction decimal.Decimal
+	// Trail is how a trailing stop follows the price
+	Trail risk_calculator.TrailingStop
+}
+
+// PositionActionResult reports the orders placed for an action
+type PositionActionResult struct {
+	Action PositionAction
+	// Side and Quantity are those of the closing order. A flip opens the
+	// same quantity on the opposite side of the original position.
+	Side     models.OrderSide
+	Quantity decimal.Decimal
+	OrderIDs []string
+	// Stop is the stop loss after a breakeven or trail action
+	Stop ProtectiveStop
+	Err  error
+}
+
+// PositionActionExecutor closes, reduces and flips open positions with
+// market orders. The closing orders are reduce-only so they can never open
+// a position, e.g. if the position closed in the meantime. Once a position
+// is closed its stop loss and take profits are cancelled. Stop moves are
+// handed to the stop manager.
+type PositionActionExecutor struct {
+	orderService   OrderServicer
+	positions      *PositionService
+	riskPercentage float64
+	commandQueue   *CommandQueue
+	sharedQueue    bool // commandQueue runs on its own, see SetCommandQueue
+	stops          *StopManager
+}
+
+// NewPositionActionExecutor creates an executor acting on the positions
+// tracked by positions. riskPercentage is only used to validate the opening
+// order of a flip.
+func NewPositionActionExecutor(orderService OrderServicer, positions *PositionService, riskPercentage float64) *PositionActionExecutor {
+	return &PositionActionExecutor{
+		orderService:   orderService,
+		positions:      positions,
+		riskPercentage: riskPercentage,
+		commandQueue:   NewCommandQueue(100, nil),
+	}
+}
+
+// SetCommandQueue queues the orders on a shared queue already started, so
+// they take their turn with every other command. The queue's state manager
+// and retry policies apply.
+func (e *PositionActionExecutor) SetCommandQueue(q *CommandQueue) {
+	e.commandQueue = q
+	e.sharedQueue = true
+}
+
+// SetStopManager moves stop losses for breakeven and trail actions, and
+// drops the stops of closed positions
+func (e *PositionActionExecutor) SetStopManager(stops *StopManager) {
+	e.stops = stops
+}
+
+// pendingAction is an action whose closing order has been queued
+type pendingAction struct {
+	result   PositionActionResult
+	position PositionSnapshot
+	inst     models.Instrument
+	orderID  string
+	done     bool // nothing left to wait for
+}
+
+// Execute carries out actions against the latest positions. All closing
+// orders are queued before any is waited on, so closing everything takes
+// one round of the queue, in parallel across symbols. Results are returned in the order of actions.
+func (e *PositionActionExecutor) Execute(actions ...PositionAction) []PositionActionResult {
+	if !e.sharedQueue {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.commandQueue.Start(ctx, e.orderService)
+		defer func() {
+			cancel()
+			e.commandQueue.Stop()
+		}()
+	}
+
+	portfolio := e.positions.Snapshot()
+	pending := make([]pendingAction, len(actions))
+	for i, action := range actions {
+		pending[i] = pendingAction{result: PositionActionResult{Action: action}}
+		if action.Kind.MovesStop() {
+			pending[i].result.Err = e.moveStop(&pending[i].result)
+			pending[i].done = true
+			continue
+		}
+		pending[i].result.Err = e.queueClose(&pending[i], portfolio)
+	}
+
+	results := make([]PositionActionResult, len(pending))
+	for i := range pending {
+		p := &pending[i]
+		if p.result.Err == nil && !p.done {
+			p.result.Err = e.finish(p, portfolio.Balance)
+		}
+		results[i] = p.result
+	}
+	return results
+}
+
+// queueClose sizes the closing order of an action and queues it
+func (e *PositionActionExecutor) queueClose(p *pendingAction, portfolio PortfolioSnapshot) error {
+	action := p.result.Action
+	position, found := findPosition(portfolio, action.Symbol)
+	if !found {
+		return fmt.Errorf("%w in %s", ErrNoPosition, action.Symbol)
+	}
+	p.position = position
+
+	inst, err := e.orderService.GetInstrument(action.Symbol)
+	if err != nil {
+		return fmt.Errorf("failed to get instrument: %w", err)
+	}
+	p.inst = inst
+
+	size := position.Size.Abs()
+	quantity := size
+	if action.Kind == PositionReduce {
+		if !action.Fraction.IsPositive() || action.Fraction.GreaterThan(decimal.NewFromInt(1)) {
+			return fmt.Errorf("reduce fraction must be in (0, 1], got %s", action.Fraction)
+		}
+		quantity = inst.RoundQuantity(size.Mul(action.Fraction))
+		if !quantity.IsPositive() {
+			return fmt.Errorf("reducing %s %s by %s%% is less than the step size %s", size, action.Symbol, action.Fraction.Shift(2), inst.StepSize)
+		}
+	}
+
+	p.result.Side = models.SideSell
+	if !position.IsLong() {
+		p.result.Side = models.SideBuy
+	}
+	p.result.Quantity = quantity
+
+	trade := models.NewMarketTrade(action.Symbol, p.result.Side, quantity)
+	trade.ReduceOnly = true
+	p.orderID, err = e.enqueue(trade, p, portfolio.Balance)
+	return err
+}
+
+// moveStop moves the stop loss of a position to breakeven or makes it trail
+func (e *PositionActionExecutor) moveStop(result *PositionActionResult) error {
+	if e.stops == nil {
+		return errors.New("stop management is not available")
+	}
+	var stop ProtectiveStop
+	var err error
+	if result.Action.Kind == PositionBreakeven {
+		stop, err = e.stops.MoveToBreakeven(result.Action.Symbol)
+	} else {
+		stop, err = e.stops.Trail(result.Action.Symbol, result.Action.Trail)
+	}
+	if err != nil {
+		return err
+	}
+	result.Side, result.Quantity, result.Stop = stop.Side, stop.Quantity, stop
+	result.OrderIDs = []string{stop.OrderID}
+	return nil
+}
+
+// finish waits for the closing order, cancels the exit orders of a closed
+// position and places the opening order of a flip
+func (e *PositionActionExecutor) finish(p *pendingAction, balance decimal.Decimal) error {
+	if err := e.wait(p.orderID, &p.result); err != nil {
+		return fmt.Errorf("closing order failed: %w", err)
+	}
+	if p.result.Action.Kind == PositionReduce {
+		return nil
+	}
+	exitErr := e.cancelExits(p.result.Action.Symbol)
+	if p.result.Action.Kind != PositionFlip {
+		if exitErr != nil {
+			return fmt.Errorf("position closed, cancelling its exit orders failed: %w", exitErr)
+		}
+		return nil
+	}
+
+	// Open only once the close is accepted, so a failed close cannot double
+	// the position instead of reversing it
+	trade := models.NewMarketTrade(p.result.Action.Symbol, p.result.Side, p.result.Quantity)
+	orderID, err := e.enqueue(trade, p, balance)
+	if err == nil {
+		err = e.wait(orderID, &p.result)
+	}
+	if err != nil {
+		return fmt.Errorf("position closed, opening the reverse failed: %w", err)
+	}
+	if e.stops != nil {
+		// Without a stop yet, be and trail place the first
+		opened := TradeResult{Symbol: p.result.Action.Symbol, Side: string(p.result.Side), Quantity: p.result.Quantity}
+		if order, exists := e.commandQueue.GetOrder(orderID); exists {
+			opened.EntryPrice = order.GetAverageFilledPrice()
+		}
+		e.stops.Track(opened)
+	}
+	if exitErr != nil {
+		// Both are reduce-only, the old exits cannot add to the new position
+		return fmt.Errorf("position flipped, cancelling the old exit orders failed: %w", exitErr)
+	}
+	return nil
+}
+
+// cancelExits cancels the stop loss and take profits of the closed position
+// on symbol, i.e. the reduce-only orders resting there, and has the stop
+// manager forget its stop. The stop is forgotten first so that a stop placed
+// by a move in progress is cancelled too.
+func (e *PositionActionExecutor) cancelExits(symbol string) error {
+	if e.stops != nil {
+		e.stops.Forget(symbol)
+	}
+	var errs []error
+	for _, o := range e.commandQueue.stateManager.GetAllOrders() {
+		if o.Symbol != symbol || !o.ReduceOnly || o.Type == models.OrderTypeMarket || o.IsTerminal() {
+			continue
+		}
+		if err := cancelQueued(e.commandQueue, o.ID); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", o.Type, o.GetExchangeOrderID(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// enqueue queues a market order for the position of p and returns its
+// tracking ID
+func (e *PositionActionExecutor) enqueue(trade models.Trade, p *pendingAction, balance decimal.Decimal) (string, error) {
+	leverage := p.position.Leverage
+	if leverage < 1 {
+		leverage = 1
+	}
+	cmd := OrderCommand{
+		Type:           CommandPlaceOrder,
+		Trade:          trade,
+		Instrument:     p.inst,
+		OrderID:        generateOrderID(),
+		Timestamp:      time.Now(),
+		Leverage:       leverage,
+		RiskPercentage: e.riskPercentage,
+		AccountBalance: balance,
+	}
+	if err := e.commandQueue.Enqueue(cmd); err != nil {
+		return "", err
+	}
+	return cmd.OrderID, nil
+}
+
+// wait waits for a queued order to be accepted and records its exchange ID
+func (e *PositionActionExecutor) wait(orderID string, result *PositionActionResult) error {
+	if _, err := waitForAccepted(e.commandQueue, orderID); err != nil {
+		return err
+	}
+	if order, exists := e.commandQueue.GetOrder(orderID); exists {
+		result.OrderIDs = append(result.OrderIDs, order.GetExchangeOrderID())
+	}
+	return nil
+}
+
+func findPosition(portfolio PortfolioSnapshot, symbol string) (PositionSnapshot, bool) {
+	for _, p := range portfolio.Positions {
+		if p.Symbol == symbol && !p.Size.IsZero() {
+			return p, true
+		}
+	}
+	return PositionSnapshot{}, false
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
+)
+
+func TestCloseCancelsTheExitOrders(t *testing.T) {
+	m := NewOrderStateManager()
+	m.RestoreOrder(stopRecord("ORD-S", "EX-S"))
+	tp := models.NewLimitTrade("BTC/USDT", models.SideSell, dec("1"), dec("120"))
+	tp.ReduceOnly = true
+	m.RestoreOrder(OrderRecord{ID: "ORD-TP", ExchangeOrderID: "EX-TP", Trade: tp, State: OrderStateActive})
+	executor := &fakeExecutor{}
+	q := startQueue(t, m, executor)
+
+	positions := NewPositionService(nil, risk_calculator.NewRiskCalculator())
+	positions.ApplyFill(models.Fill{Symbol: "BTC/USDT", Side: string(models.SideBuy), Quantity: dec("1"), Price: dec("100")})
+	stops := NewStopManager(fakeOrderService{}, positions, q, StopConfig{})
+	stops.Restore()
+
+	actions := NewPositionActionExecutor(fakeOrderService{}, positions, 1)
+	actions.SetCommandQueue(q)
+	actions.SetStopManager(stops)
+	results := actions.Execute(PositionAction{Kind: PositionClose, Symbol: "BTC/USDT"})
+	if err := results[0].Err; err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	executor.mu.Lock()
+	cancelled := map[string]bool{}
+	for _, id := range executor.cancelled {
+		cancelled[id] = true
+	}
+	executor.mu.Unlock()
+	if len(cancelled) != 2 || !cancelled["EX-S"] || !cancelled["EX-TP"] {
+		t.Errorf("cancelled %v, want the stop EX-S and take profit EX-TP", cancelled)
+	}
+	for _, id := range []string{"ORD-S", "ORD-TP"} {
+		if order, _ := m.GetOrder(id); order.GetState() != OrderStateCanceled {
+			t.Errorf("%s state = %s, want Canceled", id, order.GetState())
+		}
+	}
+	if _, err := stops.MoveToBreakeven("BTC/USDT"); !errors.Is(err, errStopUnknown) {
+		t.Errorf("MoveToBreakeven error = %v, want the stop of the closed position forgotten", err)
+	}
+}
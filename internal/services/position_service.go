@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/exchange"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
+)
+
+// DefaultPositionRefreshInterval is how often positions and the balance are
+// reloaded from the exchange. Fills and mark prices keep them current in
+// between.
+const DefaultPositionRefreshInterval = 5 * time.Second
+
+// PositionSnapshot is an open position valued at the latest mark price
+type PositionSnapshot struct {
+	Symbol     string
+	Size       decimal.Decimal // negative for shorts
+	EntryPrice decimal.Decimal
+	MarkPrice  decimal.Decimal
+	Leverage   float64
+
+	// Margin is the initial margin at entry and ROE the unrealised PnL as
+	// a percentage of it
+	Margin        decimal.Decimal
+	UnrealizedPnL decimal.Decimal
+	ROE           float64
+
+	// LiquidationDistance is how far the mark price may move against the
+	// position before liquidation, as a percentage of the mark. Both are
+	// zero for a position that cannot be liquidated.
+	LiquidationPrice    decimal.Decimal
+	LiquidationDistance float64
+
+	FundingRate float64
+
+	// Stop is the stop loss managed for the position, zero if there is none
+	Stop ProtectiveStop
+}
+
+// IsLong reports whether the position profits from a rising price
+func (p PositionSnapshot) IsLong() bool {
+	return p.Size.IsPositive()
+}
+
+// PortfolioSnapshot is the account and its open positions at one moment
+type PortfolioSnapshot struct {
+	Balance       decimal.Decimal
+	Equity        decimal.Decimal // balance plus unrealised PnL
+	UnrealizedPnL decimal.Decimal
+	Positions     []PositionSnapshot // sorted by symbol
+	UpdatedAt     time.Time
+}
+
+// trackedPosition is the state kept per symbol between snapshots
+type trackedPosition struct {
+	size        decimal.Decimal
+	entry       decimal.Decimal
+	leverage    float64
+	liquidation decimal.Decimal // as reported by the exchange, zero if not
+}
+
+// PositionService tracks the open positions of the account. It loads them
+// from the exchange, applies fills and mark prices as they arrive and
+// publishes a snapshot after every change.
+type PositionService struct {
+	client         exchange.Exchange
+	riskCalculator risk_calculator.RiskCalculatorService
+	instruments    *exchange.InstrumentCache
+	marginMode     risk_calculator.MarginMode
+	tiers          []risk_calculator.MaintenanceTier
+	stops          *StopManager
+
+	mu        sync.Mutex
+	balance   decimal.Decimal
+	positions map[string]*trackedPosition
+	marks     map[string]decimal.Decimal
+	funding   map[string]float64
+	leverage  map[string]float64 // leverage trades were opened with
+	fills     int                // counts fills applied, see Refresh
+
+	publishMu sync.Mutex // keeps snapshots in order
+	snapshots chan PortfolioSnapshot
+}
+
+// NewPositionService creates a service tracking the positions on client
+func NewPositionService(client exchange.Exchange, riskCalculator risk_calculator.RiskCalculatorService) *PositionService {
+	return &PositionService{
+		client:         client,
+		riskCalculator: riskCalculator,
+		positions:      make(map[string]*trackedPosition),
+		marks:          make(map[string]decimal.Decimal),
+		funding:        make(map[string]float64),
+		leverage:       make(map[string]float64),
+		snapshots:      make(chan PortfolioSnapshot, 1),
+	}
+}
+
+// SetInstrumentCache sets where contract multipliers and ticks come from
+func (s *PositionService) SetInstrumentCache(instruments *exchange.InstrumentCache) {
+	s.instruments = instruments
+}
+
+// SetMarginConfig sets the margin mode and maintenance tiers used for
+// liquidation prices the exchange does not report
+func (s *PositionService) SetMarginConfig(mode risk_calculator.MarginMode, tiers []risk_calculator.MaintenanceTier) {
+	s.marginMode = mode
+	s.tiers = tiers
+}
+
+// SetStopManager shows the stops managed by stops in snapshots
+func (s *PositionService) SetStopManager(stops *StopManager) {
+	s.stops = stops
+}
+
+// SetLeverage records the leverage a position on symbol was opened with,
+// for exchanges that do not report it
+func (s *PositionService) SetLeverage(symbol string, leverage float64) {
+	s.mu.Lock()
+	s.leverage[symbol] = leverage
+	if p, exists := s.positions[symbol]; exists && p.leverage == 0 {
+		p.leverage = leverage
+	}
+	s.mu.Unlock()
+	s.publish()
+}
+
+// Snapshots returns the channel snapshots are published on. Only the latest
+// is kept for a slow reader.
+func (s *PositionService) Snapshots() <-chan PortfolioSnapshot {
+	return s.snapshots
+}
+
+// Run refreshes from the exchange straight away and then at interval until
+// ctx is done
+func (s *PositionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Refresh(); err != nil {
+			log.Printf("Position refresh failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh reloads the balance and positions from the exchange. A result
+// that a fill applied in the meantime has made stale is discarded, the
+// next refresh picks the fill up.
+func (s *PositionService) Refresh() error {
+	s.mu.Lock()
+	fills := s.fills
+	s.mu.Unlock()
+
+	balance, err := s.client.GetBalance()
+	if err != nil {
+		return fmt.Errorf("failed to get account balance: %w", err)
+	}
+	positions, err := s.client.GetPositions()
+	if err != nil {
+		return fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	s.mu.Lock()
+	if s.fills != fills {
+		s.mu.Unlock()
+		return nil
+	}
+	s.balance = balance
+	s.positions = make(map[string]*trackedPosition, len(positions))
+	for _, p := range positions {
+		size := p.Size.Abs()
+		if p.Side == "SHORT" {
+			size = size.Neg()
+		}
+		leverage := p.Leverage
+		if leverage <= 0 {
+			leverage = s.leverage[p.Symbol]
+		}
+		s.positions[p.Symbol] = &trackedPosition{
+			size:        size,
+			entry:       p.EntryPrice,
+			leverage:    leverage,
+			liquidation: p.LiquidationPrice,
+		}
+		// The stream is fresher than the REST mark once it has reported
+		if _, streamed := s.marks[p.Symbol]; !streamed && p.MarkPrice.IsPositive() {
+			s.marks[p.Symbol] = p.MarkPrice
+		}
+	}
+	s.mu.Unlock()
+
+	s.publish()
+	return nil
+}
+
+// ApplyFill updates the position and balance for an execution: the entry
+// price averages in on increases, and closed size realises PnL. Fees are
+// charged to the balance.
+func (s *PositionService) ApplyFill(fill models.Fill) {
+	multiplier := s.instrument(fill.Symbol).Multiplier()
+
+	s.mu.Lock()
+	s.fills++
+	s.balance = s.balance.Sub(fill.Fee)
+
+	signed := fill.Quantity
+	if fill.Side == string(models.SideSell) {
+		signed = signed.Neg()
+	}
+
+	p, exists := s.positions[fill.Symbol]
+	if !exists {
+		p = &trackedPosition{leverage: s.leverage[fill.Symbol]}
+		s.positions[fill.Symbol] = p
+	}
+
+	if p.size.IsZero() || p.size.IsPositive() == signed.IsPositive() {
+		total := p.size.Abs().Add(fill.Quantity)
+		value := p.size.Abs().Mul(p.entry).Add(fill.Quantity.Mul(fill.Price))
+		p.entry = models.RoundHalfEven.Round(value.Div(total), models.PriceDecimals)
+		p.size = p.size.Add(signed)
+	} else {
+		closing := decimal.Min(fill.Quantity, p.size.Abs())
+		pnl := fill.Price.Sub(p.entry).Mul(closing).Mul(multiplier)
+		if p.size.IsNegative() {
+			pnl = pnl.Neg()
+		}
+		s.balance = s.balance.Add(pnl)
+
+		wasLong := p.size.IsPositive()
+		p.size = p.size.Add(signed)
+		if !p.size.IsZero() && p.size.IsPositive() != wasLong {
+			// Position reversed, the remainder opened at the fill price
+			p.entry = fill.Price
+		}
+	}
+	// The exchange's liquidation price no longer applies
+	p.liquidation = decimal.Zero
+	if p.size.IsZero() {
+		delete(s.positions, fill.Symbol)
+	}
+	s.mu.Unlock()
+
+	s.publish()
+}
+
+// ApplyMarketData records mark prices and funding rates from the market
+// data stream
+func (s *PositionService) ApplyMarketData(u marketdata.Update) {
+	s.mu.Lock()
+	switch u.Channel {
+	case marketdata.ChannelMark:
+		s.marks[u.Symbol] = u.Mark.MarkPrice
+	case marketdata.ChannelFunding:
+		s.funding[u.Symbol] = u.Funding.Rate
+	default:
+		s.mu.Unlock()
+		return
+	}
+	_, open := s.positions[u.Symbol]
+	s.mu.Unlock()
+
+	if open {
+		s.publish()
+	}
+}
+
+// Snapshot values the open positions at the latest mark prices
+func (s *PositionService) Snapshot() PortfolioSnapshot {
+	s.mu.Lock()
+	type held struct {
+		symbol string
+		trackedPosition
+		mark    decimal.Decimal
+		funding float64
+	}
+	positions := make([]held, 0, len(s.positions))
+	for symbol, p := range s.positions {
+		positions = append(positions, held{symbol, *p, s.marks[symbol], s.funding[symbol]})
+	}
+	snapshot := PortfolioSnapshot{Balance: s.balance, UpdatedAt: time.Now()}
+	s.mu.Unlock()
+
+	sort.Slice(positions, func(i, j int) bool { return positions[i].symbol < positions[j].symbol })
+	for _, p := range positions {
+		position := s.value(p.symbol, p.trackedPosition, p.mark, snapshot.Balance)
+		position.FundingRate = p.funding
+		if s.stops != nil {
+			position.Stop, _ = s.stops.Stop(p.symbol)
+		}
+		snapshot.UnrealizedPnL = snapshot.UnrealizedPnL.Add(position.UnrealizedPnL)
+		snapshot.Positions = append(snapshot.Positions, position)
+	}
+	snapshot.Equity = snapshot.Balance.Add(snapshot.UnrealizedPnL)
+	return snapshot
+}
+
+// value computes the PnL, margin and liquidation figures of a position. The
+// entry price stands in for a mark not known yet.
+func (s *PositionService) value(symbol string, p trackedPosition, mark, balance decimal.Decimal) PositionSnapshot {
+	inst := s.instrument(symbol)
+	if !mark.IsPositive() {
+		mark = p.entry
+	}
+	leverage := p.leverage
+	if leverage <= 0 {
+		leverage = 1
+	}
+
+	snapshot := PositionSnapshot{
+		Symbol:           symbol,
+		Size:             p.size,
+		EntryPrice:       p.entry,
+		MarkPrice:        mark,
+		Leverage:         leverage,
+		Margin:           p.size.Abs().Mul(p.entry).Mul(inst.Multiplier()).Div(decimal.NewFromFloat(leverage)),
+		UnrealizedPnL:    mark.Sub(p.entry).Mul(p.size).Mul(inst.Multiplier()),
+		LiquidationPrice: p.liquidation,
+	}
+	if snapshot.Margin.IsPositive() {
+		snapshot.ROE = snapshot.UnrealizedPnL.Div(snapshot.Margin).InexactFloat64() * 100
+	}
+
+	if !snapshot.LiquidationPrice.IsPositive() && p.entry.IsPositive() {
+		side := models.SideBuy
+		if p.size.IsNegative() {
+			side = models.SideSell
+		}
+		liquidation, err := s.riskCalculator.CalculateLiquidationPrice(risk_calculator.LiquidationParams{
+			Side:           string(side),
+			EntryPrice:     p.entry,
+			Quantity:       p.size.Abs(),
+			Leverage:       leverage,
+			Mode:           s.marginMode,
+			AccountBalance: balance,
+			Tiers:          s.tiers,
+			Instrument:     inst,
+		})
+		if err == nil {
+			snapshot.LiquidationPrice = liquidation
+		}
+	}
+	if snapshot.LiquidationPrice.IsPositive() && mark.IsPositive() {
+		snapshot.LiquidationDistance = mark.Sub(snapshot.LiquidationPrice).Abs().Div(mark).InexactFloat64() * 100
+	}
+	return snapshot
+}
+
+// instrument returns the trading rules of symbol, the defaults if unknown
+func (s *PositionService) instrument(symbol string) models.Instrument {
+	if s.instruments != nil {
+		if inst, err := s.instruments.Get(symbol); err == nil {
+			return inst
+		}
+	}
+	return models.DefaultInstrument(symbol)
+}
+
+// publish sends a fresh snapshot, replacing one the reader has not taken
+func (s *PositionService) publish() {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	snapshot := s.Snapshot()
+	for {
+		select {
+		case s.snapshots <- snapshot:
+			return
+		default:
+		}
+		select {
+		case <-s.snapshots:
+		default:
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package risk_calculator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// MarginMode selects how collateral backs a position
+type MarginMode int
+
+const (
+	// MarginIsolated backs a position with its initial margin only
+	MarginIsolated MarginMode = iota
+	// MarginCross backs a position with the whole account balance
+	MarginCross
+)
+
+// String returns the config name of the margin mode
+func (m MarginMode) String() string {
+	switch m {
+	case MarginIsolated:
+		return "isolated"
+	case MarginCross:
+		return "cross"
+	default:
+		return fmt.Sprintf("MarginMode(%d)", int(m))
+	}
+}
+
+// ParseMarginMode parses "isolated" or "cross"
+func ParseMarginMode(s string) (MarginMode, error) {
+	switch strings.ToLower(s) {
+	case "", "isolated":
+		return MarginIsolated, nil
+	case "cross":
+		return MarginCross, nil
+	default:
+		return 0, fmt.Errorf("unknown margin mode %q", s)
+	}
+}
+
+// MaintenanceTier is one bracket of the maintenance margin schedule.
+// Positions with notional up to MaxNotional use Rate, with Amount deducted
+// so the requirement is continuous across brackets.
+type MaintenanceTier struct {
+	MaxNotional decimal.Decimal // zero for the unbounded top tier
+	Rate        float64
+	Amount      decimal.Decimal
+}
+
+// DefaultMaintenanceTiers returns a typical perpetual swap maintenance
+// margin schedule
+func DefaultMaintenanceTiers() []MaintenanceTier {
+	tier := func(maxNotional int64, rate float64, amount int64) MaintenanceTier {
+		return MaintenanceTier{MaxNotional: decimal.NewFromInt(maxNotional), Rate: rate, Amount: decimal.NewFromInt(amount)}
+	}
+	return []MaintenanceTier{
+		tier(50000, 0.004, 0),
+		tier(250000, 0.005, 50),
+		tier(1000000, 0.01, 1300),
+		tier(10000000, 0.025, 16300),
+		tier(20000000, 0.05, 266300),
+		tier(50000000, 0.1, 1266300),
+		tier(0, 0.125, 2516300),
+	}
+}
+
+// tierFor returns the maintenance tier for a position notional
+func tierFor(tiers []MaintenanceTier, notional decimal.Decimal) MaintenanceTier {
+	for _, tier := range tiers {
+		if tier.MaxNotional.IsZero() || notional.LessThanOrEqual(tier.MaxNotional) {
+			return tier
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// LiquidationParams holds the inputs for a liquidation price calculation
+type LiquidationParams struct {
+	Side           string // BUY for long, SELL for short
+	EntryPrice     decimal.Decimal
+	Quantity       decimal.Decimal
+	Leverage       float64
+	Mode           MarginMode
+	AccountBalance decimal.Decimal // collateral backing the position in cross mode
+	Tiers          []MaintenanceTier
+	Instrument     models.Instrument // contract multiplier and tick, zero for the defaults
+}
+
+// CalculateLiquidationPrice returns the mark price at which the position's
+// margin balance falls to its maintenance requirement, rounded to the tick
+// towards the entry so the estimate errs on the side of caution. A long that cannot be
+// liquidated returns 0.
+func (rc *RiskCalculator) CalculateLiquidationPrice(params LiquidationParams) (decimal.Decimal, error) {
+	if !params.EntryPrice.IsPositive() || !params.Quantity.IsPositive() {
+		return decimal.Zero, errors.New("entry price and quantity must be positive")
+	}
+
+	inst := gridOf(params.Instrument)
+	units := params.Quantity.Mul(inst.Multiplier())
+	notional := units.Mul(params.EntryPrice)
+
+	var margin decimal.Decimal
+	switch params.Mode {
+	case MarginIsolated:
+		if params.Leverage < 1 {
+			return decimal.Zero, errors.New("leverage must be at least 1")
+		}
+		margin = notional.Div(rate(params.Leverage))
+	case MarginCross:
+		if !params.AccountBalance.IsPositive() {
+			return decimal.Zero, errors.New("account balance must be positive")
+		}
+		margin = params.AccountBalance
+	default:
+		return decimal.Zero, fmt.Errorf("unknown margin mode %v", params.Mode)
+	}
+
+	tiers := params.Tiers
+	if len(tiers) == 0 {
+		tiers = DefaultMaintenanceTiers()
+	}
+	tier := tierFor(tiers, notional)
+	one := decimal.NewFromInt(1)
+
+	// Liquidation when margin + unrealised PnL = quantity * price * rate - amount
+	switch strings.ToUpper(params.Side) {
+	case "BUY":
+		price := notional.Sub(margin).Sub(tier.Amount).Div(units.Mul(one.Sub(rate(tier.Rate))))
+		if price.IsNegative() {
+			return decimal.Zero, nil
+		}
+		return inst.RoundPrice(price, models.RoundCeil), nil
+	case "SELL":
+		price := notional.Add(margin).Add(tier.Amount).Div(units.Mul(one.Add(rate(tier.Rate))))
+		return inst.RoundPrice(price, models.RoundFloor), nil
+	default:
+		return decimal.Zero, fmt.Errorf("invalid side %q", params.Side)
+	}
+}
+
+var ErrNoLeverageFits = errors.New("no leverage fits the position")
+
+// LeverageParams holds the inputs for choosing a leverage
+type LeverageParams struct {
+	Side            string // BUY for long, SELL for short
+	EntryPrice      decimal.Decimal
+	StopLossPrice   decimal.Decimal
+	Quantity        decimal.Decimal
+	AvailableMargin decimal.Decimal
+	MaxLeverage     float64
+
+	// LiquidationBuffer is the minimum distance between the stop and the
+	// liquidation price, as a percentage of the stop price
+	LiquidationBuffer float64
+
+	Mode  MarginMode
+	Tiers []MaintenanceTier
+
+	// Instrument sets the contract multiplier and may lower MaxLeverage,
+	// zero for the defaults
+	Instrument models.Instrument
+}
+
+// SuggestLeverage returns the lowest whole leverage that funds the position
+// from the available margin while keeping the liquidation price at least
+// LiquidationBuffer percent beyond the stop. The instrument's maximum
+// leverage applies when it is below MaxLeverage.
+func (rc *RiskCalculator) SuggestLeverage(params LeverageParams) (float64, error) {
+	if !params.EntryPrice.IsPositive() || !params.StopLossPrice.IsPositive() || !params.Quantity.IsPositive() || !params.AvailableMargin.IsPositive() {
+		return 0, errors.New("all input values must be positive")
+	}
+	if limit := params.Instrument.MaxLeverage; limit > 0 && limit < params.MaxLeverage {
+		params.MaxLeverage = limit
+	}
+	if params.MaxLeverage < 1 {
+		return 0, errors.New("max leverage must be at least 1")
+	}
+
+	notional := gridOf(params.Instrument).Notional(params.Quantity, params.EntryPrice)
+	leverage := math.Max(1, notional.Div(params.AvailableMargin).Ceil().InexactFloat64())
+	if leverage > params.MaxLeverage {
+		return 0, fmt.Errorf("%w: position needs %.0fx, max is %.0fx", ErrNoLeverageFits, leverage, params.MaxLeverage)
+	}
+
+	liquidation, err := rc.CalculateLiquidationPrice(LiquidationParams{
+		Side:           params.Side,
+		EntryPrice:     params.EntryPrice,
+		Quantity:       params.Quantity,
+		Leverage:       leverage,
+		Mode:           params.Mode,
+		AccountBalance: params.AvailableMargin,
+		Tiers:          params.Tiers,
+		Instrument:     params.Instrument,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	buffer := percentOf(params.StopLossPrice, params.LiquidationBuffer)
+	switch strings.ToUpper(params.Side) {
+	case "BUY":
+		if liquidation.GreaterThan(params.StopLossPrice.Sub(buffer)) {
+			return 0, fmt.Errorf("%w: liquidation %s at %.0fx is within %.2f%% of the stop",
+				ErrNoLeverageFits, liquidation.StringFixed(2), leverage, params.LiquidationBuffer)
+		}
+	case "SELL":
+		if liquidation.LessThan(params.StopLossPrice.Add(buffer)) {
+			return 0, fmt.Errorf("%w: liquidation %s at %.0fx is within %.2f%% of the stop",
+				ErrNoLeverageFits, liquidation.StringFixed(2), leverage, params.LiquidationBuffer)
+		}
+	}
+
+	return leverage, nil
+}
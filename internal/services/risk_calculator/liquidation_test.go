@@ -0,0 +1,214 @@
+package risk_calculator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculateLiquidationPrice(t *testing.T) {
+	cents := lotOf("0.001", "0.01")
+	contracts := lotOf("1", "0.5")
+	contracts.ContractMultiplier = dec("0.001")
+
+	tests := []struct {
+		name   string
+		params LiquidationParams
+		want   string
+	}{
+		{
+			// 50000 notional, the top of the first tier, with 5000 margin
+			// and 0.4% maintenance: (50000 - 5000) / 0.996
+			name:   "isolated long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("1"), Leverage: 10, Instrument: cents},
+			want:   "45180.73",
+		},
+		{
+			// (50000 + 5000) / 1.004
+			name:   "isolated short",
+			params: LiquidationParams{Side: "SELL", EntryPrice: dec("50000"), Quantity: dec("1"), Leverage: 10, Instrument: cents},
+			want:   "54780.87",
+		},
+		{
+			// 20000 balance: (50000 - 20000) / 0.996
+			name:   "cross long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("1"), Mode: MarginCross, AccountBalance: dec("20000"), Instrument: cents},
+			want:   "30120.49",
+		},
+		{
+			// (50000 + 20000) / 1.004
+			name:   "cross short",
+			params: LiquidationParams{Side: "sell", EntryPrice: dec("50000"), Quantity: dec("1"), Mode: MarginCross, AccountBalance: dec("20000"), Instrument: cents},
+			want:   "69721.11",
+		},
+		{
+			// Just into the second tier, 0.5% less 50:
+			// (50001 - 5000.1 - 50) / 0.995
+			name:   "second tier long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50001"), Quantity: dec("1"), Leverage: 10, Instrument: cents},
+			want:   "45176.79",
+		},
+		{
+			// (50001 + 5000.1 + 50) / 1.005
+			name:   "second tier short",
+			params: LiquidationParams{Side: "SELL", EntryPrice: dec("50001"), Quantity: dec("1"), Leverage: 10, Instrument: cents},
+			want:   "54777.21",
+		},
+		{
+			// 500000 notional, 1% less 1300: (500000 - 25000 - 1300) / 9.9
+			name:   "third tier long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("10"), Leverage: 20, Instrument: cents},
+			want:   "47848.49",
+		},
+		{
+			// (500000 + 25000 + 1300) / 10.1
+			name:   "third tier short",
+			params: LiquidationParams{Side: "SELL", EntryPrice: dec("50000"), Quantity: dec("10"), Leverage: 20, Instrument: cents},
+			want:   "52108.91",
+		},
+		{
+			// A flat 1% schedule: (100 - 10) / 0.99 = 90.909
+			name: "custom tiers",
+			params: LiquidationParams{
+				Side: "BUY", EntryPrice: dec("100"), Quantity: dec("1"), Leverage: 10, Instrument: cents,
+				Tiers: []MaintenanceTier{{Rate: 0.01}},
+			},
+			want: "90.91",
+		},
+		{
+			// 1000 contracts of 0.001 BTC are 1 BTC, rounded up to the
+			// half tick for the long
+			name:   "contracts long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("1000"), Leverage: 10, Instrument: contracts},
+			want:   "45181",
+		},
+		{
+			// Rounded down to the half tick for the short
+			name:   "contracts short",
+			params: LiquidationParams{Side: "SELL", EntryPrice: dec("50000"), Quantity: dec("1000"), Leverage: 10, Instrument: contracts},
+			want:   "54780.5",
+		},
+		{
+			// The margin covers the whole notional
+			name:   "unleveraged long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("1"), Leverage: 1, Instrument: cents},
+			want:   "0",
+		},
+		{
+			name:   "overcollateralised cross long",
+			params: LiquidationParams{Side: "BUY", EntryPrice: dec("50000"), Quantity: dec("1"), Mode: MarginCross, AccountBalance: dec("100000"), Instrument: cents},
+			want:   "0",
+		},
+	}
+
+	rc := &RiskCalculator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rc.CalculateLiquidationPrice(tt.params)
+			if err != nil {
+				t.Fatalf("CalculateLiquidationPrice: %v", err)
+			}
+			if !got.Equal(dec(tt.want)) {
+				t.Errorf("liquidation price = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateLiquidationPriceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params LiquidationParams
+	}{
+		{"no quantity", LiquidationParams{Side: "BUY", EntryPrice: dec("100"), Leverage: 10}},
+		{"no entry", LiquidationParams{Side: "BUY", Quantity: dec("1"), Leverage: 10}},
+		{"leverage below 1", LiquidationParams{Side: "BUY", EntryPrice: dec("100"), Quantity: dec("1"), Leverage: 0.5}},
+		{"cross without balance", LiquidationParams{Side: "BUY", EntryPrice: dec("100"), Quantity: dec("1"), Mode: MarginCross}},
+		{"unknown mode", LiquidationParams{Side: "BUY", EntryPrice: dec("100"), Quantity: dec("1"), Leverage: 10, Mode: MarginMode(7)}},
+		{"unknown side", LiquidationParams{Side: "HOLD", EntryPrice: dec("100"), Quantity: dec("1"), Leverage: 10}},
+	}
+
+	rc := &RiskCalculator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := rc.CalculateLiquidationPrice(tt.params); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestSuggestLeverage(t *testing.T) {
+	long := func(stop, margin string, buffer float64) LeverageParams {
+		return LeverageParams{
+			Side: "BUY", EntryPrice: dec("50000"), StopLossPrice: dec(stop), Quantity: dec("1"),
+			AvailableMargin: dec(margin), MaxLeverage: 20, LiquidationBuffer: buffer,
+		}
+	}
+	short := func(stop, margin string, buffer float64) LeverageParams {
+		p := long(stop, margin, buffer)
+		p.Side = "SELL"
+		return p
+	}
+	withInstrumentMax := long("48000", "10000", 1)
+	withInstrumentMax.Instrument.MaxLeverage = 4
+	cross := long("48000", "10000", 1)
+	cross.Mode = MarginCross
+	contracts := long("48000", "10000", 1)
+	contracts.Quantity = dec("100")
+	contracts.Instrument = lotOf("1", "0.1")
+	contracts.Instrument.ContractMultiplier = dec("0.01")
+
+	tests := []struct {
+		name   string
+		params LeverageParams
+		want   float64
+		err    error
+	}{
+		// 50000 notional from 10000 margin, liquidation at 40160.64 is
+		// well below the stop
+		{"long", long("48000", "10000", 1), 5, nil},
+		// 50000 / 15000 = 3.33, rounded up
+		{"whole leverage", long("48000", "15000", 1), 4, nil},
+		// The margin covers the notional
+		{"unleveraged", long("48000", "100000", 1), 1, nil},
+		// 20x liquidates at 47690.77, below 49000 less 1%
+		{"liquidation beyond the buffer", long("49000", "2500", 1), 20, nil},
+		// but not 49000 less 5% = 46550
+		{"liquidation within the buffer", long("49000", "2500", 5), 0, ErrNoLeverageFits},
+		{"needs more than the max", long("48000", "2000", 1), 0, ErrNoLeverageFits},
+		{"instrument max is lower", withInstrumentMax, 0, ErrNoLeverageFits},
+		// 10x liquidates at 54780.87, above 51000 plus 2%
+		{"short", short("51000", "5000", 2), 10, nil},
+		// 20x liquidates at 52290.83, above 52020
+		{"short at the max", short("51000", "2500", 2), 20, nil},
+		// but not above 52530
+		{"short within the buffer", short("51000", "2500", 3), 0, ErrNoLeverageFits},
+		// The whole margin backs the position, liquidation at 40160.64
+		{"cross", cross, 5, nil},
+		// 100 contracts of 0.01 BTC are 50000 notional
+		{"contracts", contracts, 5, nil},
+	}
+
+	rc := &RiskCalculator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rc.SuggestLeverage(tt.params)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("leverage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	invalid := long("48000", "10000", 1)
+	invalid.MaxLeverage = 0
+	if _, err := rc.SuggestLeverage(invalid); err == nil {
+		t.Error("no error for a max leverage below 1")
+	}
+	invalid = long("48000", "0", 1)
+	if _, err := rc.SuggestLeverage(invalid); err == nil {
+		t.Error("no error without margin")
+	}
+}
@@ -2,17 +2,32 @@
 package risk_calculator
 
 import (
-    "math"
     "errors"
+
+    "github.com/shopspring/decimal"
+    "github.com/sub0xdai/n0xtilus/internal/models"
 )
 
 // RiskCalculatorService defines the interface for risk calculation
 type RiskCalculatorService interface {
     // CalculateRisk calculates the risk ratio for a given position
-    CalculateRisk(accountBalance, riskPercentage, quantity, price float64) (float64, error)
+    CalculateRisk(accountBalance decimal.Decimal, riskPercentage float64, quantity, price decimal.Decimal) (float64, error)
     
     // CalculatePositionSize calculates the position size based on risk parameters
-    CalculatePositionSize(accountBalance, riskPercentage, entryPrice, stopLossPrice float64) (float64, error)
+    CalculatePositionSize(accountBalance decimal.Decimal, riskPercentage float64, entryPrice, stopLossPrice decimal.Decimal) (decimal.Decimal, error)
+
+    // CalculatePositionSizeWithCosts calculates the position size whose loss at the stop,
+    // including fees, funding and slippage, equals the risk amount
+    CalculatePositionSizeWithCosts(params SizingParams) (SizingResult, error)
+
+    // CalculateLiquidationPrice calculates the price at which a position would be liquidated
+    CalculateLiquidationPrice(params LiquidationParams) (decimal.Decimal, error)
+
+    // SuggestLeverage proposes the lowest leverage that fits the position into the available margin
+    SuggestLeverage(params LeverageParams) (float64, error)
+
+    // CalculateTakeProfits resolves R multiple take profit targets to prices and quantities
+    CalculateTakeProfits(inst models.Instrument, entryPrice, stopLossPrice, quantity decimal.Decimal, targets []TakeProfitTarget) ([]TakeProfitLevel, error)
 }
 
 // RiskCalculator implements RiskCalculatorService
@@ -24,28 +39,47 @@ func NewRiskCalculator() RiskCalculatorService {
 }
 
 // CalculateRisk calculates the risk ratio
-func (rc *RiskCalculator) CalculateRisk(accountBalance, riskPercentage, quantity, price float64) (float64, error) {
-    if accountBalance <= 0 || riskPercentage <= 0 || quantity <= 0 || price <= 0 {
+func (rc *RiskCalculator) CalculateRisk(accountBalance decimal.Decimal, riskPercentage float64, quantity, price decimal.Decimal) (float64, error) {
+    if !accountBalance.IsPositive() || riskPercentage <= 0 || !quantity.IsPositive() || !price.IsPositive() {
         return 0, errors.New("all input values must be positive")
     }
 
-    riskAmount := accountBalance * (riskPercentage / 100)
-    positionSize := quantity * price
-    actualRisk := (positionSize / accountBalance) * 100
-    return actualRisk / riskAmount, nil // Return risk as a ratio of actual risk to intended risk
+    riskAmount := percentOf(accountBalance, riskPercentage)
+    positionSize := quantity.Mul(price)
+    actualRisk := positionSize.Div(accountBalance).Mul(decimal.NewFromInt(100))
+    return actualRisk.Div(riskAmount).InexactFloat64(), nil // Return risk as a ratio of actual risk to intended risk
 }
 
-// CalculatePositionSize calculates the position size based on risk parameters
-func (rc *RiskCalculator) CalculatePositionSize(accountBalance, riskPercentage, entryPrice, stopLossPrice float64) (float64, error) {
-    if accountBalance <= 0 || riskPercentage <= 0 || entryPrice <= 0 || stopLossPrice <= 0 {
-        return 0, errors.New("all input values must be positive")
+// CalculatePositionSize calculates the position size based on risk parameters.
+// The size is rounded down so the loss at the stop never exceeds the risk amount.
+func (rc *RiskCalculator) CalculatePositionSize(accountBalance decimal.Decimal, riskPercentage float64, entryPrice, stopLossPrice decimal.Decimal) (decimal.Decimal, error) {
+    if !accountBalance.IsPositive() || riskPercentage <= 0 || !entryPrice.IsPositive() || !stopLossPrice.IsPositive() {
+        return decimal.Zero, errors.New("all input values must be positive")
     }
 
-    if entryPrice == stopLossPrice {
-        return 0, errors.New("entry price cannot be equal to stop loss price")
+    if entryPrice.Equal(stopLossPrice) {
+        return decimal.Zero, errors.New("entry price cannot be equal to stop loss price")
     }
 
-    riskAmount := accountBalance * (riskPercentage / 100)
-    riskPerShare := math.Abs(entryPrice - stopLossPrice)
-    return riskAmount / riskPerShare, nil
+    riskAmount := percentOf(accountBalance, riskPercentage)
+    riskPerShare := entryPrice.Sub(stopLossPrice).Abs()
+    return models.RoundDown.Round(riskAmount.Div(riskPerShare), models.QuantityDecimals), nil
+}
+
+// percentOf returns percent percent of amount
+func percentOf(amount decimal.Decimal, percent float64) decimal.Decimal {
+    return amount.Mul(decimal.NewFromFloat(percent)).Div(decimal.NewFromInt(100))
+}
+
+// gridOf returns inst, or the default 8 decimal grid when it is unset
+func gridOf(inst models.Instrument) models.Instrument {
+    if inst.IsZero() {
+        return models.DefaultInstrument("")
+    }
+    return inst
+}
+
+// rate converts a fractional rate to a decimal for use with amounts
+func rate(r float64) decimal.Decimal {
+    return decimal.NewFromFloat(r)
 }
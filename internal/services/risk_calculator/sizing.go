@@ -0,0 +1,230 @@
+package risk_calculator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+var ErrInsufficientLiquidity = errors.New("insufficient order book liquidity")
+
+// FeeSchedule holds exchange fee rates as fractions of notional
+type FeeSchedule struct {
+	MakerRate float64
+	TakerRate float64
+
+	// FundingRate is the expected funding paid per period and
+	// FundingPeriods the number of periods the position is expected to be
+	// held
+	FundingRate    float64
+	FundingPeriods float64
+}
+
+// SlippageModel estimates how far a market execution fills from its
+// trigger price
+type SlippageModel interface {
+	// Slippage returns the expected adverse price move per unit when
+	// executing quantity on side at price
+	Slippage(side string, quantity, price decimal.Decimal) (decimal.Decimal, error)
+}
+
+// FixedBpsSlippage assumes a constant slippage in basis points of the price
+type FixedBpsSlippage struct {
+	Bps float64
+}
+
+// Slippage returns price * Bps / 10000
+func (s FixedBpsSlippage) Slippage(side string, quantity, price decimal.Decimal) (decimal.Decimal, error) {
+	return price.Mul(rate(s.Bps)).Div(decimal.NewFromInt(10000)), nil
+}
+
+// BookLevel is one price level of an order book
+type BookLevel struct {
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// OrderBookSlippage estimates slippage by walking an order book snapshot.
+// The shape of the book relative to the best price is assumed to hold when
+// the stop triggers.
+type OrderBookSlippage struct {
+	Bids []BookLevel // best first
+	Asks []BookLevel // best first
+}
+
+// Slippage returns the distance between the best price and the average
+// price of filling quantity. Sells walk the bids, buys walk the asks.
+func (s OrderBookSlippage) Slippage(side string, quantity, price decimal.Decimal) (decimal.Decimal, error) {
+	levels := s.Asks
+	if side == "SELL" {
+		levels = s.Bids
+	}
+	if len(levels) == 0 {
+		return decimal.Zero, ErrInsufficientLiquidity
+	}
+
+	remaining := quantity
+	cost := decimal.Zero
+	for _, level := range levels {
+		fill := decimal.Min(remaining, level.Quantity)
+		cost = cost.Add(fill.Mul(level.Price))
+		remaining = remaining.Sub(fill)
+		if !remaining.IsPositive() {
+			break
+		}
+	}
+	if remaining.IsPositive() {
+		return decimal.Zero, ErrInsufficientLiquidity
+	}
+
+	if quantity.IsZero() {
+		return decimal.Zero, nil
+	}
+	return cost.Div(quantity).Sub(levels[0].Price).Abs(), nil
+}
+
+// CostModel describes the trading costs incurred between entry and stop out
+type CostModel struct {
+	Fees FeeSchedule
+
+	// Slippage is applied to the stop loss exit, nil for none
+	Slippage SlippageModel
+
+	// EntryIsMaker is set when the entry rests on the book and pays the
+	// maker fee. Otherwise the taker fee is assumed.
+	EntryIsMaker bool
+}
+
+// SizingParams holds the inputs for cost-aware position sizing
+type SizingParams struct {
+	AccountBalance decimal.Decimal
+	RiskPercentage float64
+	EntryPrice     decimal.Decimal
+	StopLossPrice  decimal.Decimal
+	Costs          CostModel
+
+	// Instrument sets the quantity step and contract multiplier, zero for
+	// the default 8 decimal grid
+	Instrument models.Instrument
+}
+
+// SizingResult is the position size and a breakdown of the loss taken if
+// the stop is hit
+type SizingResult struct {
+	Quantity     decimal.Decimal
+	RiskAmount   decimal.Decimal // target loss at the stop
+	PriceLoss    decimal.Decimal // quantity * |entry - stop|
+	EntryFee     decimal.Decimal
+	ExitFee      decimal.Decimal
+	FundingCost  decimal.Decimal
+	SlippageCost decimal.Decimal
+	TotalLoss    decimal.Decimal
+}
+
+// TotalCosts returns the fees, funding and slippage included in the loss
+func (r SizingResult) TotalCosts() decimal.Decimal {
+	return r.EntryFee.Add(r.ExitFee).Add(r.FundingCost).Add(r.SlippageCost)
+}
+
+// CalculatePositionSizeWithCosts solves for the largest position size,
+// in whole steps of the instrument's lot size, whose all-in loss at the stop, including
+// fees, funding and slippage, does not exceed the risk amount
+func (rc *RiskCalculator) CalculatePositionSizeWithCosts(params SizingParams) (SizingResult, error) {
+	if !params.AccountBalance.IsPositive() || params.RiskPercentage <= 0 || !params.EntryPrice.IsPositive() || !params.StopLossPrice.IsPositive() {
+		return SizingResult{}, errors.New("all input values must be positive")
+	}
+	if params.EntryPrice.Equal(params.StopLossPrice) {
+		return SizingResult{}, errors.New("entry price cannot be equal to stop loss price")
+	}
+
+	inst := gridOf(params.Instrument)
+	params.Instrument = inst
+	riskAmount := percentOf(params.AccountBalance, params.RiskPercentage)
+
+	// The cost-free size is an upper bound, costs only add to the loss
+	riskPerContract := params.EntryPrice.Sub(params.StopLossPrice).Abs().Mul(inst.Multiplier())
+	high := inst.RoundQuantity(riskAmount.Div(riskPerContract))
+	if !high.IsPositive() || high.LessThan(inst.MinQuantity) {
+		return SizingResult{}, fmt.Errorf("risk amount is below the minimum quantity %s", inst.MinQuantity)
+	}
+	best, err := lossAtStop(params, high)
+	if err != nil && !errors.Is(err, ErrInsufficientLiquidity) {
+		return SizingResult{}, err
+	}
+	if err == nil && best.TotalLoss.LessThanOrEqual(riskAmount) {
+		best.RiskAmount = riskAmount
+		return best, nil
+	}
+
+	// Bisect over whole steps: low always fits, high never does
+	increment := inst.StepSize
+	two := decimal.NewFromInt(2)
+	low, highSteps := decimal.Zero, high.Div(increment)
+	best = SizingResult{}
+	for highSteps.Sub(low).GreaterThan(decimal.NewFromInt(1)) {
+		mid := low.Add(highSteps).Div(two).Floor()
+		result, err := lossAtStop(params, mid.Mul(increment))
+		if err != nil && !errors.Is(err, ErrInsufficientLiquidity) {
+			return SizingResult{}, err
+		}
+		if err == nil && result.TotalLoss.LessThanOrEqual(riskAmount) {
+			low = mid
+			best = result
+		} else {
+			highSteps = mid
+		}
+	}
+
+	if !best.Quantity.IsPositive() || best.Quantity.LessThan(inst.MinQuantity) {
+		return SizingResult{}, errors.New("costs exceed the risk amount")
+	}
+	best.RiskAmount = riskAmount
+	return best, nil
+}
+
+// lossAtStop computes the loss breakdown of a position of quantity
+// contracts stopped out at the stop price
+func lossAtStop(params SizingParams, quantity decimal.Decimal) (SizingResult, error) {
+	entry, stop := params.EntryPrice, params.StopLossPrice
+	units := quantity.Mul(params.Instrument.Multiplier())
+	long := entry.GreaterThan(stop)
+
+	exitSide := "SELL"
+	if !long {
+		exitSide = "BUY"
+	}
+
+	slippage := decimal.Zero
+	if params.Costs.Slippage != nil {
+		var err error
+		slippage, err = params.Costs.Slippage.Slippage(exitSide, units, stop)
+		if err != nil {
+			return SizingResult{}, err
+		}
+	}
+
+	exitPrice := stop.Sub(slippage)
+	if !long {
+		exitPrice = stop.Add(slippage)
+	}
+
+	fees := params.Costs.Fees
+	entryRate := fees.TakerRate
+	if params.Costs.EntryIsMaker {
+		entryRate = fees.MakerRate
+	}
+
+	entryNotional := units.Mul(entry)
+	result := SizingResult{
+		Quantity:     quantity,
+		PriceLoss:    units.Mul(entry.Sub(stop).Abs()),
+		EntryFee:     entryNotional.Mul(rate(entryRate)),
+		ExitFee:      units.Mul(exitPrice).Mul(rate(fees.TakerRate)),
+		FundingCost:  entryNotional.Mul(rate(fees.FundingRate)).Mul(rate(fees.FundingPeriods)),
+		SlippageCost: units.Mul(slippage),
+	}
+	result.TotalLoss = result.PriceLoss.Add(result.TotalCosts())
+	return result, nil
+}
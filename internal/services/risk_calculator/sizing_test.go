@@ -0,0 +1,249 @@
+package risk_calculator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// lotOf returns an instrument trading in steps of step with the tick tick
+func lotOf(step, tick string) models.Instrument {
+	return models.Instrument{Symbol: "TEST/USDT", TickSize: dec(tick), StepSize: dec(step), MinQuantity: dec(step)}
+}
+
+func TestCalculatePositionSizeWithCosts(t *testing.T) {
+	contracts := lotOf("1", "0.1")
+	contracts.ContractMultiplier = dec("0.01")
+
+	tests := []struct {
+		name   string
+		params SizingParams
+		want   SizingResult
+	}{
+		{
+			// 1% of 10000 is 100, 1000 lost per BTC
+			name: "long without costs",
+			params: SizingParams{
+				AccountBalance: dec("10000"), RiskPercentage: 1,
+				EntryPrice: dec("50000"), StopLossPrice: dec("49000"),
+			},
+			want: SizingResult{Quantity: dec("0.1"), RiskAmount: dec("100"), PriceLoss: dec("100"), TotalLoss: dec("100")},
+		},
+		{
+			// 2% of 5000 is 100, 50 lost per ETH
+			name: "short without costs",
+			params: SizingParams{
+				AccountBalance: dec("5000"), RiskPercentage: 2,
+				EntryPrice: dec("2000"), StopLossPrice: dec("2050"),
+				Instrument: lotOf("0.01", "0.01"),
+			},
+			want: SizingResult{Quantity: dec("2"), RiskAmount: dec("100"), PriceLoss: dec("100"), TotalLoss: dec("100")},
+		},
+		{
+			// 100 / 300 = 0.3333, rounded down to the lot
+			name: "rounded down to the lot",
+			params: SizingParams{
+				AccountBalance: dec("10000"), RiskPercentage: 1,
+				EntryPrice: dec("30000"), StopLossPrice: dec("29700"),
+				Instrument: lotOf("0.001", "0.1"),
+			},
+			want: SizingResult{Quantity: dec("0.333"), RiskAmount: dec("100"), PriceLoss: dec("99.9"), TotalLoss: dec("99.9")},
+		},
+		{
+			// 5 lost per contract of 0.01 BTC
+			name: "contract multiplier",
+			params: SizingParams{
+				AccountBalance: dec("10000"), RiskPercentage: 1,
+				EntryPrice: dec("50000"), StopLossPrice: dec("49500"),
+				Instrument: contracts,
+			},
+			want: SizingResult{Quantity: dec("20"), RiskAmount: dec("100"), PriceLoss: dec("100"), TotalLoss: dec("100")},
+		},
+		{
+			// 10 + 0.1 taker entry + 0.09 taker exit = 10.19 lost per
+			// unit, 100 / 10.19 = 9.81 rounded down to 9.8
+			name: "long with taker fees",
+			params: SizingParams{
+				AccountBalance: dec("10000"), RiskPercentage: 1,
+				EntryPrice: dec("100"), StopLossPrice: dec("90"),
+				Costs:      CostModel{Fees: FeeSchedule{MakerRate: 0.0005, TakerRate: 0.001}},
+				Instrument: lotOf("0.1", "0.01"),
+			},
+			want: SizingResult{
+				Quantity: dec("9.8"), RiskAmount: dec("100"), PriceLoss: dec("98"),
+				EntryFee: dec("0.98"), ExitFee: dec("0.882"), TotalLoss: dec("99.862"),
+			},
+		},
+		{
+			// 50 + 0.2 maker entry + 1.05 slippage + 0.525525 taker exit
+			// at 1051.05 + 0.3 funding = 52.075525 lost per unit,
+			// 100 / 52.075525 = 1.9203 rounded down to 1.92
+			name: "short with maker entry, slippage and funding",
+			params: SizingParams{
+				AccountBalance: dec("20000"), RiskPercentage: 0.5,
+				EntryPrice: dec("1000"), StopLossPrice: dec("1050"),
+				Costs: CostModel{
+					Fees:         FeeSchedule{MakerRate: 0.0002, TakerRate: 0.0005, FundingRate: 0.0001, FundingPeriods: 3},
+					Slippage:     FixedBpsSlippage{Bps: 10},
+					EntryIsMaker: true,
+				},
+				Instrument: lotOf("0.01", "0.01"),
+			},
+			want: SizingResult{
+				Quantity: dec("1.92"), RiskAmount: dec("100"), PriceLoss: dec("96"),
+				EntryFee: dec("0.384"), ExitFee: dec("1.009008"), FundingCost: dec("0.576"),
+				SlippageCost: dec("2.016"), TotalLoss: dec("99.985008"),
+			},
+		},
+		{
+			// 2 units sell 1 at 100 and 1 at 99, slipping 1 in all, and
+			// lose 20 + 1 = 21 > 20. 1.9 units slip 0.9 * 1 and lose
+			// 19 + 0.9 = 19.9.
+			name: "long walking the book",
+			params: SizingParams{
+				AccountBalance: dec("1000"), RiskPercentage: 2,
+				EntryPrice: dec("110"), StopLossPrice: dec("100"),
+				Costs: CostModel{Slippage: OrderBookSlippage{Bids: []BookLevel{
+					{Price: dec("100"), Quantity: dec("1")},
+					{Price: dec("99"), Quantity: dec("5")},
+				}}},
+				Instrument: lotOf("0.1", "0.01"),
+			},
+			want: SizingResult{
+				Quantity: dec("1.9"), RiskAmount: dec("20"), PriceLoss: dec("19"),
+				SlippageCost: dec("0.9"), TotalLoss: dec("19.9"),
+			},
+		},
+	}
+
+	rc := &RiskCalculator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rc.CalculatePositionSizeWithCosts(tt.params)
+			if err != nil {
+				t.Fatalf("CalculatePositionSizeWithCosts: %v", err)
+			}
+			fields := []struct {
+				name      string
+				got, want decimal.Decimal
+			}{
+				{"quantity", got.Quantity, tt.want.Quantity},
+				{"risk amount", got.RiskAmount, tt.want.RiskAmount},
+				{"price loss", got.PriceLoss, tt.want.PriceLoss},
+				{"entry fee", got.EntryFee, tt.want.EntryFee},
+				{"exit fee", got.ExitFee, tt.want.ExitFee},
+				{"funding cost", got.FundingCost, tt.want.FundingCost},
+				{"slippage cost", got.SlippageCost, tt.want.SlippageCost},
+				{"total loss", got.TotalLoss, tt.want.TotalLoss},
+			}
+			for _, f := range fields {
+				if !f.got.Round(8).Equal(f.want) {
+					t.Errorf("%s = %s, want %s", f.name, f.got, f.want)
+				}
+			}
+			if got.TotalLoss.GreaterThan(got.RiskAmount) {
+				t.Errorf("total loss %s exceeds the risk amount %s", got.TotalLoss, got.RiskAmount)
+			}
+		})
+	}
+}
+
+func TestCalculatePositionSizeWithCostsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params SizingParams
+		want   string
+	}{
+		{
+			name:   "no balance",
+			params: SizingParams{RiskPercentage: 1, EntryPrice: dec("100"), StopLossPrice: dec("90")},
+			want:   "must be positive",
+		},
+		{
+			name:   "stop at entry",
+			params: SizingParams{AccountBalance: dec("1000"), RiskPercentage: 1, EntryPrice: dec("100"), StopLossPrice: dec("100")},
+			want:   "cannot be equal",
+		},
+		{
+			// 1 of risk buys 0.001 BTC, below the lot
+			name: "below the minimum quantity",
+			params: SizingParams{
+				AccountBalance: dec("100"), RiskPercentage: 1,
+				EntryPrice: dec("50000"), StopLossPrice: dec("49000"),
+				Instrument: lotOf("0.01", "0.1"),
+			},
+			want: "below the minimum quantity",
+		},
+		{
+			// A single unit loses 1 at the stop and 99.5 in fees
+			name: "costs exceed the risk",
+			params: SizingParams{
+				AccountBalance: dec("100"), RiskPercentage: 1,
+				EntryPrice: dec("100"), StopLossPrice: dec("99"),
+				Costs:      CostModel{Fees: FeeSchedule{TakerRate: 0.5}},
+				Instrument: lotOf("1", "1"),
+			},
+			want: "costs exceed",
+		},
+	}
+
+	rc := &RiskCalculator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rc.CalculatePositionSizeWithCosts(tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderBookSlippage(t *testing.T) {
+	book := OrderBookSlippage{
+		Bids: []BookLevel{{Price: dec("100"), Quantity: dec("1")}, {Price: dec("99"), Quantity: dec("2")}},
+		Asks: []BookLevel{{Price: dec("101"), Quantity: dec("1")}, {Price: dec("103"), Quantity: dec("1")}},
+	}
+	tests := []struct {
+		name     string
+		side     string
+		quantity string
+		want     string
+		err      error
+	}{
+		{"sell within the best bid", "SELL", "1", "0", nil},
+		{"sell two levels", "SELL", "2", "0.5", nil},
+		{"sell the whole book", "SELL", "3", "0.6666666666666667", nil},
+		{"sell beyond the book", "SELL", "4", "0", ErrInsufficientLiquidity},
+		{"buy two levels", "BUY", "2", "1", nil},
+		{"nothing", "BUY", "0", "0", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := book.Slippage(tt.side, dec(tt.quantity), dec("100"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if !got.Equal(dec(tt.want)) {
+				t.Errorf("slippage = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (OrderBookSlippage{}).Slippage("BUY", dec("1"), dec("100")); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("empty book error = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func TestFixedBpsSlippage(t *testing.T) {
+	got, _ := FixedBpsSlippage{Bps: 25}.Slippage("SELL", dec("3"), dec("2000"))
+	if !got.Equal(dec("5")) {
+		t.Errorf("slippage = %s, want 5", got)
+	}
+}
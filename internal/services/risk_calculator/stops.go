@@ -0,0 +1,132 @@
+package risk_calculator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// ErrATRNotReady means an ATR trailing stop has no average true range to
+// follow yet
+var ErrATRNotReady = errors.New("average true range not available yet")
+
+// BreakevenPrice returns the stop price at which a position closes without
+// loss once the entry and exit fees are paid. The exit is a stop-market
+// order and pays the taker fee. The price is rounded to the tick away from
+// the entry, so the stop never locks in a loss.
+func BreakevenPrice(inst models.Instrument, side string, entryPrice decimal.Decimal, costs CostModel) decimal.Decimal {
+	entryRate := costs.Fees.TakerRate
+	if costs.EntryIsMaker {
+		entryRate = costs.Fees.MakerRate
+	}
+	one := decimal.NewFromInt(1)
+	exitRate := rate(costs.Fees.TakerRate)
+
+	inst = gridOf(inst)
+	if strings.ToUpper(side) == "SELL" {
+		// Selling at entry and buying back at p nets entry*(1-e) - p*(1+x)
+		price := entryPrice.Mul(one.Sub(rate(entryRate))).Div(one.Add(exitRate))
+		return inst.RoundPrice(price, models.RoundFloor)
+	}
+	price := entryPrice.Mul(one.Add(rate(entryRate))).Div(one.Sub(exitRate))
+	return inst.RoundPrice(price, models.RoundCeil)
+}
+
+// TrailMode is how a trailing stop measures its distance from the price
+type TrailMode string
+
+const (
+	// TrailPercent keeps the stop a percentage of the price away
+	TrailPercent TrailMode = "percent"
+	// TrailATR keeps the stop a multiple of the average true range away
+	TrailATR TrailMode = "atr"
+	// TrailFixed keeps the stop a fixed price distance away
+	TrailFixed TrailMode = "fixed"
+)
+
+// TrailingStop follows the best price since it was set, at a distance
+// given by Mode and Amount
+type TrailingStop struct {
+	Mode TrailMode
+	// Amount is the percentage, ATR multiple or price distance
+	Amount decimal.Decimal
+}
+
+// ParseTrailingStop parses a trailing distance written as a percentage
+// ("1.5%"), an ATR multiple ("2atr") or a price distance ("150")
+func ParseTrailingStop(s string) (TrailingStop, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	trail := TrailingStop{Mode: TrailFixed}
+	switch {
+	case strings.HasSuffix(value, "%"):
+		trail.Mode, value = TrailPercent, strings.TrimSuffix(value, "%")
+	case strings.HasSuffix(value, "atr"):
+		trail.Mode, value = TrailATR, strings.TrimSuffix(value, "atr")
+	}
+
+	amount, err := decimal.NewFromString(strings.TrimSpace(value))
+	if err != nil || !amount.IsPositive() {
+		return TrailingStop{}, fmt.Errorf("invalid trailing distance %q, expected e.g. 1%%, 2atr or 150", s)
+	}
+	if trail.Mode == TrailPercent && amount.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return TrailingStop{}, fmt.Errorf("trailing percentage must be below 100%%, got %s%%", amount)
+	}
+	trail.Amount = amount
+	return trail, nil
+}
+
+// String formats the trailing distance as ParseTrailingStop reads it
+func (t TrailingStop) String() string {
+	switch t.Mode {
+	case TrailPercent:
+		return t.Amount.String() + "%"
+	case TrailATR:
+		return t.Amount.String() + "atr"
+	}
+	return t.Amount.String()
+}
+
+// IsZero reports whether no trailing stop is set
+func (t TrailingStop) IsZero() bool {
+	return t.Mode == "" && t.Amount.IsZero()
+}
+
+// Distance returns how far the stop trails price. atr is only needed, and
+// must be positive, for ATR trailing.
+func (t TrailingStop) Distance(price, atr decimal.Decimal) (decimal.Decimal, error) {
+	switch t.Mode {
+	case TrailPercent:
+		return price.Mul(t.Amount).Div(decimal.NewFromInt(100)), nil
+	case TrailATR:
+		if !atr.IsPositive() {
+			return decimal.Zero, ErrATRNotReady
+		}
+		return atr.Mul(t.Amount), nil
+	case TrailFixed:
+		return t.Amount, nil
+	}
+	return decimal.Zero, fmt.Errorf("unknown trailing mode %q", t.Mode)
+}
+
+// StopPrice returns the stop trailing best, the most favourable price
+// since the stop was set, for a position opened on side. The stop is
+// rounded to the tick away from the price, so it never trails closer than
+// the distance.
+func (t TrailingStop) StopPrice(inst models.Instrument, side string, best, atr decimal.Decimal) (decimal.Decimal, error) {
+	distance, err := t.Distance(best, atr)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	inst = gridOf(inst)
+	if strings.ToUpper(side) == "SELL" {
+		return inst.RoundPrice(best.Add(distance), models.RoundCeil), nil
+	}
+	stop := inst.RoundPrice(best.Sub(distance), models.RoundFloor)
+	if !stop.IsPositive() {
+		return decimal.Zero, fmt.Errorf("trailing distance %s is beyond the price %s", distance, best)
+	}
+	return stop, nil
+}
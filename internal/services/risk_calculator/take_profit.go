@@ -0,0 +1,156 @@
+package risk_calculator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+)
+
+// TakeProfitTarget is a scaled exit of SizePercent of the position at
+// RMultiple times the initial risk
+type TakeProfitTarget struct {
+	RMultiple   float64
+	SizePercent float64
+}
+
+// TakeProfitLevel is a target resolved to a price and quantity
+type TakeProfitLevel struct {
+	RMultiple   float64
+	SizePercent float64
+	Price       decimal.Decimal
+	Quantity    decimal.Decimal
+	Reward      decimal.Decimal // profit if filled, before fees
+}
+
+// ParseTakeProfitTargets parses targets written as R:percent pairs, e.g.
+// "1:50,2:30,3:20" for 50% at 1R, 30% at 2R and 20% at 3R. An empty string
+// means no targets.
+func ParseTakeProfitTargets(s string) ([]TakeProfitTarget, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	var targets []TakeProfitTarget
+	for _, part := range strings.Split(s, ",") {
+		r, pct, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid take profit %q: expected R:percent", part)
+		}
+		rMultiple, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(r), "R"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid take profit R multiple %q", r)
+		}
+		sizePercent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(pct), "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid take profit size %q", pct)
+		}
+		targets = append(targets, TakeProfitTarget{RMultiple: rMultiple, SizePercent: sizePercent})
+	}
+
+	if err := ValidateTakeProfitTargets(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// ValidateTakeProfitTargets checks every target is beyond entry and the
+// sizes add up to at most 100%
+func ValidateTakeProfitTargets(targets []TakeProfitTarget) error {
+	var total float64
+	for _, t := range targets {
+		if t.RMultiple <= 0 {
+			return errors.New("take profit R multiple must be positive")
+		}
+		if t.SizePercent <= 0 {
+			return errors.New("take profit size must be positive")
+		}
+		total += t.SizePercent
+	}
+	if total > 100+1e-9 {
+		return fmt.Errorf("take profit sizes add up to %.0f%%, must be at most 100%%", total)
+	}
+	return nil
+}
+
+// FormatTakeProfitTargets formats targets in the form accepted by
+// ParseTakeProfitTargets
+func FormatTakeProfitTargets(targets []TakeProfitTarget) string {
+	parts := make([]string, 0, len(targets))
+	for _, t := range targets {
+		parts = append(parts, fmt.Sprintf("%g:%g", t.RMultiple, t.SizePercent))
+	}
+	return strings.Join(parts, ",")
+}
+
+// CalculateTakeProfits resolves targets to prices and quantities for a
+// position of quantity entered at entryPrice with its stop at stopLossPrice.
+// Level quantities are rounded down to the instrument's lot step and prices
+// to its tick towards the entry; when the sizes add up to 100% the last
+// level takes the remainder so the whole position is exited.
+func (rc *RiskCalculator) CalculateTakeProfits(inst models.Instrument, entryPrice, stopLossPrice, quantity decimal.Decimal, targets []TakeProfitTarget) ([]TakeProfitLevel, error) {
+	if !entryPrice.IsPositive() || !stopLossPrice.IsPositive() || !quantity.IsPositive() {
+		return nil, errors.New("all input values must be positive")
+	}
+	if entryPrice.Equal(stopLossPrice) {
+		return nil, errors.New("entry price cannot be equal to stop loss price")
+	}
+	if err := ValidateTakeProfitTargets(targets); err != nil {
+		return nil, err
+	}
+
+	inst = gridOf(inst)
+
+	// Positive for longs, negative for shorts
+	risk := entryPrice.Sub(stopLossPrice)
+	towardsEntry := models.RoundFloor
+	if risk.IsNegative() {
+		towardsEntry = models.RoundCeil
+	}
+
+	var totalPercent float64
+	for _, t := range targets {
+		totalPercent += t.SizePercent
+	}
+
+	allocated := decimal.Zero
+	levels := make([]TakeProfitLevel, 0, len(targets))
+	for i, t := range targets {
+		qty := inst.RoundQuantity(percentOf(quantity, t.SizePercent))
+		if i == len(targets)-1 && math.Abs(totalPercent-100) < 1e-9 {
+			qty = quantity.Sub(allocated)
+		}
+		if qty.LessThan(inst.MinQuantity) || !qty.IsPositive() {
+			return nil, fmt.Errorf("take profit at %gR is below the minimum quantity %s", t.RMultiple, inst.MinQuantity)
+		}
+		allocated = allocated.Add(qty)
+
+		price := inst.RoundPrice(entryPrice.Add(rate(t.RMultiple).Mul(risk)), towardsEntry)
+		if !price.IsPositive() {
+			return nil, fmt.Errorf("take profit at %gR is below zero", t.RMultiple)
+		}
+		levels = append(levels, TakeProfitLevel{
+			RMultiple:   t.RMultiple,
+			SizePercent: t.SizePercent,
+			Price:       price,
+			Quantity:    qty,
+			Reward:      inst.Notional(qty, price.Sub(entryPrice).Abs()),
+		})
+	}
+	return levels, nil
+}
+
+// ExpectedRewardRisk returns the R multiple earned if every level fills,
+// weighted by size
+func ExpectedRewardRisk(levels []TakeProfitLevel) float64 {
+	var r float64
+	for _, l := range levels {
+		r += l.RMultiple * l.SizePercent / 100
+	}
+	return r
+}
@@ -0,0 +1,602 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
+)
+
+// DefaultStopSyncInterval is how often trailing stops are moved up to the
+// price and take profit fills are checked for a move to breakeven
+const DefaultStopSyncInterval = time.Second
+
+var (
+	// errStopNotImproved means a stop move would have loosened the stop
+	errStopNotImproved = errors.New("stop not improved")
+	// errNotPastBreakeven means the price has not moved far enough for a
+	// breakeven stop
+	errNotPastBreakeven = errors.New("price has not moved past breakeven")
+	// errStopUnknown means the stop order of a position cannot be
+	// identified, so placing another could leave it resting
+	errStopUnknown = errors.New("stop order not identified")
+)
+
+// StopConfig holds the settings of stop management
+type StopConfig struct {
+	// Costs sets the fees covered by a breakeven stop
+	Costs risk_calculator.CostModel
+
+	// BreakevenOnTakeProfit moves the stop to breakeven once the first
+	// take profit of a trade fills
+	BreakevenOnTakeProfit bool
+
+	// NativeTrailing places percent and fixed distance trailing stops as
+	// exchange trailing orders instead of moving the stop client-side. ATR
+	// trailing is always client-side.
+	NativeTrailing bool
+
+	// ATRPeriod bars of ATRInterval make up the average true range
+	ATRPeriod   int
+	ATRInterval time.Duration
+}
+
+// DefaultStopConfig returns a 14 period ATR of one minute bars, moving to
+// breakeven after the first take profit
+func DefaultStopConfig() StopConfig {
+	return StopConfig{
+		BreakevenOnTakeProfit: true,
+		ATRPeriod:             14,
+		ATRInterval:           time.Minute,
+	}
+}
+
+// ProtectiveStop is the stop loss protecting a position
+type ProtectiveStop struct {
+	Symbol   string
+	Side     models.OrderSide // of the stop order, opposite the position
+	Quantity decimal.Decimal
+	Price    decimal.Decimal
+	OrderID  string // exchange order ID, empty until a stop is placed
+
+	// Trailing is how the stop follows the price, zero for a fixed stop.
+	// A native trailing stop is moved by the exchange and Price is where
+	// it started.
+	Trailing  risk_calculator.TrailingStop
+	Native    bool
+	Breakeven bool // moved to breakeven
+}
+
+// managedStop is the state kept per symbol
+type managedStop struct {
+	ProtectiveStop
+	entry         decimal.Decimal
+	takeProfits   []string // exchange IDs linked one-cancels-other with the stop
+	autoBreakeven bool     // waiting for a take profit to fill
+	opened        bool     // the position has been seen open
+	best          decimal.Decimal
+}
+
+// StopManager moves the stop losses of open positions: to breakeven on
+// request or once the first take profit fills, and after the price while
+// trailing. Stops only ever move in the position's favour. Stop orders are
+// placed, amended and cancelled through the command queue, so they go
+// ahead of new entries and are journaled and retried like any order.
+type StopManager struct {
+	orderService OrderServicer
+	positions    *PositionService
+	commands     *CommandQueue
+	orders       *OrderStateManager // the queue's
+	cfg          StopConfig
+
+	opMu  sync.Mutex // serialises stop changes on the exchange
+	mu    sync.Mutex
+	stops map[string]*managedStop
+	atrs  map[string]*marketdata.ATR
+	last  map[string]decimal.Decimal
+}
+
+// NewStopManager creates a manager for the stops of the positions tracked
+// by positions. Stop orders are queued on commands, a queue already started
+// whose state manager is kept in sync with the exchange, so it reports
+// take profit fills.
+func NewStopManager(orderService OrderServicer, positions *PositionService, commands *CommandQueue, cfg StopConfig) *StopManager {
+	return &StopManager{
+		orderService: orderService,
+		positions:    positions,
+		commands:     commands,
+		orders:       commands.stateManager,
+		cfg:          cfg,
+		stops:        make(map[string]*managedStop),
+		atrs:         make(map[string]*marketdata.ATR),
+		last:         make(map[string]decimal.Decimal),
+	}
+}
+
+// Track takes over the stop loss and take profits placed for a trade. A
+// trade without a stop loss is only tracked on a symbol with no managed
+// stop, so that moving its stop to breakeven or trailing places the first.
+func (m *StopManager) Track(result TradeResult) {
+	side := models.OrderSide(result.Side)
+	m.mu.Lock()
+	if _, managed := m.stops[result.Symbol]; managed && result.StopLossOrderID == "" {
+		m.mu.Unlock()
+		return
+	}
+	m.stops[result.Symbol] = &managedStop{
+		ProtectiveStop: ProtectiveStop{
+			Symbol:   result.Symbol,
+			Side:     side.Opposite(),
+			Quantity: result.Quantity,
+			Price:    result.StopLossPrice,
+			OrderID:  result.StopLossOrderID,
+		},
+		entry:         result.EntryPrice,
+		takeProfits:   result.TakeProfitOrderIDs,
+		autoBreakeven: m.cfg.BreakevenOnTakeProfit && len(result.TakeProfitOrderIDs) > 0,
+	}
+	m.mu.Unlock()
+	m.positions.publish()
+}
+
+// Forget stops managing the stop of the position on symbol, e.g. once the
+// position is closed. A stop move in progress is let finish first. The stop
+// order itself is left resting.
+func (m *StopManager) Forget(symbol string) {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+	m.mu.Lock()
+	delete(m.stops, symbol)
+	m.mu.Unlock()
+	m.positions.publish()
+}
+
+// Restore takes over the stops of open positions from the orders tracked
+// at startup, i.e. recovered from the order journal. An open reduce-only
+// stop order is a position's stop, and reduce-only limit orders on the same
+// side its take profits. A symbol with several open stops is left alone,
+// as is one whose stop is untracked, e.g. placed by hand: its stop cannot
+// be moved without leaving the other resting.
+func (m *StopManager) Restore() {
+	stops := make(map[string][]*AtomicOrder)
+	takeProfits := make(map[string][]*AtomicOrder)
+	for _, o := range m.orders.GetAllOrders() {
+		if o.IsTerminal() || !o.ReduceOnly || o.GetExchangeOrderID() == "" {
+			continue
+		}
+		switch o.Type {
+		case models.OrderTypeStopMarket, models.OrderTypeTrailingStop:
+			stops[o.Symbol] = append(stops[o.Symbol], o)
+		case models.OrderTypeLimit:
+			takeProfits[o.Symbol] = append(takeProfits[o.Symbol], o)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for symbol, orders := range stops {
+		if _, managed := m.stops[symbol]; managed {
+			continue
+		}
+		if len(orders) > 1 {
+			log.Printf("Stop %s: %d open stop orders, none will be moved", symbol, len(orders))
+			continue
+		}
+		o := orders[0]
+		stop := &managedStop{
+			ProtectiveStop: ProtectiveStop{
+				Symbol:   symbol,
+				Side:     o.Side,
+				Quantity: o.Quantity.Sub(o.GetFilledQuantity()),
+				Price:    o.StopPrice,
+				OrderID:  o.GetExchangeOrderID(),
+				Native:   o.Type == models.OrderTypeTrailingStop,
+			},
+		}
+		for _, tp := range takeProfits[symbol] {
+			if tp.Side == o.Side {
+				stop.takeProfits = append(stop.takeProfits, tp.GetExchangeOrderID())
+			}
+		}
+		stop.autoBreakeven = m.cfg.BreakevenOnTakeProfit && len(stop.takeProfits) > 0
+		m.stops[symbol] = stop
+	}
+}
+
+// Stop returns the stop protecting the position on symbol
+func (m *StopManager) Stop(symbol string) (ProtectiveStop, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stop, exists := m.stops[symbol]; exists && stop.OrderID != "" {
+		return stop.ProtectiveStop, true
+	}
+	return ProtectiveStop{}, false
+}
+
+// ApplyMarketData follows last traded prices for the average true range
+// and client-side trailing stops
+func (m *StopManager) ApplyMarketData(u marketdata.Update) {
+	price := u.Ticker.LastPrice
+	if u.Channel != marketdata.ChannelTicker || !price.IsPositive() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	atr, exists := m.atrs[u.Symbol]
+	if !exists {
+		atr = marketdata.NewATR(m.cfg.ATRPeriod, m.cfg.ATRInterval)
+		m.atrs[u.Symbol] = atr
+	}
+	atr.Add(price, u.Timestamp)
+	m.last[u.Symbol] = price
+
+	if stop, exists := m.stops[u.Symbol]; exists && !stop.Trailing.IsZero() && favours(stop.Side, price, stop.best) {
+		stop.best = price
+	}
+}
+
+// Run checks trailing stops and take profit fills at interval until ctx is
+// done
+func (m *StopManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Sync()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync forgets the stops of closed positions, moves stops to breakeven
+// after the first take profit and trails client-side stops after the price
+func (m *StopManager) Sync() {
+	open := make(map[string]PositionSnapshot)
+	for _, p := range m.positions.Snapshot().Positions {
+		open[p.Symbol] = p
+	}
+
+	type move struct {
+		symbol    string
+		breakeven bool
+	}
+	var moves []move
+	m.mu.Lock()
+	for symbol, stop := range m.stops {
+		if _, isOpen := open[symbol]; isOpen {
+			stop.opened = true
+		} else if stop.opened {
+			delete(m.stops, symbol)
+			continue
+		}
+		if stop.autoBreakeven && m.takeProfitFilled(stop) {
+			stop.autoBreakeven = false
+			moves = append(moves, move{symbol: symbol, breakeven: true})
+		} else if !stop.Trailing.IsZero() && !stop.Native && stop.best.IsPositive() {
+			moves = append(moves, move{symbol: symbol})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, mv := range moves {
+		var err error
+		if mv.breakeven {
+			var stop ProtectiveStop
+			stop, err = m.MoveToBreakeven(mv.symbol)
+			switch {
+			case err == nil:
+				log.Printf("Take profit filled, %s stop moved to breakeven at %s", mv.symbol, stop.Price)
+			case errors.Is(err, errNotPastBreakeven):
+				// The price pulled back after the fill, try again later
+				m.mu.Lock()
+				if stop, exists := m.stops[mv.symbol]; exists {
+					stop.autoBreakeven = true
+				}
+				m.mu.Unlock()
+				continue
+			}
+		} else {
+			err = m.trail(mv.symbol)
+		}
+		if err != nil && !errors.Is(err, errStopNotImproved) && !errors.Is(err, risk_calculator.ErrATRNotReady) {
+			log.Printf("Stop %s: %v", mv.symbol, err)
+		}
+	}
+}
+
+// takeProfitFilled reports whether a take profit of a trade has filled,
+// the nearest being the first to. The caller must hold m.mu.
+func (m *StopManager) takeProfitFilled(stop *managedStop) bool {
+	for _, id := range stop.takeProfits {
+		if order, exists := m.orders.GetOrderByExchangeID(id); exists && order.GetState() == OrderStateFilled {
+			return true
+		}
+	}
+	return false
+}
+
+// MoveToBreakeven moves the stop of the position on symbol to the entry
+// price plus the fees paid to open and close it. The price must already be
+// past that level.
+func (m *StopManager) MoveToBreakeven(symbol string) (ProtectiveStop, error) {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	stop, position, err := m.protect(symbol)
+	if err != nil {
+		return ProtectiveStop{}, err
+	}
+	inst, err := m.orderService.GetInstrument(symbol)
+	if err != nil {
+		return ProtectiveStop{}, fmt.Errorf("failed to get instrument: %w", err)
+	}
+
+	entry := position.EntryPrice
+	if !entry.IsPositive() {
+		entry = stop.entry
+	}
+	breakeven := risk_calculator.BreakevenPrice(inst, string(stop.Side.Opposite()), entry, m.cfg.Costs)
+	if price := m.price(symbol, position); !favours(stop.Side, price, breakeven) {
+		return ProtectiveStop{}, fmt.Errorf("%w: %s at %s, breakeven %s", errNotPastBreakeven, symbol, price, breakeven)
+	}
+	if err := m.moveTo(stop, breakeven); err != nil {
+		if errors.Is(err, errStopNotImproved) {
+			return ProtectiveStop{}, fmt.Errorf("%w: stop %s is already past breakeven %s", err, stop.Price, breakeven)
+		}
+		return ProtectiveStop{}, err
+	}
+
+	m.mu.Lock()
+	stop.Breakeven = true
+	result := stop.ProtectiveStop
+	m.mu.Unlock()
+	m.positions.publish()
+	return result, nil
+}
+
+// Trail makes the stop of the position on symbol follow the price at the
+// given distance, starting from the current price. The stop is moved at
+// once if that tightens it.
+func (m *StopManager) Trail(symbol string, trail risk_calculator.TrailingStop) (ProtectiveStop, error) {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	stop, position, err := m.protect(symbol)
+	if err != nil {
+		return ProtectiveStop{}, err
+	}
+	inst, err := m.orderService.GetInstrument(symbol)
+	if err != nil {
+		return ProtectiveStop{}, fmt.Errorf("failed to get instrument: %w", err)
+	}
+
+	price := m.price(symbol, position)
+	target, err := trail.StopPrice(inst, string(stop.Side.Opposite()), price, m.atr(symbol))
+	if err != nil {
+		return ProtectiveStop{}, err
+	}
+	// Never loosen the stop, start trailing from the tighter of the two
+	if stop.Price.IsPositive() && !favours(stop.Side, target, stop.Price) {
+		target = stop.Price
+	}
+
+	if m.cfg.NativeTrailing && trail.Mode != risk_calculator.TrailATR {
+		distance, _ := trail.Distance(price, decimal.Zero)
+		distance = inst.RoundPrice(distance, models.RoundCeil)
+		trade := models.NewTrailingStopTrade(symbol, stop.Side, stop.Quantity, target, distance)
+		if err := m.replace(stop, trade); err != nil {
+			return ProtectiveStop{}, err
+		}
+		m.mu.Lock()
+		stop.Native = true
+	} else {
+		switch {
+		case stop.Native || stop.OrderID == "":
+			// The exchange would keep trailing a native trailing stop,
+			// replace it with a plain one
+			err = m.replace(stop, models.NewStopMarketTrade(symbol, stop.Side, stop.Quantity, target))
+		case !target.Equal(stop.Price):
+			err = m.moveTo(stop, target)
+		}
+		if err != nil {
+			return ProtectiveStop{}, err
+		}
+		m.mu.Lock()
+		stop.Native = false
+		stop.best = price
+	}
+	stop.Trailing = trail
+	result := stop.ProtectiveStop
+	m.mu.Unlock()
+	m.positions.publish()
+	return result, nil
+}
+
+// trail moves a client-side trailing stop after the best price seen
+func (m *StopManager) trail(symbol string) error {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	m.mu.Lock()
+	stop, exists := m.stops[symbol]
+	if !exists || stop.Trailing.IsZero() || stop.Native {
+		m.mu.Unlock()
+		return nil
+	}
+	trail, best := stop.Trailing, stop.best
+	m.mu.Unlock()
+
+	inst, err := m.orderService.GetInstrument(symbol)
+	if err != nil {
+		return fmt.Errorf("failed to get instrument: %w", err)
+	}
+	target, err := trail.StopPrice(inst, string(stop.Side.Opposite()), best, m.atr(symbol))
+	if err != nil {
+		return err
+	}
+	if err := m.moveTo(stop, target); err != nil {
+		return err
+	}
+	m.positions.publish()
+	return nil
+}
+
+// protect returns the managed stop of the open position on symbol. It
+// fails with errStopUnknown for a position whose stop the manager neither
+// placed nor restored, e.g. one opened elsewhere: moving it would place a
+// second stop and leave the first resting. The caller must hold m.opMu.
+func (m *StopManager) protect(symbol string) (*managedStop, PositionSnapshot, error) {
+	position, found := findPosition(m.positions.Snapshot(), symbol)
+	if !found {
+		return nil, PositionSnapshot{}, fmt.Errorf("%w in %s", ErrNoPosition, symbol)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stop, exists := m.stops[symbol]
+	if !exists {
+		return nil, PositionSnapshot{}, fmt.Errorf("%w for %s, move it on the exchange", errStopUnknown, symbol)
+	}
+	if (stop.Side == models.SideSell) != position.IsLong() {
+		return nil, PositionSnapshot{}, fmt.Errorf("%w for %s, the stop is on the side of the position", errStopUnknown, symbol)
+	}
+	stop.opened = true
+	return stop, position, nil
+}
+
+// moveTo amends the stop to price, or places it if there is no stop order
+// yet. It fails with errStopNotImproved unless price tightens the stop. The
+// caller must hold m.opMu.
+func (m *StopManager) moveTo(stop *managedStop, price decimal.Decimal) error {
+	if stop.Price.IsPositive() && !favours(stop.Side, price, stop.Price) {
+		return errStopNotImproved
+	}
+	if stop.OrderID == "" {
+		return m.replace(stop, models.NewStopMarketTrade(stop.Symbol, stop.Side, stop.Quantity, price))
+	}
+	order, tracked := m.orders.GetOrderByExchangeID(stop.OrderID)
+	if !tracked {
+		return fmt.Errorf("%w: %s is not tracked", errStopUnknown, stop.OrderID)
+	}
+	// Modify commands carry the new quantity and price, the stop price
+	// for a stop order
+	trade := order.Trade
+	trade.Quantity, trade.Price = stop.Quantity, price
+	if err := amendQueued(m.commands, order.ID, trade); err != nil {
+		return fmt.Errorf("failed to move stop to %s: %w", price, err)
+	}
+	m.mu.Lock()
+	stop.Price = price
+	m.mu.Unlock()
+	return nil
+}
+
+// replace places trade as the new stop and then cancels the old one, so
+// the position is never left without a stop. The open take profits are
+// linked one-cancels-other with the new stop. The caller must hold m.opMu.
+func (m *StopManager) replace(stop *managedStop, trade models.Trade) error {
+	var old *AtomicOrder
+	if stop.OrderID != "" {
+		var tracked bool
+		if old, tracked = m.orders.GetOrderByExchangeID(stop.OrderID); !tracked {
+			return fmt.Errorf("%w: %s is not tracked", errStopUnknown, stop.OrderID)
+		}
+	}
+
+	inst, err := m.orderService.GetInstrument(stop.Symbol)
+	if err != nil {
+		return fmt.Errorf("failed to get instrument: %w", err)
+	}
+	cmd := OrderCommand{
+		Type:       CommandPlaceOrder,
+		Trade:      trade,
+		Instrument: inst,
+		OrderID:    generateOrderID(),
+		Timestamp:  time.Now(),
+	}
+	err = m.commands.Enqueue(cmd)
+	if err == nil {
+		_, err = waitForAccepted(m.commands, cmd.OrderID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to place stop: %w", err)
+	}
+	placed, _ := m.commands.GetOrder(cmd.OrderID)
+	orderID := placed.GetExchangeOrderID()
+
+	if exits := m.openTakeProfits(stop); len(exits) > 0 {
+		if err := m.orderService.LinkOCO(append([]string{orderID}, exits...)...); err != nil {
+			log.Printf("Stop %s: linking take profits to the new stop failed: %v", stop.Symbol, err)
+		}
+	}
+	if old != nil {
+		if err := cancelQueued(m.commands, old.ID); err != nil {
+			// Both are reduce-only, the old stop can only close the position
+			log.Printf("Stop %s: cancelling the replaced stop %s failed: %v", stop.Symbol, stop.OrderID, err)
+		}
+	}
+
+	m.mu.Lock()
+	stop.OrderID = orderID
+	stop.Price = trade.StopPrice
+	m.mu.Unlock()
+	return nil
+}
+
+// openTakeProfits returns the take profits of a stop that have not reached
+// a terminal state, as far as the tracked orders tell
+func (m *StopManager) openTakeProfits(stop *managedStop) []string {
+	var open []string
+	for _, id := range stop.takeProfits {
+		if order, exists := m.orders.GetOrderByExchangeID(id); exists && order.IsTerminal() {
+			continue
+		}
+		open = append(open, id)
+	}
+	return open
+}
+
+// price returns the last traded price of symbol, the mark price until one
+// has streamed
+func (m *StopManager) price(symbol string, position PositionSnapshot) decimal.Decimal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, exists := m.last[symbol]; exists {
+		return last
+	}
+	return position.MarkPrice
+}
+
+// atr returns the average true range of symbol, zero until a bar closes
+func (m *StopManager) atr(symbol string) decimal.Decimal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if atr, exists := m.atrs[symbol]; exists {
+		if value, ok := atr.Value(); ok {
+			return value
+		}
+	}
+	return decimal.Zero
+}
+
+// favours reports whether price is a tighter stop than other for a stop
+// order on side, i.e. higher for a sell stop protecting a long
+func favours(side models.OrderSide, price, other decimal.Decimal) bool {
+	if side == models.SideSell {
+		return price.GreaterThan(other)
+	}
+	return other.IsZero() || price.LessThan(other)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
+)
+
+// fakeOrderService serves default instruments. Methods it does not
+// override panic on the nil embedded OrderServicer.
+type fakeOrderService struct {
+	OrderServicer
+}
+
+func (fakeOrderService) GetInstrument(symbol string) (models.Instrument, error) {
+	return models.DefaultInstrument(symbol), nil
+}
+
+// stopRecord is a reduce-only sell stop at 90 resting as exchangeOrderID
+func stopRecord(id, exchangeOrderID string) OrderRecord {
+	trade := models.NewStopMarketTrade("BTC/USDT", models.SideSell, dec("1"), dec("90"))
+	trade.ClientOrderID = id
+	return OrderRecord{ID: id, ExchangeOrderID: exchangeOrderID, Trade: trade, State: OrderStateActive}
+}
+
+func TestStopMovesGoThroughTheQueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []OrderRecord
+		err     error
+	}{
+		{"restored stop is amended", []OrderRecord{stopRecord("ORD-S", "EX-S")}, nil},
+		{"unknown stop is not moved", nil, errStopUnknown},
+		{"one of several stops is not moved", []OrderRecord{stopRecord("ORD-S", "EX-S"), stopRecord("ORD-T", "EX-T")}, errStopUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewOrderStateManager()
+			for _, r := range tt.records {
+				m.RestoreOrder(r)
+			}
+			executor := &fakeExecutor{}
+			q := startQueue(t, m, executor)
+
+			// A long of 1 from 100, the price since up at 110
+			positions := NewPositionService(nil, risk_calculator.NewRiskCalculator())
+			positions.ApplyFill(models.Fill{Symbol: "BTC/USDT", Side: string(models.SideBuy), Quantity: dec("1"), Price: dec("100")})
+			stops := NewStopManager(fakeOrderService{}, positions, q, StopConfig{})
+			stops.ApplyMarketData(marketdata.Update{Symbol: "BTC/USDT", Channel: marketdata.ChannelTicker, Timestamp: time.Now(), Ticker: marketdata.Ticker{LastPrice: dec("110")}})
+			stops.Restore()
+
+			stop, err := stops.MoveToBreakeven("BTC/USDT")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("MoveToBreakeven error = %v, want %v", err, tt.err)
+			}
+
+			executor.mu.Lock()
+			defer executor.mu.Unlock()
+			if len(executor.placed) != 0 {
+				t.Errorf("%d stops placed, want the stop amended or left alone", len(executor.placed))
+			}
+			if tt.err != nil {
+				if len(executor.modified) != 0 {
+					t.Errorf("stop amended to %v, want it left alone", executor.modified)
+				}
+				return
+			}
+			if len(executor.modified) != 1 || !executor.modified[0].Equal(dec("100")) {
+				t.Errorf("stop amended to %v, want 100", executor.modified)
+			}
+			if stop.OrderID != "EX-S" || !stop.Price.Equal(dec("100")) || !stop.Breakeven {
+				t.Errorf("stop = %+v, want EX-S at breakeven 100", stop)
+			}
+		})
+	}
+}
@@ -8,7 +8,11 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
 	"github.com/sub0xdai/n0xtilus/internal/ui/styles"
+	"github.com/sub0xdai/n0xtilus/internal/validation"
 )
 
 type InputStep int
@@ -18,12 +22,41 @@ const (
 	StepEntryPrice
 	StepStopLoss
 	StepLeverage
+	StepTakeProfit
 	StepConfirmation
 	StepComplete
 )
 
+// RiskSettings holds the account risk configuration used to size trades
+type RiskSettings struct {
+	RiskPercent float64
+	Costs       risk_calculator.CostModel
+	MarginMode  risk_calculator.MarginMode
+	Tiers       []risk_calculator.MaintenanceTier
+
+	// AutoLeverage pre-fills the lowest leverage that keeps the liquidation
+	// price LiquidationBuffer percent beyond the stop
+	AutoLeverage      bool
+	LiquidationBuffer float64
+
+	// TakeProfits pre-fills the scaled exit targets of new trades
+	TakeProfits []risk_calculator.TakeProfitTarget
+
+	// Instrument looks up the trading rules of a pair, nil for the
+	// default 8 decimal grid
+	Instrument func(symbol string) (models.Instrument, error)
+}
+
+// maxLeverage is the highest leverage accepted in trade entry
+const maxLeverage = 100
+
 type TradeInputWidget struct {
-	pairs       []string
+	picker      *PairPicker
+	pair        string
+	balance     decimal.Decimal
+	riskCalc    risk_calculator.RiskCalculatorService
+	settings    RiskSettings
+	suggestion  string
 	currentStep InputStep
 	inputs      []textinput.Model
 	err         error
@@ -34,8 +67,12 @@ type TradeInputWidget struct {
 	summary     *OrderSummary
 }
 
-func NewTradeInputWidget(pairs []string) *TradeInputWidget {
-	inputs := make([]textinput.Model, 4)
+// NewTradeInputWidget creates the trade entry widget. The pair is picked
+// from pairs by fuzzy search with favourites listed first. Position size is
+// calculated from the account balance so that a stop out, including the
+// trading costs, loses settings.RiskPercent of it.
+func NewTradeInputWidget(pairs, favourites []string, balance decimal.Decimal, riskCalc risk_calculator.RiskCalculatorService, settings RiskSettings) *TradeInputWidget {
+	inputs := make([]textinput.Model, 5)
 	for i := range inputs {
 		t := textinput.New()
 		t.Cursor.Style = lipgloss.NewStyle().Foreground(styles.Sky)
@@ -45,15 +82,21 @@ func NewTradeInputWidget(pairs []string) *TradeInputWidget {
 		inputs[i] = t
 	}
 
-	inputs[0].Placeholder = "Enter number (1-5)"
+	inputs[0].Placeholder = "Type to search, e.g. eth"
 	inputs[1].Placeholder = "0.00"
 	inputs[2].Placeholder = "0.00"
-	inputs[3].Placeholder = "1-100"
+	inputs[3].Placeholder = fmt.Sprintf("1-%d", maxLeverage)
+	inputs[4].Placeholder = "1:50,2:30,3:20"
+	inputs[4].CharLimit = 64
+	inputs[4].SetValue(risk_calculator.FormatTakeProfitTargets(settings.TakeProfits))
 
 	inputs[0].Focus()
 
 	return &TradeInputWidget{
-		pairs:       pairs,
+		picker:      NewPairPicker(pairs, favourites),
+		balance:     balance,
+		riskCalc:    riskCalc,
+		settings:    settings,
 		currentStep: StepPair,
 		inputs:      inputs,
 		tradeInfo:   make(map[string]string),
@@ -78,16 +121,25 @@ func (m *TradeInputWidget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.Type {
 		case tea.KeyCtrlC, tea.KeyEsc:
 			return m, tea.Quit
+		case tea.KeyUp, tea.KeyDown, tea.KeyCtrlP, tea.KeyCtrlN:
+			if m.currentStep == StepPair {
+				if msg.Type == tea.KeyUp || msg.Type == tea.KeyCtrlP {
+					m.picker.MoveUp()
+				} else {
+					m.picker.MoveDown()
+				}
+				return m, nil
+			}
 		case tea.KeyEnter:
 			if m.currentStep == StepConfirmation {
 				switch msg.String() {
 				case "y", "Y":
 					m.Confirmed = true
 					m.currentStep = StepComplete
-					return m, tea.Quit
+					return m, nil
 				case "n", "N":
 					m.currentStep = StepComplete
-					return m, tea.Quit
+					return m, nil
 				default:
 					return m, nil
 				}
@@ -104,10 +156,10 @@ func (m *TradeInputWidget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "y", "Y":
 				m.Confirmed = true
 				m.currentStep = StepComplete
-				return m, tea.Quit
+				return m, nil
 			case "n", "N":
 				m.currentStep = StepComplete
-				return m, tea.Quit
+				return m, nil
 			}
 		}
 		return m, nil
@@ -117,6 +169,9 @@ func (m *TradeInputWidget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.currentStep < StepConfirmation {
 		var cmd tea.Cmd
 		m.inputs[m.currentStep], cmd = m.inputs[m.currentStep].Update(msg)
+		if m.currentStep == StepPair {
+			m.picker.Filter(m.inputs[StepPair].Value())
+		}
 		return m, cmd
 	}
 
@@ -125,79 +180,248 @@ func (m *TradeInputWidget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m *TradeInputWidget) nextStep() tea.Cmd {
 	switch m.currentStep {
-	case StepPair, StepEntryPrice, StepStopLoss:
+	case StepPair:
+		pair, ok := m.picker.Selected()
+		if !ok {
+			m.err = fmt.Errorf("no pair matches %q", m.inputs[StepPair].Value())
+			return nil
+		}
+		m.err = nil
+		m.pair = pair
+		m.inputs[StepPair].SetValue(pair)
+		m.currentStep++
+		return m.inputs[m.currentStep].Focus()
+	case StepEntryPrice, StepStopLoss:
 		m.currentStep++
+		if m.currentStep == StepLeverage && m.settings.AutoLeverage {
+			m.suggestLeverage()
+		}
 		return m.inputs[m.currentStep].Focus()
 	case StepLeverage:
 		if err := m.validateInputs(); err != nil {
 			m.err = err
 			return nil
 		}
+		m.err = nil
+		m.currentStep = StepTakeProfit
+		m.inputs[StepTakeProfit].CursorEnd()
+		return m.inputs[StepTakeProfit].Focus()
+	case StepTakeProfit:
+		if err := m.calculateTradeInfo(); err != nil {
+			m.err = err
+			return nil
+		}
+		m.err = nil
 		m.currentStep = StepConfirmation
-		m.calculateTradeInfo()
 	}
 	return nil
 }
 
 func (m *TradeInputWidget) validateInputs() error {
 	// Validate pair selection
-	pairNum, err := strconv.Atoi(m.inputs[0].Value())
-	if err != nil || pairNum < 1 || pairNum > len(m.pairs) {
-		return fmt.Errorf("invalid pair selection: must be between 1 and %d", len(m.pairs))
+	if m.pair == "" {
+		return fmt.Errorf("no pair selected")
 	}
 
 	// Validate entry price
-	if _, err := strconv.ParseFloat(m.inputs[1].Value(), 64); err != nil {
+	if _, err := decimal.NewFromString(m.inputs[1].Value()); err != nil {
 		return fmt.Errorf("invalid entry price: must be a number")
 	}
 
 	// Validate stop loss
-	if _, err := strconv.ParseFloat(m.inputs[2].Value(), 64); err != nil {
+	if _, err := decimal.NewFromString(m.inputs[2].Value()); err != nil {
 		return fmt.Errorf("invalid stop loss: must be a number")
 	}
 
 	// Validate leverage
 	leverage, err := strconv.ParseFloat(m.inputs[3].Value(), 64)
-	if err != nil || leverage <= 0 || leverage > 100 {
-		return fmt.Errorf("invalid leverage: must be between 1 and 100")
+	if err != nil || leverage <= 0 || leverage > maxLeverage {
+		return fmt.Errorf("invalid leverage: must be between 1 and %d", maxLeverage)
 	}
 
 	return nil
 }
 
-func (m *TradeInputWidget) calculateTradeInfo() {
-	pairIdx, _ := strconv.Atoi(m.inputs[0].Value())
-	entryPrice, _ := strconv.ParseFloat(m.inputs[1].Value(), 64)
-	stopLoss, _ := strconv.ParseFloat(m.inputs[2].Value(), 64)
+// instrument returns the trading rules of the selected pair
+func (m *TradeInputWidget) instrument() (models.Instrument, error) {
+	pair := m.pair
+	if pair == "" {
+		return models.Instrument{}, fmt.Errorf("no pair selected")
+	}
+	if m.settings.Instrument == nil {
+		return models.DefaultInstrument(pair), nil
+	}
+	inst, err := m.settings.Instrument(pair)
+	if err != nil {
+		return models.Instrument{}, fmt.Errorf("failed to get %s trading rules: %w", pair, err)
+	}
+	return inst, nil
+}
+
+// suggestLeverage pre-fills the leverage input with the lowest leverage
+// that funds the position, leaving the user free to override it
+func (m *TradeInputWidget) suggestLeverage() {
+	m.suggestion = ""
+
+	entryPrice, err := decimal.NewFromString(m.inputs[1].Value())
+	if err != nil {
+		return
+	}
+	stopLoss, err := decimal.NewFromString(m.inputs[2].Value())
+	if err != nil {
+		return
+	}
+	inst, err := m.instrument()
+	if err != nil {
+		return
+	}
+	entryPrice = inst.RoundPrice(entryPrice, models.RoundHalfEven)
+	stopLoss = inst.RoundPrice(stopLoss, models.RoundHalfEven)
+
+	sizing, err := m.riskCalc.CalculatePositionSizeWithCosts(risk_calculator.SizingParams{
+		AccountBalance: m.balance,
+		RiskPercentage: m.settings.RiskPercent,
+		EntryPrice:     entryPrice,
+		StopLossPrice:  stopLoss,
+		Costs:          m.settings.Costs,
+		Instrument:     inst,
+	})
+	if err != nil {
+		return
+	}
+
+	side := "BUY"
+	if stopLoss.GreaterThan(entryPrice) {
+		side = "SELL"
+	}
+	leverage, err := m.riskCalc.SuggestLeverage(risk_calculator.LeverageParams{
+		Side:              side,
+		EntryPrice:        entryPrice,
+		StopLossPrice:     stopLoss,
+		Quantity:          sizing.Quantity,
+		AvailableMargin:   m.balance,
+		MaxLeverage:       maxLeverage,
+		LiquidationBuffer: m.settings.LiquidationBuffer,
+		Mode:              m.settings.MarginMode,
+		Tiers:             m.settings.Tiers,
+		Instrument:        inst,
+	})
+	if err != nil {
+		m.suggestion = fmt.Sprintf("No safe leverage: %v", err)
+		return
+	}
+
+	m.inputs[3].SetValue(strconv.FormatFloat(leverage, 'f', -1, 64))
+	m.inputs[3].CursorEnd()
+	m.suggestion = fmt.Sprintf("Suggested %.0fx: lowest that fits your balance with a %.1f%% liquidation buffer", leverage, m.settings.LiquidationBuffer)
+}
+
+func (m *TradeInputWidget) calculateTradeInfo() error {
+	entryPrice, _ := decimal.NewFromString(m.inputs[1].Value())
+	stopLoss, _ := decimal.NewFromString(m.inputs[2].Value())
 	leverage, _ := strconv.ParseFloat(m.inputs[3].Value(), 64)
 
-	// Calculate risk and position size (example values)
-	riskAmount := 100.0 // This should come from your risk calculator
-	position := 0.5     // This should come from your position size calculator
+	inst, err := m.instrument()
+	if err != nil {
+		return err
+	}
+	if inst.MaxLeverage > 0 && leverage > inst.MaxLeverage {
+		return fmt.Errorf("invalid leverage: %s allows at most %gx", inst.Symbol, inst.MaxLeverage)
+	}
+
+	// Orders are placed on the tick grid, show and size the prices sent
+	entryPrice = inst.RoundPrice(entryPrice, models.RoundHalfEven)
+	stopLoss = inst.RoundPrice(stopLoss, models.RoundHalfEven)
+	if entryPrice.Equal(stopLoss) {
+		return fmt.Errorf("entry and stop loss round to the same price, tick size is %s", inst.TickSize)
+	}
+	m.inputs[1].SetValue(entryPrice.String())
+	m.inputs[2].SetValue(stopLoss.String())
+
+	// Calculate risk and position size
+	sizing, err := m.riskCalc.CalculatePositionSizeWithCosts(risk_calculator.SizingParams{
+		AccountBalance: m.balance,
+		RiskPercentage: m.settings.RiskPercent,
+		EntryPrice:     entryPrice,
+		StopLossPrice:  stopLoss,
+		Costs:          m.settings.Costs,
+		Instrument:     inst,
+	})
+	if err != nil {
+		return fmt.Errorf("position size calculation failed: %w", err)
+	}
+	riskAmount := sizing.RiskAmount
+	position := sizing.Quantity
+	if err := validation.ValidateSize(inst, position, entryPrice); err != nil {
+		return fmt.Errorf("%w, increase risk or tighten stop", err)
+	}
+
+	marginRequired := inst.Notional(position, entryPrice).Div(decimal.NewFromFloat(leverage))
+	if marginRequired.GreaterThan(m.balance) {
+		return fmt.Errorf("insufficient balance: margin $%s exceeds balance $%s, increase leverage or widen stop", marginRequired.StringFixed(2), m.balance.StringFixed(2))
+	}
+
+	side := "BUY"
+	if stopLoss.GreaterThan(entryPrice) {
+		side = "SELL"
+	}
+	liquidationPrice, err := m.riskCalc.CalculateLiquidationPrice(risk_calculator.LiquidationParams{
+		Side:           side,
+		EntryPrice:     entryPrice,
+		Quantity:       position,
+		Leverage:       leverage,
+		Mode:           m.settings.MarginMode,
+		AccountBalance: m.balance,
+		Tiers:          m.settings.Tiers,
+		Instrument:     inst,
+	})
+	if err != nil {
+		return fmt.Errorf("liquidation price calculation failed: %w", err)
+	}
+	if err := validation.ValidateStopBeforeLiquidation(stopLoss, liquidationPrice, side); err != nil {
+		return err
+	}
+
+	targets, err := m.GetTakeProfits()
+	if err != nil {
+		return err
+	}
+	var takeProfits []risk_calculator.TakeProfitLevel
+	if len(targets) > 0 {
+		takeProfits, err = m.riskCalc.CalculateTakeProfits(inst, entryPrice, stopLoss, position, targets)
+		if err != nil {
+			return fmt.Errorf("take profit calculation failed: %w", err)
+		}
+	}
 
 	// Update order summary
 	m.summary.Update(
-		m.pairs[pairIdx-1],
+		m.pair,
 		entryPrice,
 		stopLoss,
 		leverage,
 		riskAmount,
 		position,
 	)
+	m.summary.SetInstrument(inst)
+	m.summary.SetCosts(sizing.TotalCosts())
+	m.summary.SetLiquidationPrice(liquidationPrice)
+	m.summary.SetTakeProfits(takeProfits)
 
 	// Keep the old trade info for backward compatibility
 	m.tradeInfo = map[string]string{
-		"Pair":        m.pairs[pairIdx-1],
-		"Entry Price": fmt.Sprintf("%.2f", entryPrice),
-		"Stop Loss":   fmt.Sprintf("%.2f", stopLoss),
+		"Pair":        m.pair,
+		"Entry Price": entryPrice.StringFixed(2),
+		"Stop Loss":   stopLoss.StringFixed(2),
 		"Leverage":    fmt.Sprintf("%.1fx", leverage),
 		"Direction":   func() string {
-			if entryPrice > stopLoss {
+			if entryPrice.GreaterThan(stopLoss) {
 				return "LONG"
 			}
 			return "SHORT"
 		}(),
 	}
+	return nil
 }
 
 func (m *TradeInputWidget) View() string {
@@ -223,11 +447,11 @@ func (m *TradeInputWidget) View() string {
 	case StepPair:
 		content = append(content, "  Select trading pair:")
 		content = append(content, "")
-		for i, pair := range m.pairs {
-			content = append(content, fmt.Sprintf("    %d. %s", i+1, styles.PairStyle.Render(pair)))
-		}
-		content = append(content, "")
 		content = append(content, fmt.Sprintf("  > %s", m.inputs[0].View()))
+		content = append(content, "")
+		content = append(content, m.picker.View())
+		content = append(content, "")
+		content = append(content, styles.InfoStyle.Render("  ↑/↓ move, enter select, ★ favourite"))
 
 	case StepEntryPrice:
 		content = append(content, "  Enter entry price:")
@@ -240,9 +464,20 @@ func (m *TradeInputWidget) View() string {
 		content = append(content, fmt.Sprintf("  > %s", m.inputs[2].View()))
 
 	case StepLeverage:
-		content = append(content, "  Enter leverage (1-100x):")
+		content = append(content, fmt.Sprintf("  Enter leverage (1-%dx):", maxLeverage))
 		content = append(content, "")
 		content = append(content, fmt.Sprintf("  > %s", m.inputs[3].View()))
+		if m.suggestion != "" {
+			content = append(content, "")
+			content = append(content, styles.InfoStyle.Render(fmt.Sprintf("  %s", m.suggestion)))
+		}
+
+	case StepTakeProfit:
+		content = append(content, "  Enter take profits as R:percent (blank for none):")
+		content = append(content, "")
+		content = append(content, fmt.Sprintf("  > %s", m.inputs[4].View()))
+		content = append(content, "")
+		content = append(content, styles.InfoStyle.Render("  e.g. 1:50,2:30,3:20 exits 50% at 1R, 30% at 2R, 20% at 3R"))
 
 	case StepConfirmation:
 		// Use the new order summary widget
@@ -268,28 +503,45 @@ func (m *TradeInputWidget) IsComplete() bool {
 	return m.currentStep == StepComplete
 }
 
-func (m *TradeInputWidget) GetInputs() (int, float64, float64, float64, error) {
-	pairNum, err := strconv.Atoi(m.inputs[0].Value())
-	if err != nil || pairNum < 1 || pairNum > len(m.pairs) {
-		return 0, 0, 0, 0, fmt.Errorf("invalid pair selection: must be between 1 and %d", len(m.pairs))
+// GetPair returns the selected trading pair
+func (m *TradeInputWidget) GetPair() (string, error) {
+	if m.pair == "" {
+		return "", fmt.Errorf("no pair selected")
+	}
+	return m.pair, nil
+}
+
+func (m *TradeInputWidget) GetInputs() (string, decimal.Decimal, decimal.Decimal, float64, error) {
+	pair, err := m.GetPair()
+	if err != nil {
+		return "", decimal.Zero, decimal.Zero, 0, err
 	}
 
-	entry, err := strconv.ParseFloat(m.inputs[1].Value(), 64)
+	entry, err := decimal.NewFromString(m.inputs[1].Value())
 	if err != nil {
-		return 0, 0, 0, 0, fmt.Errorf("invalid entry price: %v", err)
+		return "", decimal.Zero, decimal.Zero, 0, fmt.Errorf("invalid entry price: %v", err)
 	}
 
-	stopLoss, err := strconv.ParseFloat(m.inputs[2].Value(), 64)
+	stopLoss, err := decimal.NewFromString(m.inputs[2].Value())
 	if err != nil {
-		return 0, 0, 0, 0, fmt.Errorf("invalid stop loss: %v", err)
+		return "", decimal.Zero, decimal.Zero, 0, fmt.Errorf("invalid stop loss: %v", err)
 	}
 
 	leverage, err := strconv.ParseFloat(m.inputs[3].Value(), 64)
-	if err != nil || leverage <= 0 || leverage > 100 {
-		return 0, 0, 0, 0, fmt.Errorf("invalid leverage: must be between 1 and 100")
+	if err != nil || leverage <= 0 || leverage > maxLeverage {
+		return "", decimal.Zero, decimal.Zero, 0, fmt.Errorf("invalid leverage: must be between 1 and %d", maxLeverage)
 	}
 
-	return pairNum - 1, entry, stopLoss, leverage, nil
+	return pair, entry, stopLoss, leverage, nil
+}
+
+// GetTakeProfits returns the take profit targets entered, nil for none
+func (m *TradeInputWidget) GetTakeProfits() ([]risk_calculator.TakeProfitTarget, error) {
+	targets, err := risk_calculator.ParseTakeProfitTargets(m.inputs[4].Value())
+	if err != nil {
+		return nil, fmt.Errorf("invalid take profits: %w", err)
+	}
+	return targets, nil
 }
 
 func min(a, b int) int {
@@ -4,6 +4,8 @@ import (
     "fmt"
     "strings"
     "github.com/charmbracelet/lipgloss"
+    "github.com/shopspring/decimal"
+    "github.com/sub0xdai/n0xtilus/internal/models"
     "github.com/sub0xdai/n0xtilus/internal/ui/theme"
 )
 
@@ -12,9 +14,13 @@ type OrderResult struct {
     OrderID          string
     Side            string
     Pair            string
-    Amount          float64
-    Price           float64
-    AvailableMargin float64
+    Amount          decimal.Decimal
+    Price           decimal.Decimal
+    AvailableMargin decimal.Decimal
+    StopLossID      string
+    TakeProfitIDs   []string
+    Warnings        []string
+    Err             error
     width           int
 }
 
@@ -31,17 +37,35 @@ func (o *OrderResult) SetWidth(w int) {
 }
 
 // Update updates the order result with new information
-func (o *OrderResult) Update(orderID, side, pair string, amount, price, margin float64) {
+func (o *OrderResult) Update(orderID, side, pair string, amount, price, margin decimal.Decimal) {
     o.OrderID = orderID
     o.Side = side
     o.Pair = pair
     o.Amount = amount
     o.Price = price
     o.AvailableMargin = margin
+    o.Err = nil
+}
+
+// SetExits records the protective orders placed with the entry and any
+// warnings raised placing them
+func (o *OrderResult) SetExits(stopLossID string, takeProfitIDs, warnings []string) {
+    o.StopLossID = stopLossID
+    o.TakeProfitIDs = takeProfitIDs
+    o.Warnings = warnings
+}
+
+// SetError marks the order as failed with the given error
+func (o *OrderResult) SetError(pair string, err error) {
+    o.Pair = pair
+    o.Err = err
 }
 
 // View renders the order result
 func (o *OrderResult) View() string {
+    if o.Err != nil {
+        return o.errorView()
+    }
     if o.OrderID == "" {
         return ""
     }
@@ -65,6 +89,10 @@ func (o *OrderResult) View() string {
     dividerStyle := lipgloss.NewStyle().
         Foreground(theme.Overlay0)
 
+    warningStyle := lipgloss.NewStyle().
+        Foreground(theme.Yellow).
+        Width(o.width - 4)
+
     // Build the result view
     var s strings.Builder
 
@@ -75,15 +103,22 @@ func (o *OrderResult) View() string {
     s.WriteString("\n\n")
 
     // Order details
-    details := []struct {
+    type detail struct {
         label string
         value string
-    }{
+    }
+    details := []detail{
         {"Order ID", o.OrderID},
         {"Type", fmt.Sprintf("%s %s", strings.ToUpper(o.Side), o.Pair)},
-        {"Amount", fmt.Sprintf("%.8f %s", o.Amount, strings.Split(o.Pair, "/")[0])},
-        {"Price", fmt.Sprintf("%.2f %s", o.Price, strings.Split(o.Pair, "/")[1])},
-        {"Total", fmt.Sprintf("%.2f %s", o.Amount*o.Price, strings.Split(o.Pair, "/")[1])},
+        {"Amount", fmt.Sprintf("%s %s", o.Amount.StringFixed(models.QuantityDecimals), strings.Split(o.Pair, "/")[0])},
+        {"Price", fmt.Sprintf("%s %s", models.FormatDecimal(o.Price, 2), strings.Split(o.Pair, "/")[1])},
+        {"Total", fmt.Sprintf("%s %s", models.FormatDecimal(o.Amount.Mul(o.Price), 2), strings.Split(o.Pair, "/")[1])},
+    }
+    if o.StopLossID != "" {
+        details = append(details, detail{"Stop ID", o.StopLossID})
+    }
+    for i, id := range o.TakeProfitIDs {
+        details = append(details, detail{fmt.Sprintf("TP%d ID", i+1), id})
     }
 
     for _, d := range details {
@@ -104,10 +139,15 @@ func (o *OrderResult) View() string {
     marginInfo := lipgloss.JoinHorizontal(
         lipgloss.Left,
         labelStyle.Render("Available:"),
-        marginStyle.Render(fmt.Sprintf("$%.2f", o.AvailableMargin)),
+        marginStyle.Render(formatUSD(o.AvailableMargin)),
     )
     s.WriteString(marginInfo)
 
+    for _, warning := range o.Warnings {
+        s.WriteString("\n")
+        s.WriteString(warningStyle.Render("! " + warning))
+    }
+
     // Wrap in a box
     boxStyle := lipgloss.NewStyle().
         Border(lipgloss.RoundedBorder()).
@@ -117,3 +157,36 @@ func (o *OrderResult) View() string {
 
     return boxStyle.Render(s.String())
 }
+
+// errorView renders a failed order
+func (o *OrderResult) errorView() string {
+    errorStyle := lipgloss.NewStyle().
+        Foreground(theme.Red).
+        Bold(true)
+
+    textStyle := lipgloss.NewStyle().
+        Foreground(theme.Text).
+        Width(o.width - 4)
+
+    dividerStyle := lipgloss.NewStyle().
+        Foreground(theme.Overlay0)
+
+    var s strings.Builder
+    title := "Order Failed"
+    if o.Pair != "" {
+        title = fmt.Sprintf("%s: %s", title, o.Pair)
+    }
+    s.WriteString(errorStyle.Render(title))
+    s.WriteString("\n")
+    s.WriteString(dividerStyle.Render(strings.Repeat("─", o.width-4)))
+    s.WriteString("\n\n")
+    s.WriteString(textStyle.Render(o.Err.Error()))
+
+    boxStyle := lipgloss.NewStyle().
+        Border(lipgloss.RoundedBorder()).
+        BorderForeground(theme.Red).
+        Padding(1).
+        Width(o.width)
+
+    return boxStyle.Render(s.String())
+}
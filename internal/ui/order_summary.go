@@ -4,18 +4,27 @@ import (
     "fmt"
     "strings"
     "github.com/charmbracelet/lipgloss"
+    "github.com/shopspring/decimal"
+    "github.com/sub0xdai/n0xtilus/internal/models"
+    "github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
     "github.com/sub0xdai/n0xtilus/internal/ui/styles"
 )
 
 // OrderSummary represents an order summary widget
 type OrderSummary struct {
     Pair        string
-    EntryPrice  float64
-    StopLoss    float64
+    EntryPrice  decimal.Decimal
+    StopLoss    decimal.Decimal
     Leverage    float64
     Direction   string
-    RiskAmount  float64
-    Position    float64
+    RiskAmount  decimal.Decimal
+    Position    decimal.Decimal
+    Notional    decimal.Decimal
+    Margin      decimal.Decimal
+    StopPercent decimal.Decimal
+    Costs       decimal.Decimal
+    Liquidation decimal.Decimal
+    TakeProfits []risk_calculator.TakeProfitLevel
     width       int
 }
 
@@ -32,21 +41,64 @@ func (o *OrderSummary) SetWidth(w int) {
 }
 
 // Update updates the order summary with new information
-func (o *OrderSummary) Update(pair string, entry, stop, leverage float64, risk, pos float64) {
+func (o *OrderSummary) Update(pair string, entry, stop decimal.Decimal, leverage float64, risk, pos decimal.Decimal) {
     o.Pair = pair
     o.EntryPrice = entry
     o.StopLoss = stop
     o.Leverage = leverage
     o.RiskAmount = risk
     o.Position = pos
+    o.Notional = pos.Mul(entry)
+    o.Margin = o.Notional.Div(decimal.NewFromFloat(leverage))
+    o.StopPercent = entry.Sub(stop).Abs().Div(entry).Mul(decimal.NewFromInt(100))
     o.Direction = func() string {
-        if entry > stop {
+        if entry.GreaterThan(stop) {
             return "LONG"
         }
         return "SHORT"
     }()
 }
 
+// SetInstrument applies the pair's contract multiplier to the notional
+// and margin
+func (o *OrderSummary) SetInstrument(inst models.Instrument) {
+    o.Notional = inst.Notional(o.Position, o.EntryPrice)
+    o.Margin = o.Notional.Div(decimal.NewFromFloat(o.Leverage))
+}
+
+// SetCosts sets the fees and slippage included in the risk amount
+func (o *OrderSummary) SetCosts(costs decimal.Decimal) {
+    o.Costs = costs
+}
+
+// SetLiquidationPrice sets the estimated liquidation price, 0 for none
+func (o *OrderSummary) SetLiquidationPrice(price decimal.Decimal) {
+    o.Liquidation = price
+}
+
+// SetTakeProfits sets the scaled exit levels, nil for none
+func (o *OrderSummary) SetTakeProfits(levels []risk_calculator.TakeProfitLevel) {
+    o.TakeProfits = levels
+}
+
+// RewardRisk returns the profit if every take profit fills divided by the
+// all-in risk amount
+func (o *OrderSummary) RewardRisk() decimal.Decimal {
+    if o.RiskAmount.IsZero() {
+        return decimal.Zero
+    }
+    return o.reward().Div(o.RiskAmount)
+}
+
+// reward returns the profit if every take profit fills
+func (o *OrderSummary) reward() decimal.Decimal {
+    reward := decimal.Zero
+    for _, tp := range o.TakeProfits {
+        reward = reward.Add(tp.Reward)
+    }
+    return reward
+}
+
 // View renders the order summary
 func (o *OrderSummary) View() string {
     if o.Pair == "" {
@@ -68,13 +120,19 @@ func (o *OrderSummary) View() string {
     // Entry price
     content = append(content, fmt.Sprintf("  %s %s",
         styles.LabelStyle.Render("Entry:"),
-        styles.ValueStyle.Render(fmt.Sprintf("$%.2f", o.EntryPrice)),
+        styles.ValueStyle.Render(formatUSD(o.EntryPrice)),
     ))
 
     // Stop loss
     content = append(content, fmt.Sprintf("  %s %s",
         styles.LabelStyle.Render("Stop Loss:"),
-        styles.ValueStyle.Render(fmt.Sprintf("$%.2f", o.StopLoss)),
+        styles.ValueStyle.Render(formatUSD(o.StopLoss)),
+    ))
+
+    // Distance to stop
+    content = append(content, fmt.Sprintf("  %s %s",
+        styles.LabelStyle.Render("Stop Dist:"),
+        styles.ValueStyle.Render(o.StopPercent.StringFixed(2) + "%"),
     ))
 
     // Leverage
@@ -83,21 +141,73 @@ func (o *OrderSummary) View() string {
         styles.ValueStyle.Render(fmt.Sprintf("%.0fx", o.Leverage)),
     ))
 
+    // Liquidation price
+    liquidation := "none"
+    if o.Liquidation.IsPositive() {
+        liquidation = formatUSD(o.Liquidation)
+    }
+    content = append(content, fmt.Sprintf("  %s %s",
+        styles.LabelStyle.Render("Liq Price:"),
+        styles.RiskStyle.Render(liquidation),
+    ))
+
     content = append(content, "")
 
     // Position size
     content = append(content, fmt.Sprintf("  %s %s",
         styles.LabelStyle.Render("Position:"),
-        styles.ValueStyle.Render(fmt.Sprintf("%.4f %s", o.Position, strings.Split(o.Pair, "/")[0])),
+        styles.ValueStyle.Render(fmt.Sprintf("%s %s", o.Position.String(), strings.Split(o.Pair, "/")[0])),
+    ))
+
+    // Notional value
+    content = append(content, fmt.Sprintf("  %s %s",
+        styles.LabelStyle.Render("Notional:"),
+        styles.ValueStyle.Render(formatUSD(o.Notional)),
+    ))
+
+    // Margin required at the chosen leverage
+    content = append(content, fmt.Sprintf("  %s %s",
+        styles.LabelStyle.Render("Margin:"),
+        styles.ValueStyle.Render(formatUSD(o.Margin)),
     ))
 
     // Risk amount
     content = append(content, fmt.Sprintf("  %s %s",
         styles.LabelStyle.Render("Risk:"),
-        styles.RiskStyle.Render(fmt.Sprintf("$%.2f", o.RiskAmount)),
+        styles.RiskStyle.Render(formatUSD(o.RiskAmount)),
     ))
 
+    // Fees and slippage included in the risk
+    content = append(content, fmt.Sprintf("  %s %s",
+        styles.LabelStyle.Render("Costs:"),
+        styles.ValueStyle.Render(formatUSD(o.Costs) + " incl. in risk"),
+    ))
+
+    // Scaled exits and the reward if they all fill
+    if len(o.TakeProfits) > 0 {
+        content = append(content, "")
+        for i, tp := range o.TakeProfits {
+            content = append(content, fmt.Sprintf("  %s %s",
+                styles.LabelStyle.Render(fmt.Sprintf("TP%d:", i+1)),
+                styles.ValueStyle.Render(fmt.Sprintf("%s at %gR, %g%% (%s)", formatUSD(tp.Price), tp.RMultiple, tp.SizePercent, tp.Quantity.String())),
+            ))
+        }
+        content = append(content, fmt.Sprintf("  %s %s",
+            styles.LabelStyle.Render("Reward:"),
+            styles.PnLPositiveStyle.Render(formatUSD(o.reward())),
+        ))
+        content = append(content, fmt.Sprintf("  %s %s",
+            styles.LabelStyle.Render("R:R:"),
+            styles.ValueStyle.Render(fmt.Sprintf("%s (%.2fR before costs)", o.RewardRisk().StringFixed(2), risk_calculator.ExpectedRewardRisk(o.TakeProfits))),
+        ))
+    }
+
     return styles.BoxStyle.Copy().
         BorderStyle(lipgloss.NormalBorder()).
         Render(strings.Join(content, "\n"))
 }
+
+// formatUSD formats an amount in dollars and cents, rounding half to even
+func formatUSD(amount decimal.Decimal) string {
+    return "$" + models.FormatDecimal(amount, 2)
+}
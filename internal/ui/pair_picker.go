@@ -0,0 +1,170 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sub0xdai/n0xtilus/internal/ui/styles"
+)
+
+// maxVisiblePairs is how many matches the picker lists at once
+const maxVisiblePairs = 10
+
+// PairPicker filters a list of pairs by a fuzzy query, with favourites
+// pinned above the other matches
+type PairPicker struct {
+	pairs      []string
+	favourites map[string]int // pair to its position in the favourites list
+	matches    []string
+	cursor     int
+}
+
+// NewPairPicker creates a picker over pairs. Favourites the exchange does
+// not list are ignored.
+func NewPairPicker(pairs, favourites []string) *PairPicker {
+	p := &PairPicker{
+		pairs:      append([]string(nil), pairs...),
+		favourites: make(map[string]int, len(favourites)),
+	}
+	sort.Strings(p.pairs)
+	for i, fav := range favourites {
+		if _, exists := p.favourites[fav]; !exists {
+			p.favourites[fav] = i
+		}
+	}
+	p.Filter("")
+	return p
+}
+
+// Filter narrows the list to pairs matching query and moves the cursor to
+// the best match
+func (p *PairPicker) Filter(query string) {
+	type match struct {
+		pair  string
+		score int
+	}
+
+	var matches []match
+	for _, pair := range p.pairs {
+		if score, ok := fuzzyScore(query, pair); ok {
+			matches = append(matches, match{pair, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		aFav, aIsFav := p.favourites[a.pair]
+		bFav, bIsFav := p.favourites[b.pair]
+		if aIsFav != bIsFav {
+			return aIsFav
+		}
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if aIsFav {
+			return aFav < bFav
+		}
+		// Prefer the tightest match, otherwise keep alphabetical order
+		return a.score > 0 && len(a.pair) < len(b.pair)
+	})
+
+	p.matches = p.matches[:0]
+	for _, m := range matches {
+		p.matches = append(p.matches, m.pair)
+	}
+	p.cursor = 0
+}
+
+// MoveUp moves the cursor to the previous match
+func (p *PairPicker) MoveUp() {
+	if p.cursor > 0 {
+		p.cursor--
+	}
+}
+
+// MoveDown moves the cursor to the next match
+func (p *PairPicker) MoveDown() {
+	if p.cursor < len(p.matches)-1 {
+		p.cursor++
+	}
+}
+
+// Selected returns the pair under the cursor, false if nothing matches
+func (p *PairPicker) Selected() (string, bool) {
+	if len(p.matches) == 0 {
+		return "", false
+	}
+	return p.matches[p.cursor], true
+}
+
+// Len returns the number of pairs matching the current query
+func (p *PairPicker) Len() int {
+	return len(p.matches)
+}
+
+// View renders the matches around the cursor, one per line
+func (p *PairPicker) View() string {
+	if len(p.matches) == 0 {
+		return styles.EmptyStyle.Render("    No matching pairs")
+	}
+
+	// Scroll so the cursor stays in view
+	start := 0
+	if p.cursor >= maxVisiblePairs {
+		start = p.cursor - maxVisiblePairs + 1
+	}
+	end := min(start+maxVisiblePairs, len(p.matches))
+
+	var lines []string
+	for i := start; i < end; i++ {
+		pair := p.matches[i]
+		marker := " "
+		if _, fav := p.favourites[pair]; fav {
+			marker = "★"
+		}
+		if i == p.cursor {
+			lines = append(lines, fmt.Sprintf("  > %s %s", marker, styles.PairStyle.Render(pair)))
+		} else {
+			lines = append(lines, fmt.Sprintf("    %s %s", marker, pair))
+		}
+	}
+	if len(p.matches) > end-start {
+		lines = append(lines, styles.InfoStyle.Render(fmt.Sprintf("    %d of %d pairs", len(p.matches), len(p.pairs))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fuzzyScore reports whether the letters of query appear in order in
+// candidate, ignoring case, and scores the match. Runs of consecutive
+// letters and letters starting a word, such as the base or quote currency,
+// score higher. An empty query matches everything.
+func fuzzyScore(query, candidate string) (int, bool) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	target := []rune(strings.ToLower(candidate))
+
+	score, pos, prev := 0, 0, -2
+	for _, q := range query {
+		if unicode.IsSpace(q) {
+			continue
+		}
+		for pos < len(target) && target[pos] != q {
+			pos++
+		}
+		if pos == len(target) {
+			return 0, false
+		}
+
+		score++
+		if pos == prev+1 {
+			score += 5
+		}
+		if pos == 0 || !unicode.IsLetter(target[pos-1]) && !unicode.IsDigit(target[pos-1]) {
+			score += 10
+		}
+		prev = pos
+		pos++
+	}
+	return score, true
+}
@@ -0,0 +1,110 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sub0xdai/n0xtilus/internal/services"
+	"github.com/sub0xdai/n0xtilus/internal/ui/theme"
+)
+
+// PositionActionResult shows the outcome of closing, reducing or flipping
+// positions, or moving their stops, from the dashboard
+type PositionActionResult struct {
+	Results []services.PositionActionResult
+	width   int
+}
+
+// NewPositionActionResult creates a result panel for executed actions
+func NewPositionActionResult(results []services.PositionActionResult) *PositionActionResult {
+	return &PositionActionResult{
+		Results: results,
+		width:   60, // default width
+	}
+}
+
+// SetWidth updates the widget width
+func (r *PositionActionResult) SetWidth(w int) {
+	r.width = w
+}
+
+// Failed reports whether any action failed
+func (r *PositionActionResult) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// View renders one line per action
+func (r *PositionActionResult) View() string {
+	successStyle := lipgloss.NewStyle().
+		Foreground(theme.Green).
+		Bold(true)
+
+	errorStyle := lipgloss.NewStyle().
+		Foreground(theme.Red).
+		Bold(true)
+
+	textStyle := lipgloss.NewStyle().
+		Foreground(theme.Text).
+		Width(r.width - 4)
+
+	failedStyle := lipgloss.NewStyle().
+		Foreground(theme.Red).
+		Width(r.width - 4)
+
+	dividerStyle := lipgloss.NewStyle().
+		Foreground(theme.Overlay0)
+
+	var s strings.Builder
+	title, border := successStyle.Render("Positions Updated"), theme.Overlay0
+	if r.Failed() {
+		title, border = errorStyle.Render("Position Action Failed"), theme.Red
+	}
+	s.WriteString(title)
+	s.WriteString("\n")
+	s.WriteString(dividerStyle.Render(strings.Repeat("─", r.width-4)))
+	s.WriteString("\n")
+
+	for _, result := range r.Results {
+		s.WriteString("\n")
+		if result.Err != nil {
+			s.WriteString(failedStyle.Render(fmt.Sprintf("✗ %s %s: %v", actionVerb(result.Action.Kind), result.Action.Symbol, result.Err)))
+			continue
+		}
+		if result.Action.Kind.MovesStop() {
+			s.WriteString(textStyle.Render(fmt.Sprintf("✓ %s %s: stop %s (%s)",
+				actionVerb(result.Action.Kind), result.Action.Symbol, describeStop(result.Stop), strings.Join(result.OrderIDs, ", "))))
+			continue
+		}
+		s.WriteString(textStyle.Render(fmt.Sprintf("✓ %s %s: %s %s %s (%s)",
+			actionVerb(result.Action.Kind), result.Action.Symbol, result.Side, result.Quantity,
+			baseCurrency(result.Action.Symbol), strings.Join(result.OrderIDs, ", "))))
+	}
+
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(border).
+		Padding(1).
+		Width(r.width)
+
+	return boxStyle.Render(s.String())
+}
+
+func actionVerb(kind services.PositionActionKind) string {
+	switch kind {
+	case services.PositionReduce:
+		return "Reduce"
+	case services.PositionFlip:
+		return "Flip"
+	case services.PositionBreakeven:
+		return "Breakeven"
+	case services.PositionTrail:
+		return "Trail"
+	}
+	return "Close"
+}
@@ -5,59 +5,53 @@ import (
 	"strings"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/marketdata"
+	"github.com/sub0xdai/n0xtilus/internal/services"
+	"github.com/sub0xdai/n0xtilus/internal/services/risk_calculator"
 	"github.com/sub0xdai/n0xtilus/internal/ui/styles"
 )
 
 type ExecuteTradeMsg struct{}
 
-type PositionDashboard struct {
-	balance        float64
-	positions      []Position
-	input          string
-	err            string
-	width          int
-	height         int
-	usePlaceholder bool
-	helpVisible    bool
+// PositionActionMsg asks for confirmed actions on open positions to be
+// executed
+type PositionActionMsg struct {
+	Actions []services.PositionAction
 }
 
-type Position struct {
-	Symbol       string
-	Size         float64
-	EntryPrice   float64
-	CurrentPrice float64
-	PnL          float64
+type PositionDashboard struct {
+	portfolio   *services.PortfolioSnapshot // nil until the first snapshot
+	input       string
+	pending     []services.PositionAction // awaiting confirmation
+	prompt      string
+	err         string
+	width       int
+	height      int
+	helpVisible bool
+	feed        *marketdata.Status // nil until the stream reports
 }
 
-func NewPositionDashboard(usePlaceholder bool) *PositionDashboard {
+// NewPositionDashboard creates a dashboard showing the snapshots published
+// by the position service
+func NewPositionDashboard() *PositionDashboard {
 	return &PositionDashboard{
-		width:          80,
-		height:         24,
-		usePlaceholder: usePlaceholder,
-		helpVisible:    true,
+		width:       80,
+		height:      24,
+		helpVisible: true,
 	}
 }
 
 func (d *PositionDashboard) Init() tea.Cmd {
-	if d.usePlaceholder {
-		// Set placeholder data for testing
-		d.balance = 10000.0
-		d.positions = []Position{
-			{
-				Symbol:       "BTC/USD",
-				Size:        0.5,
-				EntryPrice:  50000.0,
-				CurrentPrice: 51000.0,
-				PnL:         500.0,
-			},
-		}
-	}
 	return nil
 }
 
 func (d *PositionDashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if d.pending != nil && msg.Type != tea.KeyCtrlC {
+			return d.confirm(msg)
+		}
 		switch msg.Type {
 		case tea.KeyCtrlC:
 			return d, tea.Quit
@@ -78,6 +72,10 @@ func (d *PositionDashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		d.width = msg.Width
 		d.height = msg.Height
+	case services.PortfolioSnapshot:
+		d.portfolio = &msg
+	case marketdata.Status:
+		d.feed = &msg
 	}
 	return d, nil
 }
@@ -87,6 +85,20 @@ func (d *PositionDashboard) handleCommand() (tea.Model, tea.Cmd) {
 	d.input = ""
 	d.err = ""
 
+	if fields := strings.Fields(cmd); len(fields) > 0 {
+		switch fields[0] {
+		case "close", "reduce", "flip", "closeall", "be", "trail":
+			actions, err := d.parsePositionCommand(fields)
+			if err != nil {
+				d.err = err.Error()
+				return d, nil
+			}
+			d.pending = actions
+			d.prompt = d.describe(actions)
+			return d, nil
+		}
+	}
+
 	switch cmd {
 	case "trade", "t":
 		d.helpVisible = false
@@ -106,7 +118,132 @@ func (d *PositionDashboard) handleCommand() (tea.Model, tea.Cmd) {
 	return d, nil
 }
 
-func (d *PositionDashboard) renderPosition(p Position) string {
+// confirm executes the pending actions on y or enter and drops them on any
+// other key
+func (d *PositionDashboard) confirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	actions := d.pending
+	d.pending = nil
+	d.prompt = ""
+	if msg.Type == tea.KeyEnter || (msg.Type == tea.KeyRunes && strings.EqualFold(msg.String(), "y")) {
+		return d, func() tea.Msg { return PositionActionMsg{Actions: actions} }
+	}
+	d.err = "Cancelled"
+	return d, nil
+}
+
+// parsePositionCommand parses close <symbol>, reduce <symbol> <percent>,
+// flip <symbol>, be <symbol>, trail <symbol> <distance> and closeall
+func (d *PositionDashboard) parsePositionCommand(fields []string) ([]services.PositionAction, error) {
+	if d.portfolio == nil {
+		return nil, fmt.Errorf("positions are still loading")
+	}
+
+	if fields[0] == "closeall" {
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("usage: closeall")
+		}
+		if len(d.portfolio.Positions) == 0 {
+			return nil, fmt.Errorf("no open positions")
+		}
+		actions := make([]services.PositionAction, 0, len(d.portfolio.Positions))
+		for _, p := range d.portfolio.Positions {
+			actions = append(actions, services.PositionAction{Kind: services.PositionClose, Symbol: p.Symbol})
+		}
+		return actions, nil
+	}
+
+	action := services.PositionAction{Kind: services.PositionActionKind(fields[0])}
+	args := 2
+	if action.Kind == services.PositionReduce || action.Kind == services.PositionTrail {
+		args = 3
+	}
+	if len(fields) != args {
+		switch action.Kind {
+		case services.PositionReduce:
+			return nil, fmt.Errorf("usage: reduce <symbol> <percent>")
+		case services.PositionTrail:
+			return nil, fmt.Errorf("usage: trail <symbol> <percent%%|multiple atr|distance>")
+		}
+		return nil, fmt.Errorf("usage: %s <symbol>", action.Kind)
+	}
+
+	position, err := d.findPosition(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	action.Symbol = position.Symbol
+
+	if action.Kind == services.PositionReduce {
+		percent, err := decimal.NewFromString(strings.TrimSuffix(fields[2], "%"))
+		if err != nil || !percent.IsPositive() || percent.GreaterThan(decimal.NewFromInt(100)) {
+			return nil, fmt.Errorf("invalid percentage %q, expected e.g. 50%%", fields[2])
+		}
+		action.Fraction = percent.Shift(-2)
+	}
+	if action.Kind == services.PositionTrail {
+		if action.Trail, err = risk_calculator.ParseTrailingStop(fields[2]); err != nil {
+			return nil, err
+		}
+	}
+	return []services.PositionAction{action}, nil
+}
+
+// findPosition finds the open position on a pair, given in full or by its
+// base currency
+func (d *PositionDashboard) findPosition(query string) (services.PositionSnapshot, error) {
+	var matches []services.PositionSnapshot
+	for _, p := range d.portfolio.Positions {
+		if strings.EqualFold(p.Symbol, query) {
+			return p, nil
+		}
+		if strings.EqualFold(baseCurrency(p.Symbol), query) {
+			matches = append(matches, p)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return services.PositionSnapshot{}, fmt.Errorf("no open position in %s", strings.ToUpper(query))
+	case 1:
+		return matches[0], nil
+	}
+	symbols := make([]string, len(matches))
+	for i, p := range matches {
+		symbols[i] = p.Symbol
+	}
+	return services.PositionSnapshot{}, fmt.Errorf("%s is ambiguous, use one of %s", strings.ToUpper(query), strings.Join(symbols, ", "))
+}
+
+// describe summarises actions for the confirmation prompt
+func (d *PositionDashboard) describe(actions []services.PositionAction) string {
+	lines := make([]string, 0, len(actions)+1)
+	for _, action := range actions {
+		position, _ := d.findPosition(action.Symbol)
+		side := "LONG"
+		if !position.IsLong() {
+			side = "SHORT"
+		}
+		size := fmt.Sprintf("%s %s", position.Size.Abs(), baseCurrency(position.Symbol))
+		switch action.Kind {
+		case services.PositionReduce:
+			lines = append(lines, fmt.Sprintf("Reduce %s %s by %s%% (%s) at market", side, position.Symbol, action.Fraction.Shift(2), size))
+		case services.PositionFlip:
+			reverse := "SHORT"
+			if !position.IsLong() {
+				reverse = "LONG"
+			}
+			lines = append(lines, fmt.Sprintf("Flip %s %s %s to %s at market, without a stop loss", side, size, position.Symbol, reverse))
+		case services.PositionBreakeven:
+			lines = append(lines, fmt.Sprintf("Move the stop of %s %s to breakeven", side, position.Symbol))
+		case services.PositionTrail:
+			lines = append(lines, fmt.Sprintf("Trail the stop of %s %s %s behind the price", side, position.Symbol, describeTrail(action.Trail)))
+		default:
+			lines = append(lines, fmt.Sprintf("Close %s %s %s at market", side, size, position.Symbol))
+		}
+	}
+	return strings.Join(append(lines, "Confirm? (y/n)"), "\n")
+}
+
+func (d *PositionDashboard) renderPosition(p services.PositionSnapshot) string {
 	var lines []string
 
 	// Trading pair header
@@ -119,29 +256,58 @@ func (d *PositionDashboard) renderPosition(p Position) string {
 		Render(styles.PairStyle.Render(p.Symbol))
 	lines = append(lines, pairBox)
 
+	side := "LONG"
+	if !p.IsLong() {
+		side = "SHORT"
+	}
+	liquidation := "none"
+	if p.LiquidationPrice.IsPositive() {
+		liquidation = fmt.Sprintf("$%s (%.2f%% away)", p.LiquidationPrice.StringFixed(2), p.LiquidationDistance)
+	}
+
 	// Position details
 	detailsContent := []string{
 		fmt.Sprintf("%s %s",
 			styles.LabelStyle.Render("Position:"),
-			styles.ValueStyle.Render(fmt.Sprintf("%.4f BTC ($%.2f)", p.Size, p.EntryPrice)),
+			styles.ValueStyle.Render(fmt.Sprintf("%s %s %s @ $%s", side, p.Size.Abs(), baseCurrency(p.Symbol), p.EntryPrice.StringFixed(2))),
+		),
+		"",
+		fmt.Sprintf("%s %s",
+			styles.LabelStyle.Render("Mark:"),
+			styles.ValueStyle.Render(fmt.Sprintf("$%s", p.MarkPrice.StringFixed(2))),
+		),
+		"",
+		fmt.Sprintf("%s %s",
+			styles.LabelStyle.Render("Margin:"),
+			styles.ValueStyle.Render(fmt.Sprintf("$%s (%gx)", p.Margin.StringFixed(2), p.Leverage)),
+		),
+		"",
+		fmt.Sprintf("%s %s",
+			styles.LabelStyle.Render("Stop:"),
+			styles.ValueStyle.Render(describeStop(p.Stop)),
+		),
+		"",
+		fmt.Sprintf("%s %s",
+			styles.LabelStyle.Render("Liquidation:"),
+			styles.ValueStyle.Render(liquidation),
 		),
 		"",
 		fmt.Sprintf("%s %s",
-			styles.LabelStyle.Render("Current:"),
-			styles.ValueStyle.Render(fmt.Sprintf("$%.2f", p.CurrentPrice)),
+			styles.LabelStyle.Render("Funding:"),
+			styles.ValueStyle.Render(fmt.Sprintf("%.4f%%", p.FundingRate*100)),
 		),
 	}
 
 	// PnL with color
 	pnlStyle := styles.PnLPositiveStyle
-	if p.PnL < 0 {
+	if p.UnrealizedPnL.IsNegative() {
 		pnlStyle = styles.PnLNegativeStyle
 	}
 	detailsContent = append(detailsContent,
 		"",
 		fmt.Sprintf("%s %s",
 			styles.LabelStyle.Render("PnL:"),
-			pnlStyle.Render(fmt.Sprintf("$%.2f", p.PnL)),
+			pnlStyle.Render(fmt.Sprintf("$%s (%+.2f%% ROE)", p.UnrealizedPnL.StringFixed(2), p.ROE)),
 		),
 	)
 
@@ -158,14 +324,71 @@ func (d *PositionDashboard) renderPosition(p Position) string {
 	return lipgloss.JoinVertical(lipgloss.Left, lines...)
 }
 
+// describeTrail says how far a trailing stop follows the price
+func describeTrail(trail risk_calculator.TrailingStop) string {
+	switch trail.Mode {
+	case risk_calculator.TrailPercent:
+		return trail.Amount.String() + "%"
+	case risk_calculator.TrailATR:
+		return trail.Amount.String() + "x ATR"
+	}
+	return "$" + trail.Amount.String()
+}
+
+// describeStop summarises the stop loss of a position
+func describeStop(stop services.ProtectiveStop) string {
+	if !stop.Price.IsPositive() {
+		return "none"
+	}
+	description := fmt.Sprintf("$%s", stop.Price.StringFixed(2))
+	switch {
+	case stop.Native:
+		description = fmt.Sprintf("trailing %s from $%s on the exchange", describeTrail(stop.Trailing), stop.Price.StringFixed(2))
+	case !stop.Trailing.IsZero():
+		description += fmt.Sprintf(" trailing %s", describeTrail(stop.Trailing))
+	case stop.Breakeven:
+		description += " (breakeven)"
+	}
+	return description
+}
+
+// baseCurrency returns the currency a pair's size is quoted in, e.g. BTC
+// for BTC/USDT
+func baseCurrency(symbol string) string {
+	for _, sep := range []string{"/", "-", ":"} {
+		if base, _, found := strings.Cut(symbol, sep); found {
+			return base
+		}
+	}
+	return symbol
+}
+
+// feedStatus describes the market data connection
+func (d *PositionDashboard) feedStatus() string {
+	switch {
+	case d.feed == nil:
+		return styles.EmptyStyle.Render("Market data: connecting")
+	case d.feed.Connected:
+		return styles.PnLPositiveStyle.Render("Market data: live")
+	default:
+		return styles.ErrorStyle.Render(fmt.Sprintf("Market data: reconnecting (attempt %d)", d.feed.Attempt))
+	}
+}
+
 func (d *PositionDashboard) View() string {
 	var sections []string
 
 	// Header with balance
+	account := styles.EmptyStyle.Render("Loading account...")
+	if d.portfolio != nil {
+		account = styles.BalanceStyle.Render(fmt.Sprintf("Balance: $%s  Equity: $%s  Unrealised PnL: $%s",
+			d.portfolio.Balance.StringFixed(2), d.portfolio.Equity.StringFixed(2), d.portfolio.UnrealizedPnL.StringFixed(2)))
+	}
 	headerContent := lipgloss.JoinVertical(lipgloss.Center,
 		styles.TitleStyle.Render("Position Dashboard"),
 		"",
-		styles.BalanceStyle.Render(fmt.Sprintf("Balance: $%.2f", d.balance)),
+		account,
+		d.feedStatus(),
 	)
 
 	headerBox := styles.BoxStyle.Copy().
@@ -180,11 +403,13 @@ func (d *PositionDashboard) View() string {
 
 	// Positions section
 	var positionsContent string
-	if len(d.positions) == 0 {
+	if d.portfolio == nil {
+		positionsContent = styles.EmptyStyle.Render("Loading positions...")
+	} else if len(d.portfolio.Positions) == 0 {
 		positionsContent = styles.EmptyStyle.Render("No active positions")
 	} else {
 		var positionSections []string
-		for _, p := range d.positions {
+		for _, p := range d.portfolio.Positions {
 			positionSections = append(positionSections, d.renderPosition(p))
 		}
 		positionsContent = lipgloss.JoinVertical(lipgloss.Left, positionSections...)
@@ -197,6 +422,12 @@ func (d *PositionDashboard) View() string {
 		styles.LabelStyle.Render("Command:"),
 		d.input,
 	)
+	if d.prompt != "" {
+		inputContent = fmt.Sprintf("%s\n%s",
+			inputContent,
+			styles.InfoStyle.Render(d.prompt),
+		)
+	}
 	if d.err != "" {
 		inputContent = fmt.Sprintf("%s\n%s",
 			inputContent,
@@ -219,11 +450,17 @@ func (d *PositionDashboard) View() string {
 		helpContent := []string{
 			"Available Commands:",
 			"",
-			"  trade, t    - Open trade input",
-			"  help, h, ?  - Toggle help",
-			"  clear, c    - Clear messages",
-			"  quit, q     - Exit application",
-			"  ESC         - Clear input",
+			"  trade, t        - Open trade input",
+			"  close BTC       - Close a position at market",
+			"  reduce BTC 50%  - Close part of a position at market",
+			"  flip BTC        - Reverse a position at market",
+			"  be BTC          - Move the stop to breakeven",
+			"  trail BTC 1%    - Trail the stop by 1%, 2atr or a distance",
+			"  closeall        - Close every position at market",
+			"  help, h, ?      - Toggle help",
+			"  clear, c        - Clear messages",
+			"  quit, q         - Exit application",
+			"  ESC             - Clear input",
 		}
 
 		helpBox := styles.BoxStyle.Copy().
@@ -3,38 +3,33 @@ package validation
 import (
 	"errors"
 	"fmt"
-	"math"
-	"strconv"
 	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/sub0xdai/n0xtilus/internal/models"
 )
 
 var (
-	ErrInvalidSymbol     = errors.New("invalid trading symbol")
-	ErrInvalidSide       = errors.New("invalid order side")
-	ErrInvalidQuantity   = errors.New("invalid order quantity")
-	ErrInvalidPrice      = errors.New("invalid order price")
-	ErrInvalidLeverage   = errors.New("invalid leverage")
-	ErrInvalidRisk       = errors.New("invalid risk percentage")
-	ErrInsufficientFunds = errors.New("insufficient funds for order")
+	ErrInvalidSymbol         = errors.New("invalid trading symbol")
+	ErrInvalidSide           = errors.New("invalid order side")
+	ErrInvalidQuantity       = errors.New("invalid order quantity")
+	ErrInvalidPrice          = errors.New("invalid order price")
+	ErrInvalidLeverage       = errors.New("invalid leverage")
+	ErrInvalidRisk           = errors.New("invalid risk percentage")
+	ErrInsufficientFunds     = errors.New("insufficient funds for order")
+	ErrStopBeyondLiquidation = errors.New("stop loss beyond liquidation price")
 )
 
-// OrderValidator provides validation for order parameters
+// OrderValidator provides validation for order parameters. Quantity and
+// price limits come from the instrument of each order.
 type OrderValidator struct {
-	minQuantity float64
-	maxQuantity float64
-	minPrice    float64
-	maxPrice    float64
 	maxLeverage float64
 	maxRisk     float64
 }
 
 // NewOrderValidator creates a new order validator with specified limits
-func NewOrderValidator(minQty, maxQty, minPrice, maxPrice, maxLev, maxRisk float64) *OrderValidator {
+func NewOrderValidator(maxLev, maxRisk float64) *OrderValidator {
 	return &OrderValidator{
-		minQuantity: minQty,
-		maxQuantity: maxQty,
-		minPrice:    minPrice,
-		maxPrice:    maxPrice,
 		maxLeverage: maxLev,
 		maxRisk:     maxRisk,
 	}
@@ -61,53 +56,57 @@ func (v *OrderValidator) ValidateSymbol(symbol string) error {
 }
 
 // ValidateSide checks if the order side is valid
-func (v *OrderValidator) ValidateSide(side string) error {
-	side = strings.ToUpper(side)
-	if side != "BUY" && side != "SELL" {
+func (v *OrderValidator) ValidateSide(side models.OrderSide) error {
+	if side != models.SideBuy && side != models.SideSell {
 		return fmt.Errorf("%w: must be BUY or SELL", ErrInvalidSide)
 	}
 	return nil
 }
 
-// ValidateQuantity checks if the order quantity is valid
-func (v *OrderValidator) ValidateQuantity(quantity string) error {
-	qty, err := strconv.ParseFloat(quantity, 64)
-	if err != nil {
-		return fmt.Errorf("%w: parse error", ErrInvalidQuantity)
+// ValidateQuantity checks the order quantity against the instrument's
+// lot step and limits
+func (v *OrderValidator) ValidateQuantity(quantity decimal.Decimal, inst models.Instrument) error {
+	if quantity.LessThan(inst.MinQuantity) || !quantity.IsPositive() {
+		return fmt.Errorf("%w: quantity must be at least %s", ErrInvalidQuantity, inst.MinQuantity)
 	}
-
-	if qty < v.minQuantity || qty > v.maxQuantity {
-		return fmt.Errorf("%w: quantity must be between %v and %v", 
-			ErrInvalidQuantity, v.minQuantity, v.maxQuantity)
+	if inst.MaxQuantity.IsPositive() && quantity.GreaterThan(inst.MaxQuantity) {
+		return fmt.Errorf("%w: quantity must be at most %s", ErrInvalidQuantity, inst.MaxQuantity)
 	}
 
-	// Check decimal places
-	decimalPlaces := len(strings.Split(quantity, ".")[1])
-	if decimalPlaces > 8 {
-		return fmt.Errorf("%w: maximum 8 decimal places allowed", ErrInvalidQuantity)
+	// Check lot step
+	if !inst.OnStep(quantity) {
+		return fmt.Errorf("%w: quantity must be a multiple of %s", ErrInvalidQuantity, inst.StepSize)
 	}
 
 	return nil
 }
 
-// ValidatePrice checks if the order price is valid
-func (v *OrderValidator) ValidatePrice(price string) error {
-	p, err := strconv.ParseFloat(price, 64)
-	if err != nil {
-		return fmt.Errorf("%w: parse error", ErrInvalidPrice)
+// ValidatePrice checks the order price against the instrument's tick size
+func (v *OrderValidator) ValidatePrice(price decimal.Decimal, inst models.Instrument) error {
+	if !price.IsPositive() {
+		return fmt.Errorf("%w: price must be greater than 0", ErrInvalidPrice)
 	}
 
-	if p < v.minPrice || p > v.maxPrice {
-		return fmt.Errorf("%w: price must be between %v and %v", 
-			ErrInvalidPrice, v.minPrice, v.maxPrice)
+	// Check tick size
+	if !inst.OnTick(price) {
+		return fmt.Errorf("%w: price must be a multiple of %s", ErrInvalidPrice, inst.TickSize)
 	}
 
-	// Check for reasonable price precision
-	decimalPlaces := len(strings.Split(price, ".")[1])
-	if decimalPlaces > 8 {
-		return fmt.Errorf("%w: maximum 8 decimal places allowed", ErrInvalidPrice)
-	}
+	return nil
+}
 
+// ValidateSize checks an order's size against the instrument's minimum
+// value. It needs no order limits so it can be used before an order is
+// built.
+func ValidateSize(inst models.Instrument, quantity, price decimal.Decimal) error {
+	if quantity.LessThan(inst.MinQuantity) || !quantity.IsPositive() {
+		return fmt.Errorf("%w: size %s is below the minimum %s for %s",
+			ErrInvalidQuantity, quantity, inst.MinQuantity, inst.Symbol)
+	}
+	if notional := inst.Notional(quantity, price); notional.LessThan(inst.MinNotional) {
+		return fmt.Errorf("%w: order value %s is below the minimum %s for %s",
+			ErrInvalidQuantity, notional.StringFixed(2), inst.MinNotional, inst.Symbol)
+	}
 	return nil
 }
 
@@ -119,41 +118,76 @@ func (v *OrderValidator) ValidateRisk(riskPercentage float64) error {
 	return nil
 }
 
-// ValidateLeverage checks if the leverage is valid
-func (v *OrderValidator) ValidateLeverage(leverage float64) error {
-	if leverage < 1 || leverage > v.maxLeverage {
-		return fmt.Errorf("%w: must be between 1 and %vx", ErrInvalidLeverage, v.maxLeverage)
+// ValidateLeverage checks if the leverage is valid, capped at the
+// instrument's maximum where it has one
+func (v *OrderValidator) ValidateLeverage(leverage float64, inst models.Instrument) error {
+	maxLeverage := v.maxLeverage
+	if inst.MaxLeverage > 0 && inst.MaxLeverage < maxLeverage {
+		maxLeverage = inst.MaxLeverage
+	}
+	if leverage < 1 || leverage > maxLeverage {
+		return fmt.Errorf("%w: must be between 1 and %vx", ErrInvalidLeverage, maxLeverage)
 	}
 	return nil
 }
 
 // ValidateOrder performs comprehensive order validation
 func (v *OrderValidator) ValidateOrder(order *Order) error {
-	if err := v.ValidateSymbol(order.Symbol); err != nil {
+	trade := order.Trade
+	inst := order.Instrument
+	if inst.IsZero() {
+		inst = models.DefaultInstrument(trade.Symbol)
+	}
+	if err := v.ValidateSymbol(trade.Symbol); err != nil {
 		return err
 	}
-	if err := v.ValidateSide(order.Side); err != nil {
+	if err := v.ValidateSide(trade.Side); err != nil {
 		return err
 	}
-	if err := v.ValidateQuantity(order.Quantity); err != nil {
+	if err := trade.Validate(); err != nil {
 		return err
 	}
-	if err := v.ValidatePrice(order.Price); err != nil {
+	if err := v.ValidateQuantity(trade.Quantity, inst); err != nil {
 		return err
 	}
+	if trade.Price.IsPositive() {
+		if err := v.ValidatePrice(trade.Price, inst); err != nil {
+			return err
+		}
+	}
+	if trade.StopPrice.IsPositive() {
+		if err := v.ValidatePrice(trade.StopPrice, inst); err != nil {
+			return err
+		}
+	}
+	if !trade.ReduceOnly && trade.ReferencePrice().IsPositive() {
+		if err := ValidateSize(inst, trade.Quantity, trade.ReferencePrice()); err != nil {
+			return err
+		}
+	}
+
+	// Reduce-only orders can only shrink a position, they take on no new
+	// risk or margin
+	if trade.ReduceOnly {
+		return nil
+	}
 	if err := v.ValidateRisk(order.RiskPercentage); err != nil {
 		return err
 	}
-	if err := v.ValidateLeverage(order.Leverage); err != nil {
+	if err := v.ValidateLeverage(order.Leverage, inst); err != nil {
 		return err
 	}
+	if order.StopLoss.IsPositive() && order.LiquidationPrice.IsPositive() {
+		if err := v.ValidateLiquidation(order.StopLoss, order.LiquidationPrice, string(trade.Side)); err != nil {
+			return err
+		}
+	}
 
-	// Validate position size against account balance
-	qty, _ := strconv.ParseFloat(order.Quantity, 64)
-	price, _ := strconv.ParseFloat(order.Price, 64)
-	positionSize := qty * price
+	// Validate position size against account balance. Market orders have
+	// no price to check against.
+	positionSize := inst.Notional(trade.Quantity, trade.ReferencePrice())
 
-	if positionSize > order.AccountBalance*order.Leverage {
+	if positionSize.GreaterThan(order.AccountBalance.Mul(decimal.NewFromFloat(order.Leverage))) {
 		return fmt.Errorf("%w: position size exceeds available margin", ErrInsufficientFunds)
 	}
 
@@ -162,36 +196,58 @@ func (v *OrderValidator) ValidateOrder(order *Order) error {
 
 // Order represents the order parameters for validation
 type Order struct {
-	Symbol         string
-	Side           string
-	Quantity       string
-	Price          string
-	RiskPercentage float64
-	Leverage       float64
-	AccountBalance float64
+	Trade            models.Trade
+	Instrument       models.Instrument // zero for the default 8 decimal grid
+	RiskPercentage   float64
+	Leverage         float64
+	AccountBalance   decimal.Decimal
+	StopLoss         decimal.Decimal // optional, checked against LiquidationPrice
+	LiquidationPrice decimal.Decimal // optional
 }
 
 // ValidateStopLoss ensures the stop loss is valid for the position
-func (v *OrderValidator) ValidateStopLoss(entryPrice, stopLoss float64, side string) error {
-	if stopLoss <= 0 {
+func (v *OrderValidator) ValidateStopLoss(entryPrice, stopLoss decimal.Decimal, side string) error {
+	if !stopLoss.IsPositive() {
 		return fmt.Errorf("%w: stop loss must be greater than 0", ErrInvalidPrice)
 	}
 
 	// For long positions, stop loss must be below entry price
-	if side == "BUY" && stopLoss >= entryPrice {
+	if side == "BUY" && stopLoss.GreaterThanOrEqual(entryPrice) {
 		return errors.New("stop loss must be below entry price for long positions")
 	}
 
 	// For short positions, stop loss must be above entry price
-	if side == "SELL" && stopLoss <= entryPrice {
+	if side == "SELL" && stopLoss.LessThanOrEqual(entryPrice) {
 		return errors.New("stop loss must be above entry price for short positions")
 	}
 
 	// Calculate stop loss percentage
-	slPercentage := math.Abs((entryPrice - stopLoss) / entryPrice * 100)
-	if slPercentage > 50 { // Example: max 50% stop loss
+	slPercentage := entryPrice.Sub(stopLoss).Div(entryPrice).Mul(decimal.NewFromInt(100)).Abs()
+	if slPercentage.GreaterThan(decimal.NewFromInt(50)) { // Example: max 50% stop loss
 		return errors.New("stop loss percentage too large")
 	}
 
 	return nil
 }
+
+// ValidateLiquidation ensures the stop loss triggers before the position
+// would be liquidated
+func (v *OrderValidator) ValidateLiquidation(stopLoss, liquidationPrice decimal.Decimal, side string) error {
+	return ValidateStopBeforeLiquidation(stopLoss, liquidationPrice, side)
+}
+
+// ValidateStopBeforeLiquidation checks the stop loss lies between entry and
+// the liquidation price. It needs no order limits so it can be used before
+// an order is built.
+func ValidateStopBeforeLiquidation(stopLoss, liquidationPrice decimal.Decimal, side string) error {
+	side = strings.ToUpper(side)
+	if side == "BUY" && stopLoss.LessThanOrEqual(liquidationPrice) {
+		return fmt.Errorf("%w: stop %s is at or below liquidation %s, lower leverage or tighten stop",
+			ErrStopBeyondLiquidation, stopLoss.StringFixed(2), liquidationPrice.StringFixed(2))
+	}
+	if side == "SELL" && liquidationPrice.IsPositive() && stopLoss.GreaterThanOrEqual(liquidationPrice) {
+		return fmt.Errorf("%w: stop %s is at or above liquidation %s, lower leverage or tighten stop",
+			ErrStopBeyondLiquidation, stopLoss.StringFixed(2), liquidationPrice.StringFixed(2))
+	}
+	return nil
+}